@@ -0,0 +1,59 @@
+"use client";
+
+import { useState, useEffect, useRef } from 'react';
+import { useSearchParams } from 'next/navigation';
+import { portalAPI } from '../../lib/api';
+
+export default function AcceptInvitationPage() {
+  const searchParams = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [message, setMessage] = useState('Accepting your invitation...');
+  const [error, setError] = useState('');
+  const [needsLogin, setNeedsLogin] = useState(false);
+  const requested = useRef(false);
+
+  useEffect(() => {
+    if (!token) {
+      setError('This invitation link is missing its token');
+      return;
+    }
+
+    // Invitations are accepted by the account with the invited email address
+    if (!localStorage.getItem('token')) {
+      setNeedsLogin(true);
+      return;
+    }
+
+    // Tokens work once, so do not accept twice when the effect runs again
+    if (requested.current) return;
+    requested.current = true;
+
+    portalAPI.acceptInvitation(token)
+      .then(() => setMessage('You have joined the team'))
+      .catch((err) => setError(err.message));
+  }, [token]);
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50">
+      <div className="max-w-md w-full p-6 bg-white rounded-lg shadow-lg text-center">
+        <h1 className="text-3xl font-bold mb-6">Accept Invitation</h1>
+
+        {needsLogin ? (
+          <p className="text-sm">
+            Sign in or create an account with the email address the invitation was sent to, then open this link again.
+          </p>
+        ) : error ? (
+          <p className="text-red-500 text-sm">{error}</p>
+        ) : (
+          <p className="text-green-600 text-sm">{message}</p>
+        )}
+
+        <div className="mt-4">
+          <a href={needsLogin ? '/login' : '/dashboard'} className="text-sm text-blue-600 hover:text-blue-500">
+            {needsLogin ? 'Sign in' : 'Go to dashboard'}
+          </a>
+        </div>
+      </div>
+    </div>
+  );
+}
@@ -33,10 +33,13 @@ export default function ChatPage({ params }) {
   
   const fetchConversation = async () => {
     try {
-      const response = await fetch(`http://localhost:3001/api/conversation/public/${conversationId}`);
+      const customerToken = localStorage.getItem(`customer_token_${conversationId}`) || '';
+      const response = await fetch(`http://localhost:3001/api/conversation/public/${conversationId}`, {
+        headers: { 'X-Customer-Token': customerToken },
+      });
       
       if (!response.ok) {
-        if (response.status === 404) {
+        if (response.status === 401 || response.status === 404) {
           router.push('/');
           return;
         }
@@ -102,6 +102,12 @@ export default function ChatWindow({ conversationId, customerId, customerName, i
         if (token) {
           headers['Authorization'] = `Bearer ${token}`;
         }
+      } else {
+        // Customers prove access with the token issued for the conversation
+        const customerToken = localStorage.getItem(`customer_token_${conversationId}`);
+        if (customerToken) {
+          headers['X-Customer-Token'] = customerToken;
+        }
       }
       
       // Use direct fetch to the backend URL - using the public endpoint
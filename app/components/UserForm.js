@@ -28,6 +28,8 @@ export default function UserForm({ portalId, onStartConversation }) {
       const data = await response.json();
       
       if (response.ok) {
+        // Keep the token the conversation's public endpoints require
+        localStorage.setItem(`customer_token_${data.conversation.id}`, data.customerToken);
         setSubmitted(true);
         onStartConversation(data.conversation);
       }
@@ -11,11 +11,12 @@ import {
   listenForMessages,
   onMessage
 } from '../../lib/websocket-client';
+import { apiClient } from '../../lib/utils';
 
 // Backend API URL
 const API_URL = 'http://localhost:3001';
 
-export default function WebSocketChatWindow({ conversationId, customerId, customerName, isOwner = false }) {
+export default function WebSocketChatWindow({ conversationId, customerId, customerName, customerToken, isOwner = false }) {
   const [messages, setMessages] = useState([]);
   const [newMessage, setNewMessage] = useState('');
   const [loading, setLoading] = useState(true);
@@ -104,6 +105,9 @@ export default function WebSocketChatWindow({ conversationId, customerId, custom
         if (token) {
           headers['Authorization'] = `Bearer ${token}`;
         }
+      } else if (customerToken) {
+        // Customers prove access with the token issued when they claimed the conversation
+        headers['X-Customer-Token'] = customerToken;
       }
       
       // Use direct fetch to the backend URL - using the public endpoint
@@ -113,7 +117,13 @@ export default function WebSocketChatWindow({ conversationId, customerId, custom
         : `/api/conversation/public/${conversationId}/messages`;
         
       console.log(`Fetching messages from: ${API_URL}${endpoint}`);
-      const response = await fetch(`${API_URL}${endpoint}`, { headers });
+      let response = await fetch(`${API_URL}${endpoint}`, { headers });
+      
+      // The owner's access token is short-lived; refresh it and try once more
+      if (response.status === 401 && isOwner && await apiClient.refreshTokens()) {
+        headers['Authorization'] = `Bearer ${localStorage.getItem('token')}`;
+        response = await fetch(`${API_URL}${endpoint}`, { headers });
+      }
       
       if (!response.ok) {
         console.error('Error fetching messages:', response.statusText);
@@ -3,7 +3,7 @@
 import { useState, useEffect } from 'react';
 import { useRouter } from 'next/navigation';
 import WebSocketChatWindow from '../../../../components/WebSocketChatWindow';
-import { portalAPI, conversationAPI } from '../../../../../lib/api';
+import { authAPI, portalAPI, conversationAPI } from '../../../../../lib/api';
 
 export default function CategoryChatsPage({ params }) {
   const { portalId, categorySlug } = params;
@@ -137,8 +137,12 @@ export default function CategoryChatsPage({ params }) {
     }
   };
   
-  const handleLogout = () => {
-    localStorage.removeItem('token');
+  const handleLogout = async () => {
+    try {
+      await authAPI.logout();
+    } catch (error) {
+      console.error('Error logging out:', error);
+    }
     router.push('/');
   };
   
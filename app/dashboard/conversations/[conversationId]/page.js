@@ -3,9 +3,7 @@
 import { useState, useEffect, useRef } from 'react';
 import { useRouter } from 'next/navigation';
 import WebSocketChatWindow from '../../../components/WebSocketChatWindow';
-
-// Backend API URL
-const API_URL = 'http://localhost:3001';
+import { conversationAPI } from '../../../../lib/api';
 
 export default function ConversationPage({ params }) {
   const { conversationId } = params;
@@ -26,22 +24,12 @@ export default function ConversationPage({ params }) {
   
   const fetchConversation = async () => {
     try {
-      const token = localStorage.getItem('token');
-      const response = await fetch(`${API_URL}/api/conversations/${conversationId}`, {
-        headers: {
-          'Authorization': `Bearer ${token}`
-        }
-      });
-      
-      if (!response.ok) {
-        if (response.status === 401) {
-          router.push('/dashboard');
-          return;
-        }
-        throw new Error('Failed to fetch conversation');
+      // The API client refreshes an expired access token, or sends the user to log in
+      const data = await conversationAPI.getConversationById(conversationId);
+      if (!data) {
+        return;
       }
       
-      const data = await response.json();
       console.log('Fetched conversation:', data.conversation);
       setConversation(data.conversation);
       setLoading(false);
@@ -63,20 +51,8 @@ export default function ConversationPage({ params }) {
     }
     
     try {
-      const token = localStorage.getItem('token');
-      const response = await fetch(`${API_URL}/api/conversations/${conversationId}`, {
-        method: 'DELETE',
-        headers: {
-          'Authorization': `Bearer ${token}`
-        }
-      });
-      
-      if (response.ok) {
-        router.push('/dashboard');
-      } else {
-        const data = await response.json();
-        console.error('Error deleting conversation:', data.error);
-      }
+      await conversationAPI.deleteConversation(conversationId);
+      router.push('/dashboard');
     } catch (error) {
       console.error('Error deleting conversation:', error);
     }
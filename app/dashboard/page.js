@@ -4,7 +4,7 @@ import { useState, useEffect } from 'react';
 import { useRouter } from 'next/navigation';
 import ConversationList from '../components/ConversationList';
 import WebSocketChatWindow from '../components/WebSocketChatWindow';
-import { portalAPI, conversationAPI } from '@/lib/api';
+import { authAPI, portalAPI, conversationAPI } from '@/lib/api';
 
 export default function Dashboard() {
   const router = useRouter();
@@ -256,8 +256,12 @@ export default function Dashboard() {
     return `${baseUrl}/portal/${selectedPortal.customName}/${category.slug}`;
   };
   
-  const handleLogout = () => {
-    localStorage.removeItem('token');
+  const handleLogout = async () => {
+    try {
+      await authAPI.logout();
+    } catch (error) {
+      console.error('Error logging out:', error);
+    }
     router.push('/');
   };
   
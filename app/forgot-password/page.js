@@ -0,0 +1,67 @@
+"use client";
+
+import { useState } from 'react';
+import { authAPI } from '../../lib/api';
+
+export default function ForgotPasswordPage() {
+  const [email, setEmail] = useState('');
+  const [message, setMessage] = useState('');
+  const [error, setError] = useState('');
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+
+    try {
+      const data = await authAPI.forgotPassword(email);
+      setMessage(data.message);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50">
+      <div className="max-w-md w-full p-6 bg-white rounded-lg shadow-lg">
+        <h1 className="text-3xl font-bold text-center mb-6">Forgot Password</h1>
+
+        {message ? (
+          <p className="text-green-600 text-sm text-center">{message}</p>
+        ) : (
+          <form onSubmit={handleSubmit} className="space-y-4">
+            <div>
+              <label htmlFor="email" className="block text-sm font-medium text-gray-700">
+                Email
+              </label>
+              <input
+                type="email"
+                id="email"
+                className="mt-1 block w-full border border-gray-300 rounded-md shadow-sm p-2"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+              />
+            </div>
+
+            {error && (
+              <div className="text-red-500 text-sm">{error}</div>
+            )}
+
+            <button
+              type="submit"
+              className="w-full py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500"
+            >
+              Send Reset Link
+            </button>
+          </form>
+        )}
+
+        <div className="mt-4 text-center">
+          <a href="/login" className="text-sm text-blue-600 hover:text-blue-500">
+            Back to login
+          </a>
+        </div>
+      </div>
+    </div>
+  );
+}
@@ -24,8 +24,9 @@ export default function Home() {
           password,
         });
         
-        // Save auth token
+        // Save auth tokens
         localStorage.setItem('token', data.token);
+        localStorage.setItem('refreshToken', data.refreshToken);
         
         // Redirect to dashboard
         router.push('/dashboard');
@@ -37,8 +38,9 @@ export default function Home() {
           password,
         });
         
-        // Save auth token
+        // Save auth tokens
         localStorage.setItem('token', data.token);
+        localStorage.setItem('refreshToken', data.refreshToken);
         
         // Redirect to dashboard
         router.push('/dashboard');
@@ -113,6 +115,11 @@ export default function Home() {
         </form>
         
         <div className="mt-4 text-center">
+          {isLogin && (
+            <a href="/forgot-password" className="block mb-2 text-sm text-blue-600 hover:text-blue-500">
+              Forgot your password?
+            </a>
+          )}
           <button
             onClick={() => setIsLogin(!isLogin)}
             className="text-sm text-blue-600 hover:text-blue-500"
@@ -44,6 +44,7 @@ export default function PortalParamsPage({ params }) {
   const [conversation, setConversation] = useState(null);
   const [customerId, setCustomerId] = useState('');
   const [customerName, setCustomerName] = useState('');
+  const [customerToken, setCustomerToken] = useState('');
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
   const [formSubmitted, setFormSubmitted] = useState(false);
@@ -56,9 +57,13 @@ export default function PortalParamsPage({ params }) {
     localStorage.setItem(`customer_id_${uniqueCode}`, generatedId);
     setCustomerId(generatedId);
     
+    // Claimed conversations can only be opened with the token issued on claiming
+    const savedToken = localStorage.getItem(`customer_token_${uniqueCode}`) || '';
+    setCustomerToken(savedToken);
+
     // Check if customer name is already set
     const savedName = localStorage.getItem(`customer_name_${uniqueCode}`);
-    if (savedName) {
+    if (savedName && savedToken) {
       setCustomerName(savedName);
       setFormSubmitted(true);
     }
@@ -66,21 +71,31 @@ export default function PortalParamsPage({ params }) {
     // Fetch conversation details based on the URL parameters
     if (categorySlug) {
       // Format 2: Use both parameters
-      fetchConversationByURLParams();
+      fetchConversationByURLParams(savedToken);
     } else {
       // Format 1: Use just the uniqueCode
-      fetchConversationByCode();
+      fetchConversationByCode(savedToken);
     }
   }, [portalName, categorySlug, uniqueCode]);
   
-  const fetchConversationByURLParams = async () => {
+  const customerHeaders = (token) => (token ? { 'X-Customer-Token': token } : {});
+
+  const fetchConversationByURLParams = async (token) => {
     try {
       // Use the new endpoint to fetch conversation by URL parameters
       console.log(`Fetching conversation with params: ${portalName}/${categorySlug}/${uniqueCode}`);
-      const response = await fetch(`${API_URL}/api/conversation/find/${portalName}/${categorySlug}/${uniqueCode}`);
+      const response = await fetch(`${API_URL}/api/conversation/find/${portalName}/${categorySlug}/${uniqueCode}`, {
+        headers: customerHeaders(token),
+      });
       
       if (!response.ok) {
-        if (response.status === 404) {
+        if (response.status === 401) {
+          setError('This conversation has already been started on another device');
+        } else if (response.status === 410) {
+          // The link expired, was revoked or has been used up
+          const data = await response.json().catch(() => ({}));
+          setError(data.error || 'This conversation link is no longer valid');
+        } else if (response.status === 404) {
           setError('This conversation link is invalid or has expired');
         } else {
           setError('Failed to load conversation');
@@ -90,6 +105,13 @@ export default function PortalParamsPage({ params }) {
       }
       
       const data = await response.json();
+
+      // The portal was renamed; move to the link under its current name
+      if (data.redirect && data.canonicalURL) {
+        router.replace(data.canonicalURL);
+        return;
+      }
+
       setPortal(data.portal);
       setConversation(data.conversation);
       setLoading(false);
@@ -100,14 +122,22 @@ export default function PortalParamsPage({ params }) {
     }
   };
   
-  const fetchConversationByCode = async () => {
+  const fetchConversationByCode = async (token) => {
     try {
       // Use the endpoint to fetch conversation by code
       console.log(`Fetching conversation with code: ${uniqueCode}`);
-      const response = await fetch(`${API_URL}/api/conversation/code/${uniqueCode}`);
+      const response = await fetch(`${API_URL}/api/conversation/code/${uniqueCode}`, {
+        headers: customerHeaders(token),
+      });
       
       if (!response.ok) {
-        if (response.status === 404) {
+        if (response.status === 401) {
+          setError('This conversation has already been started on another device');
+        } else if (response.status === 410) {
+          // The link expired, was revoked or has been used up
+          const data = await response.json().catch(() => ({}));
+          setError(data.error || 'This conversation link is no longer valid');
+        } else if (response.status === 404) {
           setError('This conversation code is invalid or has expired');
         } else {
           setError('Failed to load conversation');
@@ -139,20 +169,34 @@ export default function PortalParamsPage({ params }) {
     
     setCustomerName(name);
     localStorage.setItem(`customer_name_${uniqueCode}`, name);
-    setFormSubmitted(true);
     
-    // Update the conversation with the customer name
+    // Claim the conversation with the customer name; the response carries the customer token
     try {
-      await fetch(`${API_URL}/api/conversation/${conversation.id}/update-customer`, {
+      const response = await fetch(`${API_URL}/api/conversation/${conversation.id}/update-customer`, {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
+          ...customerHeaders(customerToken),
         },
         body: JSON.stringify({
           customerName: name,
           customerId,
         }),
       });
+      if (response.status === 410) {
+        const data = await response.json().catch(() => ({}));
+        setError(data.error || 'This conversation link is no longer valid');
+        return;
+      }
+      if (!response.ok) {
+        setError('This conversation has already been started on another device');
+        return;
+      }
+
+      const data = await response.json();
+      localStorage.setItem(`customer_token_${uniqueCode}`, data.customerToken);
+      setCustomerToken(data.customerToken);
+      setFormSubmitted(true);
     } catch (error) {
       console.error('Error updating customer info:', error);
     }
@@ -229,6 +273,7 @@ export default function PortalParamsPage({ params }) {
             conversationId={conversation.id}
             customerId={customerId}
             customerName={customerName}
+            customerToken={customerToken}
             isOwner={false}
           />
         )}
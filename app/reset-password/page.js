@@ -0,0 +1,94 @@
+"use client";
+
+import { useState } from 'react';
+import { useSearchParams } from 'next/navigation';
+import { authAPI } from '../../lib/api';
+
+export default function ResetPasswordPage() {
+  const searchParams = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [message, setMessage] = useState('');
+  const [error, setError] = useState('');
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+
+    if (password !== confirmPassword) {
+      setError('Passwords do not match');
+      return;
+    }
+
+    try {
+      const data = await authAPI.resetPassword(token, password);
+      setMessage(data.message);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50">
+      <div className="max-w-md w-full p-6 bg-white rounded-lg shadow-lg">
+        <h1 className="text-3xl font-bold text-center mb-6">Reset Password</h1>
+
+        {message ? (
+          <p className="text-green-600 text-sm text-center">{message}</p>
+        ) : !token ? (
+          <p className="text-red-500 text-sm text-center">This reset link is missing its token</p>
+        ) : (
+          <form onSubmit={handleSubmit} className="space-y-4">
+            <div>
+              <label htmlFor="password" className="block text-sm font-medium text-gray-700">
+                New Password
+              </label>
+              <input
+                type="password"
+                id="password"
+                minLength={6}
+                className="mt-1 block w-full border border-gray-300 rounded-md shadow-sm p-2"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+              />
+            </div>
+
+            <div>
+              <label htmlFor="confirmPassword" className="block text-sm font-medium text-gray-700">
+                Confirm Password
+              </label>
+              <input
+                type="password"
+                id="confirmPassword"
+                minLength={6}
+                className="mt-1 block w-full border border-gray-300 rounded-md shadow-sm p-2"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                required
+              />
+            </div>
+
+            {error && (
+              <div className="text-red-500 text-sm">{error}</div>
+            )}
+
+            <button
+              type="submit"
+              className="w-full py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500"
+            >
+              Reset Password
+            </button>
+          </form>
+        )}
+
+        <div className="mt-4 text-center">
+          <a href="/login" className="text-sm text-blue-600 hover:text-blue-500">
+            Back to login
+          </a>
+        </div>
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,48 @@
+"use client";
+
+import { useState, useEffect, useRef } from 'react';
+import { useSearchParams } from 'next/navigation';
+import { authAPI } from '../../lib/api';
+
+export default function VerifyEmailPage() {
+  const searchParams = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [message, setMessage] = useState('Verifying your email...');
+  const [error, setError] = useState('');
+  const requested = useRef(false);
+
+  useEffect(() => {
+    if (!token) {
+      setError('This verification link is missing its token');
+      return;
+    }
+
+    // Tokens work once, so do not verify twice when the effect runs again
+    if (requested.current) return;
+    requested.current = true;
+
+    authAPI.verifyEmail(token)
+      .then((data) => setMessage(data.message))
+      .catch((err) => setError(err.message));
+  }, [token]);
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50">
+      <div className="max-w-md w-full p-6 bg-white rounded-lg shadow-lg text-center">
+        <h1 className="text-3xl font-bold mb-6">Verify Email</h1>
+
+        {error ? (
+          <p className="text-red-500 text-sm">{error}</p>
+        ) : (
+          <p className="text-green-600 text-sm">{message}</p>
+        )}
+
+        <div className="mt-4">
+          <a href="/dashboard" className="text-sm text-blue-600 hover:text-blue-500">
+            Go to dashboard
+          </a>
+        </div>
+      </div>
+    </div>
+  );
+}
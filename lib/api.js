@@ -11,6 +11,49 @@ export const authAPI = {
   // Register
   register(data) {
     return apiClient.post('/auth/register', data);
+  },
+
+  // End this session; the stored tokens are dropped either way
+  async logout() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    try {
+      if (refreshToken) {
+        await apiClient.post('/auth/logout', { refreshToken });
+      }
+    } finally {
+      localStorage.removeItem('token');
+      localStorage.removeItem('refreshToken');
+    }
+  },
+
+  // Mail a password reset link
+  forgotPassword(email) {
+    return apiClient.post('/auth/forgot-password', { email });
+  },
+
+  // Set a new password with the token from a reset link
+  resetPassword(token, password) {
+    return apiClient.post('/auth/reset-password', { token, password });
+  },
+
+  // Verify an email address with the token from a verification link
+  verifyEmail(token) {
+    return apiClient.post('/auth/verify-email', { token });
+  },
+
+  // Mail a new verification link to the logged in user
+  resendVerification() {
+    return apiClient.post('/auth/resend-verification');
+  },
+
+  // End every session of the logged in user, on all devices
+  async logoutAll() {
+    try {
+      await apiClient.post('/auth/logout-all');
+    } finally {
+      localStorage.removeItem('token');
+      localStorage.removeItem('refreshToken');
+    }
   }
 };
 
@@ -35,6 +78,11 @@ export const portalAPI = {
   updatePortal(portalId, data) {
     return apiClient.put(`/portals/${portalId}`, data);
   },
+
+  // Make a portal the current one; "current" can then be used as a portal ID
+  selectPortal(portalId) {
+    return apiClient.put(`/portals/${portalId}/select`);
+  },
   
   // Get all conversations for a portal
   getPortalConversations(portalId) {
@@ -64,6 +112,11 @@ export const portalAPI = {
   // Generate conversation link
   generateLink(portalId, data) {
     return apiClient.post(`/portals/${portalId}/generate-link`, data);
+  },
+
+  // Join a portal's team with the token from an invitation email
+  acceptInvitation(token) {
+    return apiClient.post('/invitations/accept', { token });
   }
 };
 
@@ -83,6 +136,16 @@ export const conversationAPI = {
   getConversationMessages(id) {
     return apiClient.get(`/conversations/${id}/messages`);
   },
+
+  // Revoke the customer's tokens; the response carries a new one
+  revokeCustomerTokens(id) {
+    return apiClient.post(`/conversations/${id}/customer-token/revoke`);
+  },
+
+  // Revoke a generated conversation link so that nobody new can open it
+  revokeConversationLink(id) {
+    return apiClient.post(`/conversations/${id}/link/revoke`);
+  },
   
   // Get conversation by code (public)
   getConversationByCode(code) {
@@ -110,13 +173,13 @@ export const conversationAPI = {
   },
   
   // Get public conversation info
-  getPublicConversation(id) {
-    return apiClient.get(`/conversation/public/${id}`);
+  getPublicConversation(id, customerToken) {
+    return apiClient.request(`/conversation/public/${id}`, { headers: { 'X-Customer-Token': customerToken } });
   },
   
   // Get public conversation messages
-  getPublicConversationMessages(id) {
-    return apiClient.get(`/conversation/public/${id}/messages`);
+  getPublicConversationMessages(id, customerToken) {
+    return apiClient.request(`/conversation/public/${id}/messages`, { headers: { 'X-Customer-Token': customerToken } });
   }
 };
 
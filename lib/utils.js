@@ -99,12 +99,22 @@ export const apiClient = {
       options.headers['Content-Type'] = 'application/json';
     }
     
+    // Login and the session endpoints answer 401 for bad credentials, not an expired token
+    const isSessionEndpoint = endpoint.startsWith('/auth/') && endpoint !== '/auth/logout-all';
+    
     try {
-      const response = await fetch(url, options);
+      let response = await fetch(url, options);
+      
+      // The access token is short-lived; get a new one and try once more
+      if (response.status === 401 && !isSessionEndpoint && await this.refreshTokens()) {
+        options.headers['Authorization'] = `Bearer ${localStorage.getItem('token')}`;
+        response = await fetch(url, options);
+      }
       
       // Handle unauthorized responses
-      if (response.status === 401) {
+      if (response.status === 401 && !isSessionEndpoint) {
         localStorage.removeItem('token');
+        localStorage.removeItem('refreshToken');
         window.location.href = '/';
         return null;
       }
@@ -122,6 +132,37 @@ export const apiClient = {
     }
   },
   
+  // Exchange the stored refresh token for new tokens; reports whether it worked
+  async refreshTokens() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) {
+      return false;
+    }
+    
+    // Concurrent requests share one refresh, as each refresh token works only once
+    if (!this.refreshing) {
+      this.refreshing = fetch(`${this.baseUrl}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken }),
+      })
+        .then(async (response) => {
+          if (!response.ok) {
+            return false;
+          }
+          const data = await response.json();
+          localStorage.setItem('token', data.token);
+          localStorage.setItem('refreshToken', data.refreshToken);
+          return true;
+        })
+        .catch(() => false)
+        .finally(() => {
+          this.refreshing = null;
+        });
+    }
+    return this.refreshing;
+  },
+  
   // GET request
   get(endpoint) {
     return this.request(endpoint);
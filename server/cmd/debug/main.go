@@ -52,8 +52,12 @@ func main() {
 	}
 
 	// Test creating a conversation
+	uniqueCode, err := utils.NewConversationCode()
+	if err != nil {
+		log.Fatalf("Failed to generate conversation code: %v", err)
+	}
 	testConversation := models.Conversation{
-		UniqueCode:   utils.GenerateRandomCode(),
+		UniqueCode:   uniqueCode,
 		Category:     "Test Category",
 		CategorySlug: "test-category",
 		CustomerID:   "test-customer",
@@ -1,27 +1,131 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
-	
+	"os"
+	"strconv"
+
 	"github.com/joho/godotenv"
-	
+
 	"server/database"
-	"server/utils"
+	"server/migrations"
 )
 
+const usage = `Usage: go run ./cmd/migrate <command> [arguments]
+
+Commands:
+  up [n]                apply all pending migrations, or only the next n
+  down [n|all]          revert the last applied migration, or the last n
+  status                list migrations and when they were applied
+  create [-go] <name>   write the files of a new migration to ./migrations
+`
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	// Creating a migration does not need the database
+	if command == "create" {
+		create(args)
+		return
+	}
+
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
-	
+
 	// Setup database connection
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	
-	// Run data migration
-	utils.MigrateData()
-	
-	log.Println("Migration completed successfully")
-}
\ No newline at end of file
+	defer database.Close()
+
+	switch command {
+	case "up":
+		applied, err := migrations.Up(database.DB, steps(args, 0))
+		for _, migration := range applied {
+			log.Printf("Applied %s_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Database is up to date (%d applied)", len(applied))
+
+	case "down":
+		n := steps(args, 1)
+		if len(args) > 0 && args[0] == "all" {
+			n = int(^uint(0) >> 1)
+		}
+		reverted, err := migrations.Down(database.DB, n)
+		for _, migration := range reverted {
+			log.Printf("Reverted %s_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("%d migrations reverted", len(reverted))
+
+	case "status":
+		statuses, err := migrations.Statuses(database.DB)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.AppliedAt != nil && status.Up == nil {
+				state += " (unknown to this build)"
+			}
+			fmt.Printf("%s  %-40s %s\n", status.Version, status.Name, state)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// create writes a new SQL migration, or a Go one with -go
+func create(args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	goMigration := flags.Bool("go", false, "write a Go migration instead of SQL files")
+	dir := flags.String("dir", "migrations", "directory to write the migration to")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: go run ./cmd/migrate create [-go] [-dir migrations] <name>")
+	}
+
+	paths, err := migrations.Create(*dir, flags.Arg(0), *goMigration)
+	if err != nil {
+		log.Fatalf("Failed to create migration: %v", err)
+	}
+	for _, path := range paths {
+		log.Printf("Created %s", path)
+	}
+}
+
+// steps parses the optional count argument of up and down
+func steps(args []string, fallback int) int {
+	if len(args) == 0 || args[0] == "all" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		log.Fatalf("Invalid number of migrations: %s", args[0])
+	}
+	return n
+}
@@ -1,42 +1,107 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 )
 
+// DefaultConversationCodeAlphabet leaves out characters that are easily
+// mistaken for one another: 0 and O, 1, I and L
+const DefaultConversationCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
 // Config holds all configuration for the application
 type Config struct {
-	DBHost         string
-	DBPort         string
-	DBUser         string
-	DBPassword     string
-	DBName         string
-	JWTSecret      string
-	JWTExpiration  time.Duration
-	AllowOrigins   string
-	Environment    string
+	DBHost                   string
+	DBPort                   string
+	DBUser                   string
+	DBPassword               string
+	DBName                   string
+	JWTSecret                string
+	AccessTokenExpiration    time.Duration
+	RefreshTokenExpiration   time.Duration
+	CustomerTokenExpiration  time.Duration
+	InvitationExpiration     time.Duration
+	PasswordResetExpiration  time.Duration
+	VerificationExpiration   time.Duration
+	MaxPortalsPerUser        int
+	ConversationCodeAlphabet string
+	ConversationCodeLength   int
+	AllowOrigins             string
+	AppURL                   string
+	MailBackend              string
+	MailFrom                 string
+	MailDir                  string
+	SMTPHost                 string
+	SMTPPort                 string
+	SMTPUsername             string
+	SMTPPassword             string
+	WSSendQueueSize          int
+	WSWriteTimeout           time.Duration
+	WSPingInterval           time.Duration
+	WSPongTimeout            time.Duration
+	WSMaxMessageSize         int
+	RealtimeBackend          string
+	RealtimeChannel          string
+	ShutdownTimeout          time.Duration
+	SnoozeCheckInterval      time.Duration
+	Environment              string
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	config := &Config{
-		DBHost:         getEnv("DB_HOST", "localhost"),
-		DBPort:         getEnv("DB_PORT", "3306"),
-		DBUser:         getEnv("DB_USER", "root"),
-		DBPassword:     getEnv("DB_PASSWORD", ""),
-		DBName:         getEnv("DB_NAME", "customer_support"),
-		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key"),
-		JWTExpiration:  time.Duration(getEnvAsInt("JWT_EXPIRATION", 24)) * time.Hour,
-		AllowOrigins:   getEnv("ALLOW_ORIGINS", "http://localhost:3000"),
-		Environment:    getEnv("ENVIRONMENT", "development"),
+		DBHost:                   getEnv("DB_HOST", "localhost"),
+		DBPort:                   getEnv("DB_PORT", "3306"),
+		DBUser:                   getEnv("DB_USER", "root"),
+		DBPassword:               getEnv("DB_PASSWORD", ""),
+		DBName:                   getEnv("DB_NAME", "customer_support"),
+		JWTSecret:                getEnv("JWT_SECRET", "your-secret-key"),
+		AccessTokenExpiration:    time.Duration(getEnvAsInt("ACCESS_TOKEN_EXPIRATION", 15)) * time.Minute,
+		RefreshTokenExpiration:   time.Duration(getEnvAsInt("REFRESH_TOKEN_EXPIRATION", 720)) * time.Hour,
+		CustomerTokenExpiration:  time.Duration(getEnvAsInt("CUSTOMER_TOKEN_EXPIRATION", 720)) * time.Hour,
+		InvitationExpiration:     time.Duration(getEnvAsInt("INVITATION_EXPIRATION", 168)) * time.Hour,
+		PasswordResetExpiration:  time.Duration(getEnvAsInt("PASSWORD_RESET_EXPIRATION", 60)) * time.Minute,
+		VerificationExpiration:   time.Duration(getEnvAsInt("VERIFICATION_EXPIRATION", 48)) * time.Hour,
+		MaxPortalsPerUser:        getEnvAsInt("MAX_PORTALS_PER_USER", 5),
+		ConversationCodeAlphabet: getEnv("CONVERSATION_CODE_ALPHABET", DefaultConversationCodeAlphabet),
+		ConversationCodeLength:   getEnvAsInt("CONVERSATION_CODE_LENGTH", 10),
+		AllowOrigins:             getEnv("ALLOW_ORIGINS", "http://localhost:3000"),
+		AppURL:                   strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/"),
+		MailBackend:              getEnv("MAIL_BACKEND", ""),
+		MailFrom:                 getEnv("MAIL_FROM", "Customer Support <no-reply@localhost>"),
+		MailDir:                  getEnv("MAIL_DIR", "tmp/mail"),
+		SMTPHost:                 getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:                 getEnv("SMTP_PORT", "587"),
+		SMTPUsername:             getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:             getEnv("SMTP_PASSWORD", ""),
+		WSSendQueueSize:          getEnvAsInt("WS_SEND_QUEUE_SIZE", 64),
+		WSWriteTimeout:           time.Duration(getEnvAsInt("WS_WRITE_TIMEOUT", 10)) * time.Second,
+		WSPingInterval:           time.Duration(getEnvAsInt("WS_PING_INTERVAL", 30)) * time.Second,
+		WSPongTimeout:            time.Duration(getEnvAsInt("WS_PONG_TIMEOUT", 60)) * time.Second,
+		WSMaxMessageSize:         getEnvAsInt("WS_MAX_MESSAGE_SIZE", 64*1024),
+		RealtimeBackend:          getEnv("REALTIME_BACKEND", "memory"),
+		RealtimeChannel:          getEnv("REALTIME_CHANNEL", "realtime_events"),
+		ShutdownTimeout:          time.Duration(getEnvAsInt("SHUTDOWN_TIMEOUT", 30)) * time.Second,
+		SnoozeCheckInterval:      time.Duration(getEnvAsInt("SNOOZE_CHECK_INTERVAL", 60)) * time.Second,
+		Environment:              getEnv("ENVIRONMENT", "development"),
 	}
 
 	return config
 }
 
+// CheckRemovedSettings returns an error if a setting that is no longer
+// supported is set. JWT_EXPIRATION set the lifetime of access tokens in
+// hours; access tokens are now short-lived and renewed with refresh tokens.
+func CheckRemovedSettings() error {
+	if os.Getenv("JWT_EXPIRATION") != "" {
+		return errors.New("JWT_EXPIRATION is no longer supported: set ACCESS_TOKEN_EXPIRATION (minutes) and REFRESH_TOKEN_EXPIRATION (hours) instead")
+	}
+	return nil
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -66,4 +131,4 @@ func getEnvAsInt(key string, defaultValue int) int {
 // IsDevelopment checks if the environment is development
 func (c *Config) IsDevelopment() bool {
 	return strings.ToLower(c.Environment) == "development"
-}
\ No newline at end of file
+}
@@ -0,0 +1,21 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"server/config"
+)
+
+func TestCheckRemovedSettings(t *testing.T) {
+	t.Setenv("JWT_EXPIRATION", "")
+	os.Unsetenv("JWT_EXPIRATION")
+	if err := config.CheckRemovedSettings(); err != nil {
+		t.Fatalf("CheckRemovedSettings without JWT_EXPIRATION: %v", err)
+	}
+
+	t.Setenv("JWT_EXPIRATION", "24")
+	if err := config.CheckRemovedSettings(); err == nil {
+		t.Fatal("CheckRemovedSettings accepted JWT_EXPIRATION")
+	}
+}
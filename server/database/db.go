@@ -9,23 +9,15 @@ import (
 	"gorm.io/gorm/logger"
 
 	"server/config"
-	"server/database/models"
 )
 
 var DB *gorm.DB
 
-// Connect establishes a connection to the PostgreSQL database
+// Connect establishes a connection to the PostgreSQL database. The schema is
+// managed by the migrations package; see cmd/migrate.
 func Connect() error {
 	cfg := config.LoadConfig()
-
-	// Build PostgreSQL DSN (Data Source Name)
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=require TimeZone=Asia/Kolkata",
-		cfg.DBHost,
-		cfg.DBUser,
-		cfg.DBPassword,
-		cfg.DBName,
-		cfg.DBPort,
-	)
+	dsn := DSN(cfg)
 
 	// Configure logger
 	gormConfig := &gorm.Config{}
@@ -40,17 +32,31 @@ func Connect() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Auto migrate schemas
-	err = DB.AutoMigrate(
-		&models.User{},
-		&models.Portal{},
-		&models.Conversation{},
-		&models.Message{},
+	log.Println("Connected to PostgreSQL database")
+	return nil
+}
+
+// DSN builds the PostgreSQL DSN (Data Source Name) for the configured database
+func DSN(cfg *config.Config) string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=require TimeZone=Asia/Kolkata",
+		cfg.DBHost,
+		cfg.DBUser,
+		cfg.DBPassword,
+		cfg.DBName,
+		cfg.DBPort,
 	)
+}
+
+// Close releases the underlying database connection pool
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+
+	sqlDB, err := DB.DB()
 	if err != nil {
-		return fmt.Errorf("failed to migrate database: %w", err)
+		return fmt.Errorf("failed to get database handle: %w", err)
 	}
 
-	log.Println("Connected to PostgreSQL database and migrated models")
-	return nil
-}
\ No newline at end of file
+	return sqlDB.Close()
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"server/slug"
+)
+
+// Category represents a support category within a portal
+type Category struct {
+	ID          string `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	PortalID    string `gorm:"type:varchar(36);uniqueIndex:idx_categories_portal_slug" json:"portalId"`
+	Portal      Portal `gorm:"foreignKey:PortalID" json:"portal,omitempty"`
+	Name        string `gorm:"type:varchar(255)" json:"name"`
+	Slug        string `gorm:"type:varchar(255);uniqueIndex:idx_categories_portal_slug" json:"slug"` // URL-friendly version of name
+	Description string `gorm:"type:text" json:"description"`
+	SortOrder   int    `gorm:"default:0" json:"sortOrder"`
+	// Enabled is a pointer so that a disabled category is not mistaken for an unset field on create
+	Enabled *bool `gorm:"default:true" json:"enabled"`
+	// RoutingMode overrides the portal's routing mode when set
+	RoutingMode string    `gorm:"type:varchar(20)" json:"routingMode"`
+	ActiveCount int64     `gorm:"-" json:"activeCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a category
+func (c *Category) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+
+	// Generate URL-friendly slug if not provided
+	if c.Slug == "" {
+		c.Slug = slug.Make(c.Name)
+	}
+
+	return nil
+}
+
+// AfterSave is a GORM hook that remembers the name the category's slug stands for
+func (c *Category) AfterSave(tx *gorm.DB) error {
+	return rememberSlug(tx, SlugScopeCategory, c.Slug, c.Name)
+}
+
+// IsEnabled reports whether customers can start conversations in the category
+func (c *Category) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CategorySlug records a slug a category used before it was renamed, so
+// links built with the old slug keep working
+type CategorySlug struct {
+	ID         string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	PortalID   string    `gorm:"type:varchar(36);uniqueIndex:idx_category_slugs_portal_slug" json:"portalId"`
+	CategoryID string    `gorm:"type:varchar(36);index" json:"categoryId"`
+	Slug       string    `gorm:"type:varchar(255);uniqueIndex:idx_category_slugs_portal_slug" json:"slug"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a category slug
+func (s *CategorySlug) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
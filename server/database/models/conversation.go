@@ -1,29 +1,128 @@
 package models
 
 import (
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"server/slug"
 )
 
 // Conversation represents a support conversation
 type Conversation struct {
-	ID            string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	UniqueCode    string    `gorm:"uniqueIndex;type:varchar(10)" json:"uniqueCode"`
-	Category      string    `gorm:"type:varchar(255)" json:"category"`
-	CategorySlug  string    `gorm:"type:varchar(255)" json:"categorySlug"` // URL-friendly version of category
-	CustomerID    string    `gorm:"type:varchar(255)" json:"customerId"`
-	CustomerName  string    `gorm:"type:varchar(255)" json:"customerName"`
-	OwnerID       string    `gorm:"type:varchar(36)" json:"ownerId"`
-	Owner         User      `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
-	PortalID      string    `gorm:"type:varchar(36)" json:"portalId"`
-	Portal        Portal    `gorm:"foreignKey:PortalID" json:"portal,omitempty"`
-	Messages      []Message `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
-	MessageCount  int64     `gorm:"-" json:"messageCount,omitempty"`
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	ID                   string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UniqueCode           string     `gorm:"uniqueIndex;type:varchar(32)" json:"uniqueCode"`
+	CategoryID           *string    `gorm:"type:varchar(36);index" json:"categoryId,omitempty"`
+	Category             string     `gorm:"type:varchar(255)" json:"category"`
+	CategorySlug         string     `gorm:"type:varchar(255)" json:"categorySlug"` // URL-friendly version of category
+	CustomerID           string     `gorm:"type:varchar(255)" json:"customerId"`
+	CustomerName         string     `gorm:"type:varchar(255)" json:"customerName"`
+	CustomerTokenVersion int        `gorm:"not null;default:1" json:"-"` // bumped to revoke the customer's tokens
+	LinkExpiresAt        *time.Time `json:"linkExpiresAt,omitempty"`
+	LinkMaxUses          int        `gorm:"not null;default:0" json:"linkMaxUses"` // 0 means unlimited
+	LinkUses             int        `gorm:"not null;default:0" json:"linkUses"`
+	LinkRevokedAt        *time.Time `json:"linkRevokedAt,omitempty"`
+	Status               string     `gorm:"type:varchar(20);default:open;index" json:"status"`
+	SnoozedUntil         *time.Time `json:"snoozedUntil,omitempty"`
+	ResolvedAt           *time.Time `json:"resolvedAt,omitempty"`
+	AssigneeID           *string    `gorm:"type:varchar(36);index" json:"assigneeId,omitempty"`
+	AssignedAt           *time.Time `json:"assignedAt,omitempty"`
+	OwnerID              string     `gorm:"type:varchar(36)" json:"ownerId"`
+	Owner                User       `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	PortalID             string     `gorm:"type:varchar(36)" json:"portalId"`
+	Portal               Portal     `gorm:"foreignKey:PortalID" json:"portal,omitempty"`
+	Messages             []Message  `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
+	MessageCount         int64      `gorm:"-" json:"messageCount,omitempty"`
+	UnreadCount          int64      `gorm:"-" json:"unreadCount"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
+
+// UnclaimedCustomerID is the customer ID of a conversation created from a link
+// before a customer has claimed it by entering their name
+const UnclaimedCustomerID = "placeholder"
+
+// Conversation link states
+const (
+	// LinkActive links can be opened
+	LinkActive = "active"
+	// LinkExpired links stopped working at LinkExpiresAt
+	LinkExpired = "expired"
+	// LinkRevoked links were turned off by the support team
+	LinkRevoked = "revoked"
+	// LinkUsedUp links were claimed LinkMaxUses times
+	LinkUsedUp = "used_up"
+)
+
+// Conversation statuses
+const (
+	// ConversationOpen conversations need attention from the support team
+	ConversationOpen = "open"
+	// ConversationPending conversations are waiting on the customer
+	ConversationPending = "pending"
+	// ConversationSnoozed conversations are hidden until SnoozedUntil
+	ConversationSnoozed = "snoozed"
+	// ConversationResolved conversations are done but reopen when the customer replies
+	ConversationResolved = "resolved"
+	// ConversationClosed conversations are final and accept no more messages
+	ConversationClosed = "closed"
+)
+
+// ActiveStatuses are the statuses of conversations the support team is working on
+var ActiveStatuses = []string{ConversationOpen, ConversationPending}
+
+// conversationTransitions lists the statuses each status may move to
+var conversationTransitions = map[string][]string{
+	ConversationOpen:     {ConversationPending, ConversationSnoozed, ConversationResolved, ConversationClosed},
+	ConversationPending:  {ConversationOpen, ConversationSnoozed, ConversationResolved, ConversationClosed},
+	ConversationSnoozed:  {ConversationOpen, ConversationPending, ConversationResolved, ConversationClosed},
+	ConversationResolved: {ConversationOpen, ConversationClosed},
+	ConversationClosed:   {},
+}
+
+// IsConversationStatus reports whether status is a known conversation status
+func IsConversationStatus(status string) bool {
+	_, ok := conversationTransitions[status]
+	return ok
+}
+
+// CanTransitionTo reports whether the conversation may move to the given status
+func (c *Conversation) CanTransitionTo(status string) bool {
+	for _, allowed := range conversationTransitions[c.CurrentStatus()] {
+		if allowed == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentStatus returns the conversation status, treating rows created before
+// statuses existed as open
+func (c *Conversation) CurrentStatus() string {
+	if c.Status == "" {
+		return ConversationOpen
+	}
+	return c.Status
+}
+
+// Claimed reports whether a customer has taken over the conversation
+func (c *Conversation) Claimed() bool {
+	return c.CustomerID != UnclaimedCustomerID
+}
+
+// LinkState reports whether the conversation's link still works at the given
+// time. Revocation and expiry take precedence over the use limit.
+func (c *Conversation) LinkState(now time.Time) string {
+	switch {
+	case c.LinkRevokedAt != nil:
+		return LinkRevoked
+	case c.LinkExpiresAt != nil && !now.Before(*c.LinkExpiresAt):
+		return LinkExpired
+	case c.LinkMaxUses > 0 && c.LinkUses >= c.LinkMaxUses:
+		return LinkUsedUp
+	}
+	return LinkActive
 }
 
 // BeforeCreate is a GORM hook that generates a UUID before creating a conversation
@@ -32,43 +131,18 @@ func (c *Conversation) BeforeCreate(tx *gorm.DB) error {
 		c.ID = uuid.New().String()
 	}
 	
-	// Generate URL-friendly category slug if not provided
-	if c.CategorySlug == "" {
-		c.CategorySlug = slugifyInternal(c.Category)
+	if c.Status == "" {
+		c.Status = ConversationOpen
 	}
 	
-	return nil
-}
-
-// slugifyInternal converts a string to a URL-friendly slug (internal function)
-func slugifyInternal(input string) string {
-	// Convert to lowercase
-	result := strings.ToLower(input)
-	
-	// Replace spaces with hyphens
-	result = strings.ReplaceAll(result, " ", "-")
-	
-	// Remove special characters
-	var sb strings.Builder
-	for _, r := range result {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
-			sb.WriteRune(r)
-		}
+	if c.CustomerTokenVersion == 0 {
+		c.CustomerTokenVersion = 1
 	}
-	result = sb.String()
-	
-	// Remove consecutive hyphens
-	for strings.Contains(result, "--") {
-		result = strings.ReplaceAll(result, "--", "-")
-	}
-	
-	// Trim hyphens from start and end
-	result = strings.Trim(result, "-")
 	
-	// If the result is empty, use a fallback
-	if result == "" {
-		result = "untitled"
+	// Generate URL-friendly category slug if not provided
+	if c.CategorySlug == "" {
+		c.CategorySlug = slug.Make(c.Category)
 	}
 	
-	return result
-}
\ No newline at end of file
+	return nil
+}
@@ -9,16 +9,21 @@ import (
 
 // Message represents a chat message
 type Message struct {
-	ID             string       `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	Content        string       `gorm:"type:text" json:"content"`
-	SenderID       string       `gorm:"type:varchar(255)" json:"senderId"`
+	ID              string       `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	// Seq numbers the conversation's messages in the order they are stored, so it can be used as a sync cursor
+	Seq             int64        `gorm:"not null;uniqueIndex:idx_messages_conversation_seq,priority:2" json:"seq"`
+	Content         string       `gorm:"type:text" json:"content"`
+	SenderID        string       `gorm:"type:varchar(255)" json:"senderId"`
 	// Remove the foreign key constraint since customers are not in the users table
 	// We don't use foreignKey here because not all senders are in the users table
-	Sender         User         `gorm:"-" json:"sender,omitempty"` // Ignore this field in database
-	ConversationID string       `gorm:"type:varchar(36)" json:"conversationId"`
-	Conversation   Conversation `gorm:"foreignKey:ConversationID" json:"conversation,omitempty"`
-	IsOwner        bool         `gorm:"default:false" json:"isOwner"`
-	CreatedAt      time.Time    `json:"createdAt"`
+	Sender          User         `gorm:"-" json:"sender,omitempty"` // Ignore this field in database
+	ConversationID  string       `gorm:"type:varchar(36);uniqueIndex:idx_messages_client_message_id,priority:1;uniqueIndex:idx_messages_conversation_seq,priority:1" json:"conversationId"`
+	Conversation    Conversation `gorm:"foreignKey:ConversationID" json:"conversation,omitempty"`
+	IsOwner         bool         `gorm:"default:false" json:"isOwner"`
+	// ClientMessageID is an optional idempotency key chosen by the sender; a resend
+	// with the same key returns the stored message instead of creating another one
+	ClientMessageID *string      `gorm:"type:varchar(64);uniqueIndex:idx_messages_client_message_id,priority:2" json:"clientMessageId,omitempty"`
+	CreatedAt       time.Time    `json:"createdAt"`
 }
 
 // BeforeCreate is a GORM hook that generates a UUID before creating a message
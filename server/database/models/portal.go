@@ -5,18 +5,41 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"server/slug"
 )
 
 // Portal represents a support portal
 type Portal struct {
-	ID            string         `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	Name          string         `gorm:"uniqueIndex;type:varchar(255)" json:"name"`
-	CustomName    string         `gorm:"uniqueIndex;type:varchar(255)" json:"customName"` // URL-friendly unique name
-	OwnerID       string         `gorm:"type:varchar(36)" json:"ownerId"`
-	Owner         User           `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
-	Conversations []Conversation `gorm:"foreignKey:PortalID" json:"conversations,omitempty"`
-	CreatedAt     time.Time      `json:"createdAt"`
-	UpdatedAt     time.Time      `json:"updatedAt"`
+	ID                   string         `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	Name                 string         `gorm:"uniqueIndex;type:varchar(255)" json:"name"`
+	CustomName           string         `gorm:"uniqueIndex;type:varchar(255)" json:"customName"` // URL-friendly unique name
+	OwnerID              string         `gorm:"type:varchar(36)" json:"ownerId"`
+	// AllowAdHocCategories lets customers start conversations in categories the owner has not defined
+	AllowAdHocCategories bool           `gorm:"default:false" json:"allowAdHocCategories"`
+	// RoutingMode decides who new conversations are assigned to (see the Routing constants)
+	RoutingMode          string         `gorm:"type:varchar(20);default:manual" json:"routingMode"`
+	// Role is the requesting user's role on the portal, filled in by the handlers
+	Role                 string         `gorm:"-" json:"role,omitempty"`
+	Owner                User           `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	Conversations        []Conversation `gorm:"foreignKey:PortalID" json:"conversations,omitempty"`
+	CreatedAt            time.Time      `json:"createdAt"`
+	UpdatedAt            time.Time      `json:"updatedAt"`
+}
+
+// Routing modes for new conversations
+const (
+	// RoutingManual leaves new conversations unassigned
+	RoutingManual = "manual"
+	// RoutingRoundRobin assigns to the agent who was assigned least recently
+	RoutingRoundRobin = "round_robin"
+	// RoutingLeastBusy assigns to the agent with the fewest open or pending conversations
+	RoutingLeastBusy = "least_busy"
+)
+
+// IsRoutingMode reports whether mode is a known routing mode
+func IsRoutingMode(mode string) bool {
+	return mode == RoutingManual || mode == RoutingRoundRobin || mode == RoutingLeastBusy
 }
 
 // BeforeCreate is a GORM hook that generates a UUID before creating a portal
@@ -27,8 +50,13 @@ func (p *Portal) BeforeCreate(tx *gorm.DB) error {
 	
 	// Generate URL-friendly custom name if not provided
 	if p.CustomName == "" {
-		p.CustomName = p.Name
+		p.CustomName = slug.Make(p.Name)
 	}
 	
 	return nil
+}
+
+// AfterSave is a GORM hook that remembers the name the portal's custom name stands for
+func (p *Portal) AfterSave(tx *gorm.DB) error {
+	return rememberSlug(tx, SlugScopePortal, p.CustomName, p.Name)
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Portal member roles, from most to least privileged
+const (
+	// RoleOwner can do everything, including managing admins
+	RoleOwner = "owner"
+	// RoleAdmin manages the portal settings, categories and team
+	RoleAdmin = "admin"
+	// RoleAgent answers conversations
+	RoleAgent = "agent"
+	// RoleViewer can read conversations but not answer them
+	RoleViewer = "viewer"
+)
+
+// roleRanks orders the roles so that a higher rank includes every lower one
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleAgent:  2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// AssignableRoles are the roles that can be assigned conversations
+var AssignableRoles = []string{RoleAgent, RoleAdmin, RoleOwner}
+
+// IsMemberRole reports whether role is a known portal member role
+func IsMemberRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything min does
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
+
+// PortalMember gives a user a role on a portal
+type PortalMember struct {
+	ID       string `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	PortalID string `gorm:"type:varchar(36);uniqueIndex:idx_portal_members_user" json:"portalId"`
+	UserID   string `gorm:"type:varchar(36);uniqueIndex:idx_portal_members_user;index" json:"userId"`
+	User     User   `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role     string `gorm:"type:varchar(20)" json:"role"`
+	// LastAssignedAt drives round-robin routing
+	LastAssignedAt *time.Time `json:"lastAssignedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a portal member
+func (m *PortalMember) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// PortalInvitation invites someone by email to join a portal with a role.
+// Only a hash of the accept token is stored.
+type PortalInvitation struct {
+	ID          string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	PortalID    string     `gorm:"type:varchar(36);index" json:"portalId"`
+	Email       string     `gorm:"type:varchar(255);index" json:"email"`
+	Role        string     `gorm:"type:varchar(20)" json:"role"`
+	TokenHash   string     `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	InvitedByID string     `gorm:"type:varchar(36)" json:"invitedById"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	AcceptedAt  *time.Time `json:"acceptedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating an invitation
+func (i *PortalInvitation) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == "" {
+		i.ID = uuid.New().String()
+	}
+	return nil
+}
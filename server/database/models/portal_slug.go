@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PortalSlug records a custom name a portal used before it was renamed, so
+// links built with the old name keep working
+type PortalSlug struct {
+	ID        string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	PortalID  string    `gorm:"type:varchar(36);index" json:"portalId"`
+	Slug      string    `gorm:"uniqueIndex;type:varchar(255)" json:"slug"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a portal slug
+func (s *PortalSlug) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReadReceipt records how far a participant has read in a conversation
+type ReadReceipt struct {
+	ID                string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	ConversationID    string    `gorm:"type:varchar(36);uniqueIndex:idx_read_receipts_participant" json:"conversationId"`
+	ParticipantID     string    `gorm:"type:varchar(255);uniqueIndex:idx_read_receipts_participant" json:"participantId"`
+	IsOwner           bool      `gorm:"default:false" json:"isOwner"`
+	LastReadMessageID string    `gorm:"type:varchar(36)" json:"lastReadMessageId"`
+	LastReadSeq       int64     `json:"lastReadSeq"`
+	ReadAt            time.Time `json:"readAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a read receipt
+func (r *ReadReceipt) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken lets a support user get new access tokens without logging in
+// again. Each refresh rotates the token: the old one is revoked and a new one
+// in the same family is issued, so a family is one login session. Only a hash
+// of the token is stored.
+type RefreshToken struct {
+	ID        string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserID    string     `gorm:"type:varchar(36);index" json:"userId"`
+	FamilyID  string     `gorm:"type:varchar(36);index" json:"familyId"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a refresh
+// token. A token without a family starts a new one.
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.FamilyID == "" {
+		t.FamilyID = t.ID
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Slug scopes recorded in the slug lookup table
+const (
+	SlugScopePortal   = "portal"
+	SlugScopeCategory = "category"
+)
+
+// SlugLookup remembers the name a slug was first made from, so a slug can be
+// turned back into a readable name without guessing
+type SlugLookup struct {
+	Scope     string    `gorm:"primaryKey;type:varchar(20)" json:"scope"`
+	Slug      string    `gorm:"primaryKey;type:varchar(255)" json:"slug"`
+	Source    string    `gorm:"type:varchar(255)" json:"source"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// rememberSlug records the name a slug was made from. The first name recorded
+// for a slug is kept.
+func rememberSlug(tx *gorm.DB, scope, slug, source string) error {
+	if slug == "" || source == "" {
+		return nil
+	}
+
+	return tx.Session(&gorm.Session{NewDB: true}).Clauses(clause.OnConflict{DoNothing: true}).Create(&SlugLookup{
+		Scope:  scope,
+		Slug:   slug,
+		Source: source,
+	}).Error
+}
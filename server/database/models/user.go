@@ -10,13 +10,21 @@ import (
 
 // User represents a support provider
 type User struct {
-	ID        string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	Email     string    `gorm:"uniqueIndex;type:varchar(255)" json:"email"`
-	Password  string    `gorm:"type:varchar(255)" json:"-"`
-	Name      string    `gorm:"type:varchar(255)" json:"name"`
-	Portals   []Portal  `gorm:"foreignKey:OwnerID" json:"portals,omitempty"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID               string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	Email            string     `gorm:"uniqueIndex;type:varchar(255)" json:"email"`
+	Password         string     `gorm:"type:varchar(255)" json:"-"`
+	Name             string     `gorm:"type:varchar(255)" json:"name"`
+	// EmailVerifiedAt is set once the user follows the link sent to their email
+	EmailVerifiedAt  *time.Time `json:"emailVerifiedAt,omitempty"`
+	// SelectedPortalID is the portal the dashboard works on when a request says "current"
+	SelectedPortalID *string    `gorm:"type:varchar(36)" json:"selectedPortalId,omitempty"`
+	// PortalLimit overrides the configured number of portals the user may own (0 means unlimited)
+	PortalLimit      *int       `json:"portalLimit,omitempty"`
+	// TokenVersion must match the "ver" claim of an access token; bumping it logs out every session
+	TokenVersion     int        `gorm:"not null;default:1" json:"-"`
+	Portals          []Portal   `gorm:"foreignKey:OwnerID" json:"portals,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
 }
 
 // BeforeCreate is a GORM hook that generates a UUID before creating a user
@@ -24,5 +32,8 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == "" {
 		u.ID = uuid.New().String()
 	}
+	if u.TokenVersion == 0 {
+		u.TokenVersion = 1
+	}
 	return nil
 }
\ No newline at end of file
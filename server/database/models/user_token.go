@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// User token purposes
+const (
+	// TokenPasswordReset tokens let a user choose a new password
+	TokenPasswordReset = "password_reset"
+	// TokenEmailVerification tokens confirm that a user owns their email address
+	TokenEmailVerification = "email_verification"
+)
+
+// UserToken is a one-off secret mailed to a user, such as a password reset
+// link. Only a hash of the token is stored, and it works once, before ExpiresAt.
+type UserToken struct {
+	ID        string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserID    string     `gorm:"type:varchar(36);index" json:"userId"`
+	Purpose   string     `gorm:"type:varchar(32)" json:"purpose"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// BeforeCreate is a GORM hook that generates a UUID before creating a user token
+func (t *UserToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
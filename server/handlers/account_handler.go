@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/config"
+	"server/database/models"
+	"server/mail"
+	"server/store"
+	"server/utils"
+)
+
+// ForgotPasswordRequest represents the expected body for requesting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest represents the expected body for resetting a password
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+// VerifyEmailRequest represents the expected body for verifying an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ForgotPassword mails a password reset link to the user with the given
+// email. The response is the same whether or not the account exists, so that
+// it cannot be used to find out who has one, and it does not wait for the
+// email, so that its timing gives nothing away either.
+func (h *Handler) ForgotPassword(c *fiber.Ctx) error {
+	// Parse request body
+	var req ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is required",
+		})
+	}
+
+	email := strings.TrimSpace(req.Email)
+	h.runLater("password reset email", func() { h.sendPasswordReset(email) })
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+// sendPasswordReset mails a password reset link to the user with the given
+// email, if there is one. Failures can only be logged, as the request that
+// asked for the email has been answered already.
+func (h *Handler) sendPasswordReset(email string) {
+	user, err := h.stores.Users.GetByEmail(email)
+	if err == nil {
+		cfg := config.LoadConfig()
+		err = h.sendUserToken(user, models.TokenPasswordReset, cfg.PasswordResetExpiration, "/reset-password",
+			"Reset your password",
+			"Someone asked to reset the password of your account. If it was you, choose a new password here:")
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+}
+
+// ResetPassword sets a new password with the token from a reset email. Every
+// session of the user is logged out, and the email counts as verified.
+func (h *Handler) ResetPassword(c *fiber.Ctx) error {
+	// Parse request body
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Validate input
+	if req.Token == "" || len(req.Password) < 6 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token and a password of at least 6 characters are required",
+		})
+	}
+
+	// Hash the password first, so that a failure does not use up the token
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	// The token is used up only if the whole reset goes through
+	if _, err := h.stores.UserTokens.ResetPassword(utils.HashToken(req.Token), hashedPassword, time.Now()); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return userTokenError(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Password has been reset, please log in",
+	})
+}
+
+// VerifyEmail confirms a user's email address with the token from a verification email
+func (h *Handler) VerifyEmail(c *fiber.Ctx) error {
+	// Parse request body
+	var req VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token is required",
+		})
+	}
+
+	now := time.Now()
+	token, err := h.stores.UserTokens.Use(models.TokenEmailVerification, utils.HashToken(req.Token), now)
+	if err != nil {
+		return userTokenError(c, err)
+	}
+
+	if err := h.stores.Users.MarkEmailVerified(token.UserID, now); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify email",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification mails a new verification link to the authenticated user
+func (h *Handler) ResendVerification(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if user.EmailVerifiedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Email is already verified",
+		})
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send verification email",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Verification email sent",
+	})
+}
+
+// sendVerificationEmail mails the user a link to verify their email address
+func (h *Handler) sendVerificationEmail(user models.User) error {
+	cfg := config.LoadConfig()
+	return h.sendUserToken(user, models.TokenEmailVerification, cfg.VerificationExpiration, "/verify-email",
+		"Verify your email address",
+		"Please confirm that this is your email address by following this link:")
+}
+
+// sendUserToken creates a token for purpose and mails it to the user as a
+// link to the frontend page at path. Earlier tokens for the same purpose stop
+// working, so only the newest email counts.
+func (h *Handler) sendUserToken(user models.User, purpose string, expiresIn time.Duration, path, subject, intro string) error {
+	secret, err := utils.GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := h.stores.UserTokens.InvalidateForUser(user.ID, purpose, now); err != nil {
+		return err
+	}
+
+	// Only a hash of the token is stored
+	if err := h.stores.UserTokens.Create(&models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(secret),
+		ExpiresAt: now.Add(expiresIn),
+	}); err != nil {
+		return err
+	}
+
+	link := config.LoadConfig().AppURL + path + "?token=" + url.QueryEscape(secret)
+	return h.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: "Hi " + user.Name + ",\n\n" + intro + "\n\n" + link + "\n\n" +
+			"The link expires in " + describeDuration(expiresIn) + ". If you did not ask for this email, you can ignore it.\n",
+	})
+}
+
+// describeDuration spells out a token lifetime in whole hours or minutes
+func describeDuration(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// userTokenError responds to a password reset or verification token that cannot be used
+func userTokenError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "This link is invalid, has expired or was already used",
+			"code":  "invalid_token",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to check token",
+	})
+}
@@ -0,0 +1,112 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/handlers"
+	"server/mail"
+)
+
+// waitForMail waits for the mailer to have sent n messages and returns them
+func (s *testServer) waitForMail(n int) []mail.Message {
+	s.t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sent := s.mailer.Sent()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%d emails sent, want %d", len(sent), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// mailToken returns the token from the link in an account email
+func mailToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if link, err := url.Parse(line); err == nil && link.Query().Get("token") != "" {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no token in email %q", msg.Body)
+	return ""
+}
+
+func TestForgotPasswordAnswersAlikeForUnknownEmails(t *testing.T) {
+	s := newTestServer(t)
+	s.user("Ada", "ada@example.com")
+
+	var known, unknown map[string]interface{}
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/forgot-password", body: fiber.Map{"email": "ada@example.com"}}, &known)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/forgot-password", body: fiber.Map{"email": "nobody@example.com"}}, &unknown)
+	if known["message"] != unknown["message"] {
+		t.Fatalf("responses differ: %v and %v", known, unknown)
+	}
+
+	sent := s.waitForMail(1)
+	time.Sleep(20 * time.Millisecond)
+	if sent = s.mailer.Sent(); len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Fatalf("sent %+v, want one email to ada@example.com", sent)
+	}
+}
+
+func TestResetPasswordWithMailedToken(t *testing.T) {
+	s := newTestServer(t)
+
+	var auth handlers.AuthResponse
+	s.expect(http.StatusCreated, request{
+		method: "POST",
+		path:   "/api/auth/register",
+		body:   fiber.Map{"name": "Ada", "email": "ada@example.com", "password": "old-password"},
+	}, &auth)
+	// Registering sent a verification email
+	s.waitForMail(1)
+
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/forgot-password", body: fiber.Map{"email": "ada@example.com"}}, nil)
+	token := mailToken(t, s.waitForMail(2)[1])
+
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/reset-password", body: fiber.Map{"token": token, "password": "new-password"}}, nil)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/login", body: fiber.Map{"email": "ada@example.com", "password": "new-password"}}, nil)
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/login", body: fiber.Map{"email": "ada@example.com", "password": "old-password"}}, nil)
+
+	// The sessions from before the reset are over and the address counts as verified
+	s.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/portals", token: auth.Token}, nil)
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": auth.RefreshToken}}, nil)
+	if user, err := s.stores.Users.GetByEmail("ada@example.com"); err != nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("user after reset = %+v, %v; want a verified email", user, err)
+	}
+
+	// Reset tokens work once
+	if status := s.do(request{method: "POST", path: "/api/auth/reset-password", body: fiber.Map{"token": token, "password": "other-password"}}, nil); status == http.StatusOK {
+		t.Fatal("a reset token worked twice")
+	}
+}
+
+func TestCloseSendsQueuedMail(t *testing.T) {
+	s := newTestServer(t)
+	s.user("Ada", "ada@example.com")
+
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/forgot-password", body: fiber.Map{"email": "ada@example.com"}}, nil)
+	s.handler.Close()
+	if sent := s.mailer.Sent(); len(sent) != 1 {
+		t.Fatalf("%d emails sent by Close, want 1", len(sent))
+	}
+
+	// After Close requests are still answered, but nothing more is sent
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/forgot-password", body: fiber.Map{"email": "ada@example.com"}}, nil)
+	time.Sleep(20 * time.Millisecond)
+	if sent := s.mailer.Sent(); len(sent) != 1 {
+		t.Fatalf("%d emails sent after Close, want 1", len(sent))
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+	"server/store"
+)
+
+// AssignConversationRequest represents the expected body for assigning a conversation
+type AssignConversationRequest struct {
+	AssigneeID string `json:"assigneeId" validate:"required"`
+}
+
+// AssignConversation assigns or reassigns a conversation. Agents may take
+// conversations nobody is assigned to; assigning someone else or taking a
+// conversation over from a colleague needs the admin role.
+func (h *Handler) AssignConversation(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Parse request body
+	var req AssignConversationRequest
+	if err := c.BodyParser(&req); err != nil || req.AssigneeID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "assigneeId is required",
+		})
+	}
+
+	// Verify portal membership
+	conversation, role, err := h.authorizeConversation(conversationID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
+	selfAssign := req.AssigneeID == userID &&
+		(conversation.AssigneeID == nil || *conversation.AssigneeID == userID)
+	if !selfAssign && !models.RoleAtLeast(role, models.RoleAdmin) {
+		return accessError(c, errInsufficientRole)
+	}
+
+	// The assignee must be able to answer the conversation
+	assigneeRole := h.portalRole(models.Portal{ID: conversation.PortalID, OwnerID: conversation.OwnerID}, req.AssigneeID)
+	if !models.RoleAtLeast(assigneeRole, models.RoleAgent) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The assignee must be an agent, admin or owner of the portal",
+		})
+	}
+
+	if err := h.assignConversation(&conversation, &req.AssigneeID, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to assign conversation",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"conversation": conversation,
+	})
+}
+
+// UnassignConversation removes a conversation's assignee. Agents may drop
+// their own conversations; anything else needs the admin role.
+func (h *Handler) UnassignConversation(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Verify portal membership
+	conversation, role, err := h.authorizeConversation(conversationID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
+	ownAssignment := conversation.AssigneeID != nil && *conversation.AssigneeID == userID
+	if !ownAssignment && !models.RoleAtLeast(role, models.RoleAdmin) {
+		return accessError(c, errInsufficientRole)
+	}
+
+	if conversation.AssigneeID != nil {
+		if err := h.assignConversation(&conversation, nil, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to unassign conversation",
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"conversation": conversation,
+	})
+}
+
+// assignConversation stores a manual assignment (nil assigneeID unassigns)
+// and tells the portal's team about it
+func (h *Handler) assignConversation(conversation *models.Conversation, assigneeID *string, assignedByID string) error {
+	now := time.Now()
+
+	var assignedAt *time.Time
+	if assigneeID != nil {
+		assignedAt = &now
+	}
+
+	if err := h.stores.Conversations.Assign(conversation.ID, conversation.PortalID, assigneeID, now); err != nil {
+		return err
+	}
+
+	conversation.AssigneeID = assigneeID
+	conversation.AssignedAt = assignedAt
+
+	assignedBy := h.teamParticipant(assignedByID)
+	h.publishAssignment(conversation, &assignedBy, models.RoutingManual, now)
+	return nil
+}
+
+// autoAssign routes an unassigned conversation according to its category's
+// routing mode, or the portal's when the category does not set one. It runs
+// when the customer writes, so links nobody uses are never routed.
+func (h *Handler) autoAssign(conversationID string) {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil || conversation.AssigneeID != nil {
+		return
+	}
+
+	mode := h.routingMode(conversation)
+	if mode == models.RoutingManual {
+		return
+	}
+
+	// Another message may have routed the conversation in the meantime
+	now := time.Now()
+	assigneeID, err := h.stores.Conversations.AutoAssign(conversation.ID, conversation.PortalID, mode, now)
+	if err != nil || assigneeID == "" {
+		return
+	}
+
+	conversation.AssigneeID = &assigneeID
+	conversation.AssignedAt = &now
+	h.publishAssignment(&conversation, nil, mode, now)
+}
+
+// routingMode returns the routing mode that applies to a conversation
+func (h *Handler) routingMode(conversation models.Conversation) string {
+	if conversation.CategoryID != nil {
+		category, err := h.stores.Categories.Find(conversation.PortalID, *conversation.CategoryID)
+		if err == nil && category.RoutingMode != "" {
+			return category.RoutingMode
+		}
+	}
+
+	portal, err := h.stores.Portals.GetByID(conversation.PortalID)
+	if err != nil || portal.RoutingMode == "" {
+		return models.RoutingManual
+	}
+	return portal.RoutingMode
+}
+
+// publishAssignment broadcasts a conversation's assignee to its portal's team
+func (h *Handler) publishAssignment(conversation *models.Conversation, assignedBy *realtime.Participant, routing string, at time.Time) {
+	var assignee *realtime.Participant
+	if conversation.AssigneeID != nil {
+		participant := h.teamParticipant(*conversation.AssigneeID)
+		assignee = &participant
+	}
+
+	h.events.Publish(realtime.NewAssignmentEvent(conversation.PortalID, conversation.ID, assignee, assignedBy, routing, at))
+}
+
+// teamParticipant describes a support user in real-time events
+func (h *Handler) teamParticipant(userID string) realtime.Participant {
+	user, _ := h.stores.Users.GetByID(userID)
+	return realtime.Participant{ID: userID, Name: user.Name, IsOwner: true}
+}
+
+// assigneeFilter applies the `assignee` query parameter of the list endpoints:
+// "me", "unassigned" or a user ID
+func assigneeFilter(c *fiber.Ctx, filter store.ConversationFilter, userID string) store.ConversationFilter {
+	switch assignee := c.Query("assignee"); assignee {
+	case "":
+	case "me":
+		filter.AssigneeID = userID
+	case "unassigned":
+		filter.Unassigned = true
+	default:
+		filter.AssigneeID = assignee
+	}
+	return filter
+}
@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+)
+
+// assignee returns the user a conversation is assigned to, or "" if none
+func (s *testServer) assignee(token, conversationID string) string {
+	s.t.Helper()
+
+	var resp struct {
+		Conversation models.Conversation `json:"conversation"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversations/" + conversationID, token: token}, &resp)
+	if resp.Conversation.AssigneeID == nil {
+		return ""
+	}
+	return *resp.Conversation.AssigneeID
+}
+
+func TestRoundRobinAssignsOnCustomerMessage(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	viewer, viewerToken := s.user("Carol", "carol@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+	s.member(ownerToken, portal.ID, viewer, viewerToken, models.RoleViewer)
+	s.category(ownerToken, portal.ID, "Billing")
+	s.expect(http.StatusOK, request{method: "PUT", path: "/api/portals/" + portal.ID, token: ownerToken, body: fiber.Map{"routingMode": models.RoutingRoundRobin}}, nil)
+
+	// Links nobody writes through stay unassigned
+	first, firstToken := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+	if got := s.assignee(ownerToken, first.ID); got != "" {
+		t.Fatalf("unused conversation assigned to %q", got)
+	}
+
+	second, secondToken := s.conversation(ownerToken, portal.ID, "Billing", "Linus")
+	s.customerMessage(first.ID, firstToken, "Hello")
+	s.customerMessage(second.ID, secondToken, "Hello")
+
+	assigned := map[string]bool{
+		s.assignee(ownerToken, first.ID):  true,
+		s.assignee(ownerToken, second.ID): true,
+	}
+	if !assigned[owner.ID] || !assigned[bob.ID] {
+		t.Fatalf("conversations assigned to %v, want one each for the owner and Bob", assigned)
+	}
+}
+
+func TestManualRoutingLeavesConversationsUnassigned(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.category(ownerToken, portal.ID, "Billing")
+
+	conversation, customerToken := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+
+	if got := s.assignee(ownerToken, conversation.ID); got != "" {
+		t.Fatalf("conversation assigned to %q under manual routing", got)
+	}
+}
+
+func TestAssignConversation(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	viewer, viewerToken := s.user("Carol", "carol@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+	s.member(ownerToken, portal.ID, viewer, viewerToken, models.RoleViewer)
+	s.category(ownerToken, portal.ID, "Billing")
+	conversation, _ := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+	path := "/api/conversations/" + conversation.ID + "/assignee"
+
+	// Viewers can neither assign nor be assigned
+	s.expect(http.StatusForbidden, request{method: "PUT", path: path, token: viewerToken, body: fiber.Map{"assigneeId": bob.ID}}, nil)
+	s.expect(http.StatusBadRequest, request{method: "PUT", path: path, token: ownerToken, body: fiber.Map{"assigneeId": viewer.ID}}, nil)
+
+	s.expect(http.StatusOK, request{method: "PUT", path: path, token: ownerToken, body: fiber.Map{"assigneeId": bob.ID}}, nil)
+	if got := s.assignee(ownerToken, conversation.ID); got != bob.ID {
+		t.Fatalf("assignee = %q, want Bob", got)
+	}
+
+	var mine struct {
+		Conversations []models.Conversation `json:"conversations"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/conversations?assignee=me", token: bobToken}, &mine)
+	if len(mine.Conversations) != 1 || mine.Conversations[0].ID != conversation.ID {
+		t.Fatalf("Bob's conversations = %+v, want the assigned one", mine.Conversations)
+	}
+
+	s.expect(http.StatusOK, request{method: "DELETE", path: path, token: bobToken}, nil)
+	if got := s.assignee(ownerToken, conversation.ID); got != "" {
+		t.Fatalf("assignee after unassigning = %q", got)
+	}
+}
+
+func TestAgentsOnlyTakeUnassignedConversations(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	carol, carolToken := s.user("Carol", "carol@example.com")
+	dave, daveToken := s.user("Dave", "dave@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+	s.member(ownerToken, portal.ID, carol, carolToken, models.RoleAgent)
+	s.member(ownerToken, portal.ID, dave, daveToken, models.RoleAdmin)
+	s.category(ownerToken, portal.ID, "Billing")
+	conversation, _ := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+	path := "/api/conversations/" + conversation.ID + "/assignee"
+
+	// An agent takes an unassigned conversation, and taking it again changes nothing
+	s.expect(http.StatusOK, request{method: "PUT", path: path, token: bobToken, body: fiber.Map{"assigneeId": bob.ID}}, nil)
+	s.expect(http.StatusOK, request{method: "PUT", path: path, token: bobToken, body: fiber.Map{"assigneeId": bob.ID}}, nil)
+
+	// Another agent can neither take it over nor hand it on
+	s.expect(http.StatusForbidden, request{method: "PUT", path: path, token: carolToken, body: fiber.Map{"assigneeId": carol.ID}}, nil)
+	s.expect(http.StatusForbidden, request{method: "PUT", path: path, token: bobToken, body: fiber.Map{"assigneeId": carol.ID}}, nil)
+	if got := s.assignee(ownerToken, conversation.ID); got != bob.ID {
+		t.Fatalf("assignee = %q, want Bob", got)
+	}
+
+	// Admins reassign
+	s.expect(http.StatusOK, request{method: "PUT", path: path, token: daveToken, body: fiber.Map{"assigneeId": carol.ID}}, nil)
+	if got := s.assignee(ownerToken, conversation.ID); got != carol.ID {
+		t.Fatalf("assignee = %q, want Carol", got)
+	}
+	s.expect(http.StatusForbidden, request{method: "PUT", path: path, token: bobToken, body: fiber.Map{"assigneeId": bob.ID}}, nil)
+}
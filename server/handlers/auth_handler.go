@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 
-	"server/database"
+	"server/config"
 	"server/database/models"
+	"server/store"
 	"server/utils"
 )
 
@@ -21,14 +26,20 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// RefreshRequest represents the expected body for refreshing or ending a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
 // AuthResponse represents the response after successful authentication
 type AuthResponse struct {
-	User  models.User `json:"user"`
-	Token string      `json:"token"`
+	User         models.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refreshToken"`
 }
 
 // Register handles user registration
-func Register(c *fiber.Ctx) error {
+func (h *Handler) Register(c *fiber.Ctx) error {
 	// Parse request body
 	var req RegisterRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -45,9 +56,7 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// Check if user already exists
-	var existingUser models.User
-	result := database.DB.Where("email = ?", req.Email).First(&existingUser)
-	if result.RowsAffected > 0 {
+	if _, err := h.stores.Users.GetByEmail(req.Email); err == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Email already in use",
 		})
@@ -68,30 +77,35 @@ func Register(c *fiber.Ctx) error {
 		Password: hashedPassword,
 	}
 
-	result = database.DB.Create(&user)
-	if result.Error != nil {
+	if err := h.stores.Users.Create(&user); errors.Is(err, store.ErrDuplicate) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email already in use",
+		})
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create user",
 		})
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Email, user.Name)
+	// Ask the user to confirm their address; they can have the email sent again
+	if err := h.sendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
+	// Start a session with an access token and a refresh token
+	response, err := h.issueTokens(user, "")
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	// Return user and token
-	return c.Status(fiber.StatusCreated).JSON(AuthResponse{
-		User:  user,
-		Token: token,
-	})
+	// Return user and tokens
+	return c.Status(fiber.StatusCreated).JSON(response)
 }
 
 // Login handles user login
-func Login(c *fiber.Ctx) error {
+func (h *Handler) Login(c *fiber.Ctx) error {
 	// Parse request body
 	var req LoginRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -108,9 +122,8 @@ func Login(c *fiber.Ctx) error {
 	}
 
 	// Find user
-	var user models.User
-	result := database.DB.Where("email = ?", req.Email).First(&user)
-	if result.Error != nil {
+	user, err := h.stores.Users.GetByEmail(req.Email)
+	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid email or password",
 		})
@@ -123,17 +136,167 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Email, user.Name)
+	// Start a session with an access token and a refresh token
+	response, err := h.issueTokens(user, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	// Return user and tokens
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The old refresh token is revoked; presenting it again is taken as a
+// sign it was stolen and ends the whole session.
+func (h *Handler) Refresh(c *fiber.Ctx) error {
+	// Parse request body
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Refresh token is required",
+		})
+	}
+
+	// Find the refresh token
+	token, err := h.stores.RefreshTokens.GetByHash(utils.HashToken(req.RefreshToken))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	now := time.Now()
+	if token.RevokedAt == nil && !now.Before(token.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Refresh token has expired",
+		})
+	}
+
+	// A revoked token coming back was replayed, possibly by someone who stole it
+	if token.RevokedAt != nil {
+		return h.replayedRefreshToken(c, token)
+	}
+
+	// Revoking succeeds only once, so concurrent requests cannot both rotate the token
+	if err := h.stores.RefreshTokens.Revoke(token.ID, now); errors.Is(err, store.ErrNotFound) {
+		return h.replayedRefreshToken(c, token)
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rotate refresh token",
+		})
+	}
+
+	// Find user
+	user, err := h.stores.Users.GetByID(token.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	// Continue the session with a new pair of tokens
+	response, err := h.issueTokens(user, token.FamilyID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	// Return user and token
-	return c.Status(fiber.StatusOK).JSON(AuthResponse{
-		User:  user,
-		Token: token,
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// replayedRefreshToken ends the session of a refresh token that was used
+// after it had been revoked
+func (h *Handler) replayedRefreshToken(c *fiber.Ctx, token models.RefreshToken) error {
+	if err := h.stores.RefreshTokens.RevokeFamily(token.FamilyID, time.Now()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "Refresh token has been revoked",
+	})
+}
+
+// Logout ends the session a refresh token belongs to. The access token of the
+// session keeps working until it expires, which is soon.
+func (h *Handler) Logout(c *fiber.Ctx) error {
+	// Parse request body
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Refresh token is required",
+		})
+	}
+
+	// An unknown token has no session left to end
+	token, err := h.stores.RefreshTokens.GetByHash(utils.HashToken(req.RefreshToken))
+	if err == nil {
+		err = h.stores.RefreshTokens.RevokeFamily(token.FamilyID, time.Now())
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// LogoutAll ends every session of the authenticated user, revoking their
+// refresh tokens and, through the user's token version, their access tokens
+func (h *Handler) LogoutAll(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	if _, err := h.stores.Users.RevokeTokens(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+	if err := h.stores.RefreshTokens.RevokeForUser(userID, time.Now()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
 	})
-}
\ No newline at end of file
+}
+
+// issueTokens creates an access token and a refresh token for the user. A
+// refresh token rotated from an earlier one stays in that session's familyID;
+// an empty familyID starts a new session.
+func (h *Handler) issueTokens(user models.User, familyID string) (AuthResponse, error) {
+	token, err := utils.GenerateToken(user.ID, user.Email, user.Name, user.TokenVersion)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	refreshToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	// Only a hash of the refresh token is stored
+	if err := h.stores.RefreshTokens.Create(&models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(config.LoadConfig().RefreshTokenExpiration),
+	}); err != nil {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/handlers"
+	"server/utils"
+)
+
+func TestProtectedRoutesCheckTheUserStore(t *testing.T) {
+	s := newTestServer(t)
+
+	var auth handlers.AuthResponse
+	s.expect(http.StatusCreated, request{
+		method: "POST",
+		path:   "/api/auth/register",
+		body:   fiber.Map{"name": "Ada", "email": "ada@example.com", "password": "secret-password"},
+	}, &auth)
+
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals", token: auth.Token}, nil)
+
+	// Logging out everywhere bumps the token version the middleware checks
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/logout-all", token: auth.Token}, nil)
+	s.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/portals", token: auth.Token}, nil)
+}
+
+func TestProtectedRoutesRejectUnknownUsers(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+
+	// A token for a user the store has never seen
+	other := newTestServer(t)
+	other.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/portals", token: token}, nil)
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals", token: token}, nil)
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	s := newTestServer(t)
+
+	var auth handlers.AuthResponse
+	s.expect(http.StatusCreated, request{
+		method: "POST",
+		path:   "/api/auth/register",
+		body:   fiber.Map{"name": "Ada", "email": "ada@example.com", "password": "secret-password"},
+	}, &auth)
+
+	var refreshed handlers.AuthResponse
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": auth.RefreshToken}}, &refreshed)
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == auth.RefreshToken {
+		t.Fatalf("refresh returned refresh token %q, want a new one", refreshed.RefreshToken)
+	}
+
+	// Replaying the old token ends the whole session
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": auth.RefreshToken}}, nil)
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": refreshed.RefreshToken}}, nil)
+}
+
+func TestReplayedRefreshTokenRevokesTheFamily(t *testing.T) {
+	s := newTestServer(t)
+
+	var first, other handlers.AuthResponse
+	s.expect(http.StatusCreated, request{
+		method: "POST",
+		path:   "/api/auth/register",
+		body:   fiber.Map{"name": "Ada", "email": "ada@example.com", "password": "secret-password"},
+	}, &first)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/login", body: fiber.Map{"email": "ada@example.com", "password": "secret-password"}}, &other)
+
+	// Two rotations make a family of three tokens
+	var second, third handlers.AuthResponse
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": first.RefreshToken}}, &second)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": second.RefreshToken}}, &third)
+
+	// Replaying the oldest token is taken as theft and revokes every token of the family
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": first.RefreshToken}}, nil)
+	for _, refreshToken := range []string{first.RefreshToken, second.RefreshToken, third.RefreshToken} {
+		stored, err := s.stores.RefreshTokens.GetByHash(utils.HashToken(refreshToken))
+		if err != nil || stored.RevokedAt == nil {
+			t.Fatalf("refresh token after the replay = %+v, %v; want it revoked", stored, err)
+		}
+	}
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": third.RefreshToken}}, nil)
+
+	// Other sessions of the user are not part of the family
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": other.RefreshToken}}, nil)
+}
+
+func TestLogoutAllBumpsTokenVersion(t *testing.T) {
+	s := newTestServer(t)
+
+	var auth handlers.AuthResponse
+	s.expect(http.StatusCreated, request{
+		method: "POST",
+		path:   "/api/auth/register",
+		body:   fiber.Map{"name": "Ada", "email": "ada@example.com", "password": "secret-password"},
+	}, &auth)
+	before, err := s.stores.Users.GetByEmail("ada@example.com")
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/logout-all", token: auth.Token}, nil)
+
+	after, err := s.stores.Users.GetByEmail("ada@example.com")
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if after.TokenVersion != before.TokenVersion+1 {
+		t.Fatalf("token version %d after logging out everywhere, want %d", after.TokenVersion, before.TokenVersion+1)
+	}
+	s.expect(http.StatusUnauthorized, request{method: "POST", path: "/api/auth/refresh", body: fiber.Map{"refreshToken": auth.RefreshToken}}, nil)
+
+	// Logging in again issues tokens of the new version
+	var again handlers.AuthResponse
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/auth/login", body: fiber.Map{"email": "ada@example.com", "password": "secret-password"}}, &again)
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals", token: again.Token}, nil)
+}
+
+func TestStaleTokenVersionIsRejected(t *testing.T) {
+	s := newTestServer(t)
+	user, stale := s.user("Ada", "ada@example.com")
+
+	version, err := s.stores.Users.RevokeTokens(user.ID)
+	if err != nil {
+		t.Fatalf("revoke tokens: %v", err)
+	}
+	current, err := utils.GenerateToken(user.ID, user.Email, user.Name, version)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	// Both the REST middleware and the real-time handshake check the version
+	s.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/portals", token: stale}, nil)
+	s.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/conversation/public/any/poll?timeout=1&token=" + stale}, nil)
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals", token: current}, nil)
+}
@@ -1,104 +1,113 @@
 package handlers
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
-	"server/database"
 	"server/database/models"
+	"server/slug"
+	"server/store"
 	"server/utils"
-	"time"
 )
 
-// Category represents a support category
-type Category struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Slug        string    `json:"slug"`
-	PortalID    string    `json:"portalId"`
-	ActiveCount int       `json:"activeCount"`
-	CreatedAt   time.Time `json:"createdAt"`
-}
+// Errors returned by resolveCategory
+var (
+	errCategoryNotFound = errors.New("Category not found")
+	errCategoryDisabled = errors.New("Category is not available")
+)
 
 // AddCategoryRequest represents the expected body for adding a category
 type AddCategoryRequest struct {
-	Name string `json:"name" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	SortOrder   int    `json:"sortOrder"`
+	Enabled     *bool  `json:"enabled"`
+	// RoutingMode overrides the portal's routing mode; empty inherits it
+	RoutingMode string `json:"routingMode"`
+}
+
+// UpdateCategoryRequest represents the expected body for updating a category.
+// Fields left out of the body are not changed.
+type UpdateCategoryRequest struct {
+	Name        *string `json:"name"`
+	Slug        *string `json:"slug"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sortOrder"`
+	Enabled     *bool   `json:"enabled"`
+	RoutingMode *string `json:"routingMode"`
 }
 
 // GetPortalCategories returns all categories for a portal
-func GetPortalCategories(c *fiber.Ctx) error {
+func (h *Handler) GetPortalCategories(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
-		})
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
 	}
 
-	// Get all conversations for the portal to extract unique categories
-	var conversations []models.Conversation
-	database.DB.Where("portal_id = ?", portalID).Find(&conversations)
+	categories, err := h.stores.Categories.ListByPortal(portalID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch categories",
+		})
+	}
 
-	// Create a map to keep track of unique categories
-	categoryMap := make(map[string]*Category)
+	// Count active conversations per category, as the active conversations
+	// endpoint lists them
+	countByCategory, err := h.stores.Categories.CountActive(portalID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count conversations",
+		})
+	}
+	for i := range categories {
+		categories[i].ActiveCount = countByCategory[categories[i].ID]
+	}
 
-	// Extract unique categories from conversations
-	for _, conv := range conversations {
-		if conv.Category == "" {
-			continue
-		}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"categories": categories,
+	})
+}
 
-		slug := conv.CategorySlug
-		if slug == "" {
-			slug = utils.Slugify(conv.Category)
-		}
+// GetCategory returns a single category of a portal
+func (h *Handler) GetCategory(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
 
-		if _, exists := categoryMap[slug]; !exists {
-			// Create a new category if it doesn't exist
-			categoryMap[slug] = &Category{
-				ID:          slug,
-				Name:        conv.Category,
-				Slug:        slug,
-				PortalID:    portalID,
-				ActiveCount: 0,
-				CreatedAt:   conv.CreatedAt,
-			}
-		}
+	// Get portal ID and category ID (or slug) from URL
+	portalID := h.portalIDParam(c)
+	categoryRef := c.Params("categoryId")
 
-		// Count active conversations (with customer and messages)
-		if conv.CustomerName != "Unassigned" {
-			var messageCount int64
-			database.DB.Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Count(&messageCount)
-			
-			if messageCount > 0 {
-				categoryMap[slug].ActiveCount++
-			}
-		}
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
 	}
 
-	// Convert map to slice
-	categories := make([]*Category, 0, len(categoryMap))
-	for _, category := range categoryMap {
-		categories = append(categories, category)
+	category, err := h.stores.Categories.Find(portalID, categoryRef)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Category not found",
+		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"categories": categories,
+		"category": category,
 	})
 }
 
 // AddCategory adds a new category for a portal
-func AddCategory(c *fiber.Ctx) error {
+func (h *Handler) AddCategory(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
 	// Parse request body
 	var req AddCategoryRequest
@@ -114,83 +123,229 @@ func AddCategory(c *fiber.Ctx) error {
 			"error": "Category name is required",
 		})
 	}
-
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
+	if req.RoutingMode != "" && !models.IsRoutingMode(req.RoutingMode) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Routing mode must be one of manual, round_robin or least_busy",
 		})
 	}
 
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleAdmin); err != nil {
+		return accessError(c, err)
+	}
+
 	// Generate slug from category name
-	slug := utils.Slugify(req.Name)
+	categorySlug := slug.Make(req.Name)
 
-	// Check if category already exists
-	var existingConversation models.Conversation
-	result = database.DB.Where("portal_id = ? AND category_slug = ?", portalID, slug).First(&existingConversation)
-	
 	// If category already exists, just return it
-	if result.RowsAffected > 0 {
-		category := Category{
-			ID:          slug,
-			Name:        req.Name,
-			Slug:        slug,
-			PortalID:    portalID,
-			ActiveCount: 0,
-			CreatedAt:   time.Now(),
+	existing, err := h.stores.Categories.GetBySlug(portalID, categorySlug)
+	if err == nil && strings.EqualFold(existing.Name, req.Name) {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"category": existing,
+		})
+	}
+
+	// A different name with the same slug, or a slug still kept for the old
+	// links of a renamed category, gets a numbered suffix
+	if taken, _ := h.stores.Categories.SlugTaken(portalID, categorySlug, ""); taken {
+		categorySlug = slug.Unique(categorySlug, func(candidate string) bool {
+			taken, _ := h.stores.Categories.SlugTaken(portalID, candidate, "")
+			return taken
+		})
+	}
+
+	category := models.Category{
+		PortalID:    portalID,
+		Name:        req.Name,
+		Slug:        categorySlug,
+		Description: req.Description,
+		SortOrder:   req.SortOrder,
+		Enabled:     req.Enabled,
+		RoutingMode: req.RoutingMode,
+	}
+
+	if err := h.stores.Categories.Create(&category); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create category",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"category": category,
+	})
+}
+
+// UpdateCategory updates a category of a portal
+func (h *Handler) UpdateCategory(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID and category ID (or slug) from URL
+	portalID := h.portalIDParam(c)
+	categoryRef := c.Params("categoryId")
+
+	// Parse request body
+	var req UpdateCategoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleAdmin); err != nil {
+		return accessError(c, err)
+	}
+
+	category, err := h.stores.Categories.Find(portalID, categoryRef)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Category not found",
+		})
+	}
+
+	oldSlug := category.Slug
+	if req.Name != nil {
+		if *req.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Category name cannot be empty",
+			})
 		}
+		category.Name = *req.Name
+	}
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"category": category,
+	// The slug only changes when asked for, since it is part of shared links
+	if req.Slug != nil {
+		newSlug := slug.Make(*req.Slug)
+		if newSlug != category.Slug {
+			if taken, _ := h.stores.Categories.SlugTaken(portalID, newSlug, category.ID); taken {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "A category with this slug already exists",
+				})
+			}
+			category.Slug = newSlug
+		}
+	}
+
+	if req.Description != nil {
+		category.Description = *req.Description
+	}
+	if req.SortOrder != nil {
+		category.SortOrder = *req.SortOrder
+	}
+	if req.Enabled != nil {
+		category.Enabled = req.Enabled
+	}
+	if req.RoutingMode != nil {
+		if *req.RoutingMode != "" && !models.IsRoutingMode(*req.RoutingMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Routing mode must be one of manual, round_robin or least_busy",
+			})
+		}
+		category.RoutingMode = *req.RoutingMode
+	}
+
+	// Keep the category name and slug stored on conversations in step, and the
+	// old slug working for links already shared
+	if err := h.stores.Categories.Update(&category, oldSlug); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update category",
 		})
 	}
 
-	// Generate a unique code for the placeholder conversation
-	uniqueCode := utils.GenerateRandomCode()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"category": category,
+	})
+}
+
+// ensureCategory returns the portal's category with the given name, creating it
+// if it does not exist yet. Concurrent callers end up with the same category.
+func (h *Handler) ensureCategory(portalID, name string) (models.Category, error) {
+	category := models.Category{
+		PortalID: portalID,
+		Name:     name,
+		Slug:     slug.Make(name),
+	}
+
+	err := h.stores.Categories.Ensure(&category)
+	return category, err
+}
+
+// resolveCategory finds the category a new conversation is started in. Unknown
+// slugs are refused unless the portal allows ad-hoc categories, in which case
+// the category is created under the given name.
+func (h *Handler) resolveCategory(portal models.Portal, categorySlug, name string) (models.Category, error) {
+	// Links may carry the slug percent-encoded or in a script the slug transliterates
+	categorySlug = slug.FromPath(categorySlug)
 
-	// Ensure the code is unique
-	for {
-		var existingConversation models.Conversation
-		result := database.DB.Where("unique_code = ?", uniqueCode).First(&existingConversation)
-		if result.Error != nil {
-			// No conversation found with this code, it's unique
+	var category models.Category
+	var err error
+	for _, candidate := range []string{categorySlug, slug.Make(categorySlug)} {
+		category, err = h.stores.Categories.GetBySlug(portal.ID, candidate)
+		if !errors.Is(err, store.ErrNotFound) {
 			break
 		}
-		// If code already exists, generate a new one
-		uniqueCode = utils.GenerateRandomCode()
 	}
 
-	// Create a placeholder conversation for this category
-	conversation := models.Conversation{
-		UniqueCode:   uniqueCode,  // Use a generated unique code instead of "placeholder"
-		Category:     req.Name,
-		CategorySlug: slug,
-		CustomerID:   "placeholder",
-		CustomerName: "Unassigned",
-		OwnerID:      userID,
-		PortalID:     portalID,
+	// Links shared before a rename use a slug the category had before
+	if errors.Is(err, store.ErrNotFound) {
+		category, err = h.stores.Categories.GetByOldSlug(portal.ID, categorySlug)
+	}
+	if err == nil {
+		if !category.IsEnabled() {
+			return category, errCategoryDisabled
+		}
+		return category, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return category, err
+	}
+
+	if !portal.AllowAdHocCategories {
+		return category, errCategoryNotFound
+	}
+	if name == "" {
+		name, err = h.stores.Categories.SlugSource(categorySlug)
+		if err != nil {
+			name = utils.NameFromSlug(categorySlug)
+		}
 	}
+	return h.ensureCategory(portal.ID, name)
+}
 
-	result = database.DB.Create(&conversation)
-	if result.Error != nil {
+// categoryError responds to a failed resolveCategory. Unknown and disabled
+// categories get a structured 404 listing the categories that can be used.
+func (h *Handler) categoryError(c *fiber.Ctx, portal models.Portal, slug string, err error) error {
+	code := ""
+	switch {
+	case errors.Is(err, errCategoryNotFound):
+		code = "category_not_found"
+	case errors.Is(err, errCategoryDisabled):
+		code = "category_disabled"
+	default:
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create category",
+			"error": "Failed to resolve category",
 		})
 	}
 
-	// Create category response
-	category := Category{
-		ID:          slug,
-		Name:        req.Name,
-		Slug:        slug,
-		PortalID:    portalID,
-		ActiveCount: 0,
-		CreatedAt:   conversation.CreatedAt,
+	categories, _ := h.stores.Categories.ListByPortal(portal.ID)
+
+	available := make([]fiber.Map, 0, len(categories))
+	for _, category := range categories {
+		if !category.IsEnabled() {
+			continue
+		}
+		available = append(available, fiber.Map{
+			"name": category.Name,
+			"slug": category.Slug,
+		})
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"category": category,
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error":               err.Error(),
+		"code":                code,
+		"portal":              portal.CustomName,
+		"categorySlug":        slug,
+		"availableCategories": available,
 	})
-}
\ No newline at end of file
+}
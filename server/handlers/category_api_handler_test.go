@@ -0,0 +1,163 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+)
+
+func TestAddCategoryReturnsExistingCategory(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+
+	billing := s.category(token, portal.ID, "Billing")
+
+	var again struct {
+		Category models.Category `json:"category"`
+	}
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/portals/" + portal.ID + "/categories", token: token, body: fiber.Map{"name": "billing"}}, &again)
+	if again.Category.ID != billing.ID {
+		t.Fatalf("adding an existing category created %q, want %q", again.Category.ID, billing.ID)
+	}
+}
+
+func TestGetPortalCategoriesCountsActiveConversations(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	billing := s.category(token, portal.ID, "Billing")
+	s.category(token, portal.ID, "Shipping")
+
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+
+	var resp struct {
+		Categories []models.Category `json:"categories"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/categories", token: token}, &resp)
+
+	counts := map[string]int64{}
+	for _, category := range resp.Categories {
+		counts[category.Slug] = category.ActiveCount
+	}
+	if counts["billing"] != 1 || counts["shipping"] != 0 {
+		t.Fatalf("active counts = %v, want billing 1 and shipping 0", counts)
+	}
+	if resp.Categories[0].ID != billing.ID {
+		t.Fatalf("categories are not in display order: %+v", resp.Categories)
+	}
+
+	// Counts go by status, like the active conversations endpoint
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/resolve", token: token}, nil)
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/categories", token: token}, &resp)
+	if resp.Categories[0].ActiveCount != 0 {
+		t.Fatalf("resolved conversation still counts as active: %+v", resp.Categories[0])
+	}
+}
+
+func TestUpdateCategoryMovesConversations(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	billing := s.category(token, portal.ID, "Billing")
+	s.category(token, portal.ID, "Shipping")
+	conversation, _ := s.conversation(token, portal.ID, "Billing", "Grace")
+
+	s.expect(http.StatusConflict, request{method: "PUT", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token, body: fiber.Map{"slug": "shipping"}}, nil)
+	s.expect(http.StatusOK, request{method: "PUT", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token, body: fiber.Map{"name": "Payments", "slug": "payments"}}, nil)
+
+	var resp struct {
+		Conversation models.Conversation `json:"conversation"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversations/" + conversation.ID, token: token}, &resp)
+	if resp.Conversation.Category != "Payments" || resp.Conversation.CategorySlug != "payments" {
+		t.Fatalf("conversation category = %q (%s), want Payments (payments)", resp.Conversation.Category, resp.Conversation.CategorySlug)
+	}
+}
+
+func TestRenamedCategoryLinksStillResolve(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	billing := s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+
+	s.expect(http.StatusOK, request{method: "PUT", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token, body: fiber.Map{"slug": "payments"}}, nil)
+
+	var found struct {
+		Conversation          models.Conversation `json:"conversation"`
+		CanonicalCategorySlug string              `json:"canonicalCategorySlug"`
+		CanonicalURL          string              `json:"canonicalURL"`
+		Redirect              bool                `json:"redirect"`
+	}
+	s.expect(http.StatusOK, request{
+		method:        "GET",
+		path:          "/api/conversation/find/" + portal.CustomName + "/billing/" + conversation.UniqueCode,
+		customerToken: customerToken,
+	}, &found)
+	if found.Conversation.ID != conversation.ID || !found.Redirect || found.CanonicalCategorySlug != "payments" {
+		t.Fatalf("got %+v, want the conversation with a redirect to payments", found)
+	}
+	if found.CanonicalURL != "/portal/"+portal.CustomName+"/payments/"+conversation.UniqueCode {
+		t.Fatalf("canonical URL = %q", found.CanonicalURL)
+	}
+
+	var opened struct {
+		Conversation          models.Conversation `json:"conversation"`
+		CanonicalCategorySlug string              `json:"canonicalCategorySlug"`
+		Redirect              bool                `json:"redirect"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversation/category/" + portal.CustomName + "/billing"}, &opened)
+	if opened.Conversation.CategorySlug != "payments" || !opened.Redirect || opened.CanonicalCategorySlug != "payments" {
+		t.Fatalf("got %+v, want a conversation in the renamed category with a redirect", opened)
+	}
+
+	// Other categories cannot take the old slug while it still redirects
+	var added struct {
+		Category models.Category `json:"category"`
+	}
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/" + portal.ID + "/categories", token: token, body: fiber.Map{"name": "Billing"}}, &added)
+	if added.Category.Slug == "billing" {
+		t.Fatal("a new category took the renamed category's old slug")
+	}
+	s.expect(http.StatusConflict, request{method: "PUT", path: "/api/portals/" + portal.ID + "/categories/" + added.Category.ID, token: token, body: fiber.Map{"slug": "billing"}}, nil)
+
+	// The category itself may take it back
+	s.expect(http.StatusOK, request{method: "PUT", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token, body: fiber.Map{"slug": "billing"}}, nil)
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversation/category/" + portal.CustomName + "/payments"}, &opened)
+	if opened.Conversation.CategorySlug != "billing" || opened.CanonicalCategorySlug != "billing" {
+		t.Fatalf("got %+v, want the old slug to redirect back to billing", opened)
+	}
+}
+
+func TestDeleteCategoryDeletesConversations(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	billing := s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+
+	s.expect(http.StatusOK, request{method: "DELETE", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token}, nil)
+
+	s.expect(http.StatusNotFound, request{method: "GET", path: "/api/portals/" + portal.ID + "/categories/" + billing.ID, token: token}, nil)
+	s.expect(http.StatusNotFound, request{method: "GET", path: "/api/conversations/" + conversation.ID, token: token}, nil)
+	if count, _ := s.stores.Messages.Count(conversation.ID); count != 0 {
+		t.Fatalf("%d messages of the deleted conversation are left", count)
+	}
+}
+
+func TestCategoriesNeedAdminRole(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	viewer, viewerToken := s.user("Bob", "bob@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, viewer, viewerToken, models.RoleViewer)
+
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/categories", token: viewerToken}, nil)
+	s.expect(http.StatusForbidden, request{method: "POST", path: "/api/portals/" + portal.ID + "/categories", token: viewerToken, body: fiber.Map{"name": "Billing"}}, nil)
+}
@@ -3,13 +3,12 @@ package handlers
 import (
 	"github.com/gofiber/fiber/v2"
 
-	"server/database"
 	"server/database/models"
-	"server/utils"
+	"server/slug"
 )
 
 // HandleCategoryAccess generates a new conversation when a user accesses a category URL
-func HandleCategoryAccess(c *fiber.Ctx) error {
+func (h *Handler) HandleCategoryAccess(c *fiber.Ctx) error {
 	// Get parameters from URL
 	portalName := c.Params("portalName")
 	categorySlug := c.Params("categorySlug")
@@ -20,57 +19,56 @@ func HandleCategoryAccess(c *fiber.Ctx) error {
 		})
 	}
 	
-	// First, find the portal by custom name
-	var portal models.Portal
-	portalResult := database.DB.Where("custom_name = ?", portalName).First(&portal)
-	if portalResult.Error != nil {
+	// First, find the portal by custom name (or a name it had before a rename)
+	portal, redirect, err := h.findPortalBySlug(portalName)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Portal not found",
 		})
 	}
 	
-	// Find the original category name from the slug
-	category := utils.Unslugify(categorySlug)
-	
-	// Generate a unique code
-	uniqueCode := utils.GenerateRandomCode()
-
-	// Ensure the code is unique
-	for {
-		var existingConversation models.Conversation
-		result := database.DB.Where("unique_code = ?", uniqueCode).First(&existingConversation)
-		if result.Error != nil {
-			// No conversation found with this code, it's unique
-			break
-		}
-		// If code already exists, generate a new one
-		uniqueCode = utils.GenerateRandomCode()
+	// Only the portal's own categories can be used, unless it allows ad-hoc ones
+	category, err := h.resolveCategory(portal, categorySlug, "")
+	if err != nil {
+		return h.categoryError(c, portal, categorySlug, err)
 	}
 	
-	// Create a placeholder conversation
+	// Create a placeholder conversation under a fresh code
 	conversation := models.Conversation{
-		UniqueCode:   uniqueCode,
-		Category:     category,
-		CategorySlug: categorySlug,
-		CustomerID:   "placeholder", // Will be updated when customer enters their name
-		CustomerName: "Unassigned",  // Will be updated when customer enters their name
+		CategoryID:   &category.ID,
+		Category:     category.Name,
+		CategorySlug: category.Slug,
+		CustomerID:   models.UnclaimedCustomerID, // Will be updated when customer enters their name
+		CustomerName: "Unassigned",                 // Will be updated when customer enters their name
 		OwnerID:      portal.OwnerID,
 		PortalID:     portal.ID,
 	}
 	
-	result := database.DB.Create(&conversation)
-	if result.Error != nil {
+	if err := h.createWithCode(&conversation); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create conversation",
 		})
 	}
 	
-	// Redirect to the full URL with the unique code
-	redirectURL := "/portal/" + portalName + "/" + categorySlug + "/" + uniqueCode
+	// Issue a token the customer uses for access to this conversation
+	customerToken, err := issueCustomerToken(conversation)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate customer token",
+		})
+	}
+	
+	// Redirect to the full URL with the unique code, under the current portal and category names
+	redirectURL := "/portal/" + portal.CustomName + "/" + category.Slug + "/" + conversation.UniqueCode
+	redirect = redirect || category.Slug != slug.FromPath(categorySlug)
 	
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"conversation": conversation,
+		"conversation": publicConversation(conversation),
+		"customerToken": customerToken,
 		"redirectURL": redirectURL,
+		"canonicalSlug": portal.CustomName,
+		"canonicalCategorySlug": category.Slug,
+		"redirect": redirect,
 	})
 }
\ No newline at end of file
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 
-	"server/database"
+	"server/config"
 	"server/database/models"
+	"server/slug"
+	"server/store"
 	"server/utils"
 )
 
@@ -29,31 +33,66 @@ type UpdateCustomerRequest struct {
 	CustomerID   string `json:"customerId" validate:"required"`
 }
 
+// PublicConversation is what the public conversation routes show of a
+// conversation: enough to open the chat, but none of the IDs of the customer,
+// the portal owner or the team.
+type PublicConversation struct {
+	ID           string    `json:"id"`
+	UniqueCode   string    `json:"uniqueCode"`
+	Category     string    `json:"category"`
+	CategorySlug string    `json:"categorySlug"`
+	CustomerName string    `json:"customerName"`
+	Status       string    `json:"status"`
+	PortalID     string    `json:"portalId"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// PublicPortal is what the public conversation routes show of a portal
+type PublicPortal struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CustomName string `json:"customName"`
+}
+
+// publicConversation trims a conversation down to its public fields
+func publicConversation(conversation models.Conversation) PublicConversation {
+	return PublicConversation{
+		ID:           conversation.ID,
+		UniqueCode:   conversation.UniqueCode,
+		Category:     conversation.Category,
+		CategorySlug: conversation.CategorySlug,
+		CustomerName: conversation.CustomerName,
+		Status:       conversation.Status,
+		PortalID:     conversation.PortalID,
+		CreatedAt:    conversation.CreatedAt,
+	}
+}
+
 // GetConversation returns a specific conversation by ID
-func GetConversation(c *fiber.Ctx) error {
+func (h *Handler) GetConversation(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
-	// Find the conversation with messages
-	var conversation models.Conversation
-	result := database.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
-		return db.Order("messages.created_at ASC")
-	}).Where("id = ? AND owner_id = ?", conversationID, userID).First(&conversation)
+	// Verify portal membership
+	if _, _, err := h.authorizeConversation(conversationID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
+	}
 
-	if result.Error != nil {
+	// Find the conversation with messages
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Conversation not found or unauthorized",
 		})
 	}
+	conversation.Messages, _ = h.stores.Messages.ListByConversation(conversationID)
 
 	// Get sender information for each message
 	for i := range conversation.Messages {
-		var sender models.User
-		database.DB.Select("id, name").Where("id = ?", conversation.Messages[i].SenderID).First(&sender)
-		conversation.Messages[i].Sender = sender
+		conversation.Messages[i].Sender = h.teamSender(conversation.Messages[i].SenderID)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -62,27 +101,25 @@ func GetConversation(c *fiber.Ctx) error {
 }
 
 // DeleteConversation deletes a conversation and all its messages
-func DeleteConversation(c *fiber.Ctx) error {
+func (h *Handler) DeleteConversation(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
-	// Verify conversation ownership
-	var conversation models.Conversation
-	result := database.DB.Where("id = ? AND owner_id = ?", conversationID, userID).First(&conversation)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Conversation not found or unauthorized",
-		})
+	// Verify portal membership
+	conversation, _, err := h.authorizeConversation(conversationID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
 	}
 
-	// Delete all messages in the conversation
-	database.DB.Where("conversation_id = ?", conversationID).Delete(&models.Message{})
-
-	// Delete the conversation
-	database.DB.Delete(&conversation)
+	// Delete the conversation along with its messages
+	if err := h.stores.Conversations.Delete(conversation.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete conversation",
+		})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
@@ -90,19 +127,24 @@ func DeleteConversation(c *fiber.Ctx) error {
 }
 
 // GetConversationMessages returns all messages for a conversation
-func GetConversationMessages(c *fiber.Ctx) error {
+func (h *Handler) GetConversationMessages(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
+	// Verify portal membership
+	if _, _, err := h.authorizeConversation(conversationID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
+	}
+
 	// Find all messages for the conversation
-	var messages []models.Message
-	database.DB.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages)
+	messages, _ := h.stores.Messages.ListByConversation(conversationID)
 
 	// Get sender information for each message
 	for i := range messages {
-		var sender models.User
-		database.DB.Select("id, name").Where("id = ?", messages[i].SenderID).First(&sender)
-		messages[i].Sender = sender
+		messages[i].Sender = h.teamSender(messages[i].SenderID)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -110,30 +152,36 @@ func GetConversationMessages(c *fiber.Ctx) error {
 	})
 }
 
-// GetConversationByCode returns a conversation by its unique code
-func GetConversationByCode(c *fiber.Ctx) error {
+// GetConversationByCode returns a conversation by its unique code. Once a
+// customer has claimed the conversation, their customer token is required;
+// before that, anyone may look while the conversation's link works.
+func (h *Handler) GetConversationByCode(c *fiber.Ctx) error {
 	// Get unique code from URL
 	uniqueCode := c.Params("uniqueCode")
 
 	// Find the conversation
-	var conversation models.Conversation
-	result := database.DB.Preload("Portal", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id, name, custom_name")
-	}).Where("unique_code = ?", uniqueCode).First(&conversation)
-
-	if result.Error != nil {
+	conversation, err := h.stores.Conversations.GetByCode(uniqueCode)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Conversation not found",
 		})
 	}
 
+	// Only the customer may see a claimed conversation, and others only while the link works
+	if err := openLink(c, conversation); err != nil {
+		return openLinkError(c, err)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"conversation": conversation,
+		"conversation": publicConversation(conversation),
+		"claimed":      conversation.Claimed(),
 	})
 }
 
-// GetConversationByURLParams returns a conversation by the URL parameters (portal name, category, unique code)
-func GetConversationByURLParams(c *fiber.Ctx) error {
+// GetConversationByURLParams returns a conversation by the URL parameters (portal name, category, unique code).
+// Once a customer has claimed the conversation, their customer token is required;
+// before that, anyone may look while the conversation's link works.
+func (h *Handler) GetConversationByURLParams(c *fiber.Ctx) error {
 	// Get parameters from URL
 	portalName := c.Params("portalName")
 	categorySlug := c.Params("categorySlug")
@@ -145,38 +193,52 @@ func GetConversationByURLParams(c *fiber.Ctx) error {
 		})
 	}
 	
-	// First, find the portal by custom name
-	var portal models.Portal
-	portalResult := database.DB.Where("custom_name = ?", portalName).First(&portal)
-	if portalResult.Error != nil {
+	// First, find the portal by custom name (or a name it had before a rename)
+	portal, redirect, err := h.findPortalBySlug(portalName)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Portal not found",
 		})
 	}
 	
 	// Then, find the conversation
-	var conversation models.Conversation
-	result := database.DB.Where(
-		"portal_id = ? AND category_slug = ? AND unique_code = ?",
-		portal.ID, categorySlug, uniqueCode,
-	).Preload("Portal", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id, name, custom_name")
-	}).First(&conversation)
-
-	if result.Error != nil {
+	categorySlug = slug.FromPath(categorySlug)
+	conversation, err := h.stores.Conversations.GetByURL(portal.ID, categorySlug, uniqueCode)
+	if errors.Is(err, store.ErrNotFound) {
+		// The link may use a slug the category had before a rename
+		var category models.Category
+		if category, err = h.stores.Categories.GetByOldSlug(portal.ID, categorySlug); err == nil {
+			conversation, err = h.stores.Conversations.GetByURL(portal.ID, category.Slug, uniqueCode)
+			redirect = true
+		}
+	}
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Conversation not found",
 		})
 	}
 
+	// Only the customer may see a claimed conversation, and others only while the link works
+	if err := openLink(c, conversation); err != nil {
+		return openLinkError(c, err)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"conversation": conversation,
-		"portal": portal,
+		"conversation": publicConversation(conversation),
+		"claimed": conversation.Claimed(),
+		"portal": PublicPortal{ID: portal.ID, Name: portal.Name, CustomName: portal.CustomName},
+		"canonicalSlug": portal.CustomName,
+		"canonicalCategorySlug": conversation.CategorySlug,
+		"canonicalURL": "/portal/" + portal.CustomName + "/" + conversation.CategorySlug + "/" + conversation.UniqueCode,
+		"redirect": redirect,
 	})
 }
 
-// UpdateCustomerInfo updates customer information for a conversation
-func UpdateCustomerInfo(c *fiber.Ctx) error {
+// UpdateCustomerInfo updates customer information for a conversation. The
+// first call claims a conversation created from a link, using the link up
+// once, and needs no token; later calls need the customer token issued by the
+// first.
+func (h *Handler) UpdateCustomerInfo(c *fiber.Ctx) error {
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
@@ -196,26 +258,58 @@ func UpdateCustomerInfo(c *fiber.Ctx) error {
 	}
 
 	// Find the conversation
-	var conversation models.Conversation
-	result := database.DB.Where("id = ?", conversationID).First(&conversation)
-	if result.Error != nil {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Conversation not found",
 		})
 	}
 
-	// Update customer information
-	conversation.CustomerName = req.CustomerName
-	conversation.CustomerID = req.CustomerID
-	database.DB.Save(&conversation)
+	if conversation.Claimed() {
+		// Only the customer may change their details, and the customer ID stays
+		if err := authorizeCustomer(c, conversation); err != nil {
+			return customerTokenError(c)
+		}
+
+		conversation.CustomerName = req.CustomerName
+		if err := h.stores.Conversations.UpdateCustomer(conversation.ID, conversation.CustomerID, conversation.CustomerName); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update customer information",
+			})
+		}
+	} else {
+		// A link that was revoked, has expired or is used up can no longer be claimed
+		version, err := h.claimLink(conversation, req.CustomerID, req.CustomerName)
+		var dead deadLinkError
+		if errors.Is(err, errCustomerToken) || errors.As(err, &dead) {
+			return openLinkError(c, err)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update customer information",
+			})
+		}
+		conversation.CustomerID = req.CustomerID
+		conversation.CustomerName = req.CustomerName
+		conversation.CustomerTokenVersion = version
+	}
+
+	// Issue a token the customer uses for access to this conversation
+	customerToken, err := issueCustomerToken(conversation)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate customer token",
+		})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"conversation": conversation,
+		"conversation":  publicConversation(conversation),
+		"customerToken": customerToken,
 	})
 }
 
 // CreateConversation creates a new conversation
-func CreateConversation(c *fiber.Ctx) error {
+func (h *Handler) CreateConversation(c *fiber.Ctx) error {
 	// Parse request body
 	var req CreateConversationRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -232,95 +326,111 @@ func CreateConversation(c *fiber.Ctx) error {
 	}
 
 	// Find the portal to get the owner ID
-	var portal models.Portal
-	result := database.DB.Where("id = ?", req.PortalID).First(&portal)
-	if result.Error != nil {
+	portal, err := h.stores.Portals.GetByID(req.PortalID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Portal not found",
 		})
 	}
 
-	// Generate a unique code
-	uniqueCode := utils.GenerateRandomCode()
+	// Create category slug
+	categorySlug := slug.Make(req.Category)
 
-	// Ensure the code is unique
-	for {
-		var existingConversation models.Conversation
-		result := database.DB.Where("unique_code = ?", uniqueCode).First(&existingConversation)
-		if result.Error != nil {
-			// No conversation found with this code, it's unique
-			break
-		}
-		// If code already exists, generate a new one
-		uniqueCode = utils.GenerateRandomCode()
+	// Only the portal's own categories can be used, unless it allows ad-hoc ones
+	category, err := h.resolveCategory(portal, categorySlug, req.Category)
+	if err != nil {
+		return h.categoryError(c, portal, categorySlug, err)
 	}
 
-	// Create category slug - Updated to use utils.Slugify
-	categorySlug := utils.Slugify(req.Category)
+	// Generate a unique customer ID
+	customerID, err := utils.GenerateCode(config.DefaultConversationCodeAlphabet, 16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create conversation",
+		})
+	}
 
 	// Create a new conversation
 	conversation := models.Conversation{
-		UniqueCode:   uniqueCode,
-		Category:     req.Category,
-		CategorySlug: categorySlug,
-		CustomerID:   "customer-" + utils.GenerateRandomCode(), // Generate a unique customer ID
+		CategoryID:   &category.ID,
+		Category:     category.Name,
+		CategorySlug: category.Slug,
+		CustomerID:   "customer-" + customerID,
 		CustomerName: req.CustomerName,
 		OwnerID:      portal.OwnerID,
 		PortalID:     req.PortalID,
 	}
 
-	result = database.DB.Create(&conversation)
-	if result.Error != nil {
+	if err := h.createWithCode(&conversation); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create conversation",
 		})
 	}
 
+	// Issue a token the customer uses for access to this conversation
+	customerToken, err := issueCustomerToken(conversation)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate customer token",
+		})
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"conversation": conversation,
+		"conversation":  publicConversation(conversation),
+		"customerToken": customerToken,
 	})
 }
 
-// GetPublicConversation returns basic public information about a conversation
-func GetPublicConversation(c *fiber.Ctx) error {
+// GetPublicConversation returns basic public information about a conversation to its customer
+func (h *Handler) GetPublicConversation(c *fiber.Ctx) error {
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
 	// Find the conversation
-	var conversation models.Conversation
-	result := database.DB.Select("id, customer_name, category, category_slug, portal_id").Where("id = ?", conversationID).First(&conversation)
-	if result.Error != nil {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Conversation not found",
 		})
 	}
 
+	if err := authorizeCustomer(c, conversation); err != nil {
+		return customerTokenError(c)
+	}
+
+	// Customers only see what identifies the conversation
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"conversation": conversation,
+		"conversation": publicConversation(conversation),
 	})
 }
 
-// GetPublicConversationMessages returns messages for a public conversation
-func GetPublicConversationMessages(c *fiber.Ctx) error {
+// GetPublicConversationMessages returns messages for a public conversation to its customer
+func (h *Handler) GetPublicConversationMessages(c *fiber.Ctx) error {
 	// Get conversation ID from URL
 	conversationID := c.Params("id")
 
+	// Find the conversation
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Conversation not found",
+		})
+	}
+
+	if err := authorizeCustomer(c, conversation); err != nil {
+		return customerTokenError(c)
+	}
+
 	// Find all messages for the conversation
-	var messages []models.Message
-	database.DB.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages)
+	messages, _ := h.stores.Messages.ListByConversation(conversationID)
 
 	// Get sender information for each message
 	for i := range messages {
 		if messages[i].IsOwner {
 			// For owner messages, fetch the user
-			var sender models.User
-			database.DB.Select("id, name").Where("id = ?", messages[i].SenderID).First(&sender)
-			messages[i].Sender = sender
+			messages[i].Sender = h.teamSender(messages[i].SenderID)
 		} else {
 			// For customer messages, use the message's sender ID and conversation's customer name
-			var conversation models.Conversation
-			database.DB.Select("customer_name").Where("id = ?", conversationID).First(&conversation)
-			
 			messages[i].Sender = models.User{
 				ID:   messages[i].SenderID,
 				Name: conversation.CustomerName,
@@ -331,4 +441,27 @@ func GetPublicConversationMessages(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"messages": messages,
 	})
-}
\ No newline at end of file
+}
+
+// maxCodeAttempts bounds how often creating a conversation is retried with a
+// new code after the code turned out to be taken
+const maxCodeAttempts = 5
+
+// createWithCode stores a new conversation under a fresh random code. The
+// unique index on unique_code settles collisions, so two requests can never
+// end up with the same code.
+func (h *Handler) createWithCode(conversation *models.Conversation) error {
+	var err error
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		conversation.UniqueCode, err = utils.NewConversationCode()
+		if err != nil {
+			return err
+		}
+
+		err = h.stores.Conversations.Create(conversation)
+		if !errors.Is(err, store.ErrDuplicate) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+)
+
+// errConversationClosed is returned when a message is sent to a closed conversation
+var errConversationClosed = errors.New("Conversation is closed")
+
+// UpdateConversationStatusRequest represents the expected body for changing a conversation's status
+type UpdateConversationStatusRequest struct {
+	Status string `json:"status" validate:"required"`
+	// SnoozedUntil is required when snoozing
+	SnoozedUntil *time.Time `json:"snoozedUntil"`
+}
+
+// UpdateConversationStatus moves a conversation to a new status
+func (h *Handler) UpdateConversationStatus(c *fiber.Ctx) error {
+	// Parse request body
+	var req UpdateConversationStatusRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Validate input
+	if !models.IsConversationStatus(req.Status) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Status must be one of open, pending, snoozed, resolved or closed",
+		})
+	}
+
+	return h.changeConversationStatus(c, req.Status, req.SnoozedUntil)
+}
+
+// ResolveConversation marks a conversation as resolved
+func (h *Handler) ResolveConversation(c *fiber.Ctx) error {
+	return h.changeConversationStatus(c, models.ConversationResolved, nil)
+}
+
+// ReopenConversation moves a resolved, pending or snoozed conversation back to open
+func (h *Handler) ReopenConversation(c *fiber.Ctx) error {
+	return h.changeConversationStatus(c, models.ConversationOpen, nil)
+}
+
+// changeConversationStatus applies a status change requested by a team member
+func (h *Handler) changeConversationStatus(c *fiber.Ctx, status string, snoozedUntil *time.Time) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Verify portal membership
+	conversation, _, err := h.authorizeConversation(conversationID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	if status == models.ConversationSnoozed {
+		if snoozedUntil == nil || !snoozedUntil.After(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "snoozedUntil must be a time in the future",
+			})
+		}
+	} else {
+		snoozedUntil = nil
+	}
+
+	// Re-snoozing just moves the wake-up time; other repeats are no-ops
+	current := conversation.CurrentStatus()
+	if current == status && status != models.ConversationSnoozed {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"conversation": conversation,
+		})
+	}
+	if current != status && !conversation.CanTransitionTo(status) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Cannot change a " + current + " conversation to " + status,
+		})
+	}
+
+	if err := h.setConversationStatus(&conversation, status, snoozedUntil); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update conversation status",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"conversation": conversation,
+	})
+}
+
+// setConversationStatus stores a conversation's new status and announces it to the room
+func (h *Handler) setConversationStatus(conversation *models.Conversation, status string, snoozedUntil *time.Time) error {
+	now := time.Now()
+
+	var resolvedAt *time.Time
+	if status == models.ConversationResolved || status == models.ConversationClosed {
+		resolvedAt = &now
+	}
+
+	if err := h.stores.Conversations.UpdateStatus(conversation.ID, status, snoozedUntil, resolvedAt); err != nil {
+		return err
+	}
+
+	conversation.Status = status
+	conversation.SnoozedUntil = snoozedUntil
+	conversation.ResolvedAt = resolvedAt
+
+	h.events.Publish(realtime.NewStatusEvent(conversation.ID, status, snoozedUntil, now))
+	return nil
+}
+
+// reopenOnCustomerReply moves a pending, snoozed or resolved conversation back
+// to open when the customer writes in it
+func (h *Handler) reopenOnCustomerReply(conversationID string) {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		return
+	}
+
+	switch conversation.Status {
+	case models.ConversationPending, models.ConversationSnoozed, models.ConversationResolved:
+		h.setConversationStatus(&conversation, models.ConversationOpen, nil)
+	}
+}
+
+// WakeSnoozedConversations reopens the snoozed conversations whose snooze
+// ran out by now and announces them to their rooms
+func (h *Handler) WakeSnoozedConversations(now time.Time) error {
+	conversations, err := h.stores.Conversations.WakeSnoozed(now)
+	if err != nil {
+		return err
+	}
+
+	for _, conversation := range conversations {
+		h.events.Publish(realtime.NewStatusEvent(conversation.ID, models.ConversationOpen, nil, now))
+	}
+	return nil
+}
+
+// StartSnoozeTimer wakes snoozed conversations every interval until Close
+func (h *Handler) StartSnoozeTimer(interval time.Duration) {
+	h.workers.Add(1)
+	go func() {
+		defer h.workers.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := h.WakeSnoozedConversations(now); err != nil {
+					log.Printf("Failed to wake snoozed conversations: %v", err)
+				}
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// statusFilter parses the comma-separated `status` query parameter of the list
+// endpoints. It returns fallback when the parameter is absent.
+func statusFilter(c *fiber.Ctx, fallback []string) ([]string, error) {
+	param := c.Query("status")
+	if param == "" {
+		return fallback, nil
+	}
+
+	var statuses []string
+	for _, status := range strings.Split(param, ",") {
+		status = strings.TrimSpace(status)
+		if !models.IsConversationStatus(status) {
+			return nil, errors.New("Unknown conversation status: " + status)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
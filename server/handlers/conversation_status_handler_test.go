@@ -0,0 +1,167 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+)
+
+// setStatus changes a conversation's status through the API and returns the response status
+func (s *testServer) setStatus(token, conversationID string, body fiber.Map) int {
+	s.t.Helper()
+
+	return s.do(request{method: "PUT", path: "/api/conversations/" + conversationID + "/status", token: token, body: body}, nil)
+}
+
+// status returns a conversation's stored status
+func (s *testServer) status(conversationID string) string {
+	s.t.Helper()
+
+	conversation, err := s.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		s.t.Fatalf("get conversation: %v", err)
+	}
+	return conversation.CurrentStatus()
+}
+
+func TestClosedConversationsStayClosed(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+
+	if status := s.setStatus(token, conversation.ID, fiber.Map{"status": models.ConversationClosed}); status != http.StatusOK {
+		t.Fatalf("closing returned %d", status)
+	}
+
+	snoozedUntil := time.Now().Add(time.Hour)
+	for _, body := range []fiber.Map{
+		{"status": models.ConversationOpen},
+		{"status": models.ConversationPending},
+		{"status": models.ConversationSnoozed, "snoozedUntil": snoozedUntil},
+		{"status": models.ConversationResolved},
+	} {
+		if status := s.setStatus(token, conversation.ID, body); status != http.StatusConflict {
+			t.Errorf("moving a closed conversation to %v returned %d, want %d", body["status"], status, http.StatusConflict)
+		}
+	}
+	s.expect(http.StatusConflict, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/reopen", token: token}, nil)
+	s.expect(http.StatusConflict, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/resolve", token: token}, nil)
+
+	// Closed conversations take no more messages either
+	s.expect(http.StatusConflict, request{
+		method:        "POST",
+		path:          "/api/messages",
+		customerToken: customerToken,
+		body:          fiber.Map{"conversationId": conversation.ID, "content": "Hello?"},
+	}, nil)
+	if status := s.status(conversation.ID); status != models.ConversationClosed {
+		t.Fatalf("status %s, want closed", status)
+	}
+}
+
+func TestSnoozeMustBeInTheFuture(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation, _ := s.conversation(token, portal.ID, "Billing", "Grace")
+
+	for _, body := range []fiber.Map{
+		{"status": models.ConversationSnoozed},
+		{"status": models.ConversationSnoozed, "snoozedUntil": time.Now().Add(-time.Minute)},
+	} {
+		if status := s.setStatus(token, conversation.ID, body); status != http.StatusBadRequest {
+			t.Errorf("snoozing with %v returned %d, want %d", body["snoozedUntil"], status, http.StatusBadRequest)
+		}
+	}
+	if status := s.status(conversation.ID); status != models.ConversationOpen {
+		t.Fatalf("status %s after refused snoozes, want open", status)
+	}
+
+	if status := s.setStatus(token, conversation.ID, fiber.Map{"status": models.ConversationSnoozed, "snoozedUntil": time.Now().Add(time.Hour)}); status != http.StatusOK {
+		t.Fatalf("snoozing for an hour returned %d", status)
+	}
+	if status := s.status(conversation.ID); status != models.ConversationSnoozed {
+		t.Fatalf("status %s, want snoozed", status)
+	}
+}
+
+func TestCustomerReplyReopensConversation(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+
+	for _, body := range []fiber.Map{
+		{"status": models.ConversationPending},
+		{"status": models.ConversationSnoozed, "snoozedUntil": time.Now().Add(time.Hour)},
+		{"status": models.ConversationResolved},
+	} {
+		conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+		if status := s.setStatus(token, conversation.ID, body); status != http.StatusOK {
+			t.Fatalf("setting %v returned %d", body["status"], status)
+		}
+
+		s.customerMessage(conversation.ID, customerToken, "Still there?")
+		if status := s.status(conversation.ID); status != models.ConversationOpen {
+			t.Errorf("a %v conversation is %s after the customer replied, want open", body["status"], status)
+		}
+	}
+}
+
+func TestWakeSnoozedConversations(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	short, _ := s.conversation(token, portal.ID, "Billing", "Grace")
+	long, _ := s.conversation(token, portal.ID, "Billing", "Linus")
+
+	s.setStatus(token, short.ID, fiber.Map{"status": models.ConversationSnoozed, "snoozedUntil": time.Now().Add(time.Hour)})
+	s.setStatus(token, long.ID, fiber.Map{"status": models.ConversationSnoozed, "snoozedUntil": time.Now().Add(3 * time.Hour)})
+	watcher := s.watch(short.ID)
+
+	// Listing conversations leaves the snoozes alone
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/conversations", token: token}, nil)
+	if status := s.status(short.ID); status != models.ConversationSnoozed {
+		t.Fatalf("status %s after listing, want snoozed", status)
+	}
+
+	if err := s.handler.WakeSnoozedConversations(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatalf("wake: %v", err)
+	}
+	if status := s.status(short.ID); status != models.ConversationOpen {
+		t.Fatalf("status %s after the snooze ran out, want open", status)
+	}
+	if status := s.status(long.ID); status != models.ConversationSnoozed {
+		t.Fatalf("status %s before the snooze ran out, want snoozed", status)
+	}
+
+	// The room hears that the conversation woke up
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case frame := <-watcher.Frames():
+			var event realtime.Event
+			if err := json.Unmarshal(frame.Data, &event); err != nil {
+				t.Fatalf("decode frame %s: %v", frame.Data, err)
+			}
+			if event.Type == realtime.EventStatus {
+				if data := event.Data.(realtime.StatusData); data.Status != models.ConversationOpen {
+					t.Fatalf("status event %+v, want open", data)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("no status event arrived")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+	"server/utils"
+)
+
+// errCustomerToken is returned when a public conversation route is called
+// without a valid token for that conversation
+var errCustomerToken = errors.New("A valid customer token for this conversation is required")
+
+// customerTokenFrom reads the customer token of a public request, sent in the
+// X-Customer-Token header or, where headers cannot be set, the customerToken query parameter
+func customerTokenFrom(c *fiber.Ctx) string {
+	if token := c.Get("X-Customer-Token"); token != "" {
+		return token
+	}
+	return c.Query("customerToken")
+}
+
+// authorizeCustomer checks that the request carries a customer token issued
+// for the conversation and not revoked since
+func authorizeCustomer(c *fiber.Ctx, conversation models.Conversation) error {
+	token := customerTokenFrom(c)
+	if token == "" {
+		return errCustomerToken
+	}
+
+	claims, err := utils.ParseCustomerToken(token)
+	if err != nil {
+		return errCustomerToken
+	}
+	if claims.ConversationID != conversation.ID || claims.Version != conversation.CustomerTokenVersion {
+		return errCustomerToken
+	}
+	return nil
+}
+
+// customerTokenError responds to a failed customer token check
+func customerTokenError(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": errCustomerToken.Error(),
+		"code":  "customer_token_required",
+	})
+}
+
+// issueCustomerToken creates the token a customer uses for a conversation
+func issueCustomerToken(conversation models.Conversation) (string, error) {
+	return utils.GenerateCustomerToken(conversation.ID, conversation.CustomerID, conversation.CustomerTokenVersion)
+}
+
+// RevokeCustomerTokens invalidates every token issued to a conversation's
+// customer, for example after a link was shared by mistake, and disconnects
+// real-time subscribers using one. The response carries a fresh token the team
+// can pass on to the customer.
+func (h *Handler) RevokeCustomerTokens(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Agents and above may revoke
+	conversation, _, err := h.authorizeConversation(conversationID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	version, err := h.stores.Conversations.RevokeCustomerTokens(conversation.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke customer tokens",
+		})
+	}
+	conversation.CustomerTokenVersion = version
+
+	// Disconnect whoever is still connected with a revoked token
+	h.events.Publish(realtime.NewTokensRevokedEvent(conversation.ID, version))
+
+	customerToken, err := issueCustomerToken(conversation)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate customer token",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":       true,
+		"customerToken": customerToken,
+	})
+}
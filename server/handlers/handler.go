@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+
+	"server/mail"
+	"server/realtime"
+	"server/store"
+)
+
+// backgroundQueueSize is how many jobs may wait for the background worker
+// before more are dropped
+const backgroundQueueSize = 100
+
+// Handler serves the API. It holds everything the handlers read, write and
+// send, so that tests can run them against the in-memory stores.
+type Handler struct {
+	// stores is where users, portals, conversations and messages are kept
+	stores store.Stores
+	// events is where real-time events are published
+	events realtime.Publisher
+	// mailer sends the emails of the account and team flows
+	mailer mail.Mailer
+
+	// background holds the work that runs after a response was sent, such
+	// as emails that the client must not wait for
+	background chan func()
+	// closeMu guards closed, so that no job is queued after Close
+	closeMu sync.Mutex
+	closed  bool
+	// stop is closed by Close to end the timers
+	stop chan struct{}
+	// workers counts the goroutines Close waits for
+	workers sync.WaitGroup
+}
+
+// New returns a Handler working on stores. A nil events publisher or mailer
+// drops what would have been published or sent. Close must be called to
+// finish the background work.
+func New(stores store.Stores, events realtime.Publisher, mailer mail.Mailer) *Handler {
+	if events == nil {
+		events = realtime.Discard
+	}
+	if mailer == nil {
+		mailer = mail.Discard
+	}
+	h := &Handler{
+		stores:     stores,
+		events:     events,
+		mailer:     mailer,
+		background: make(chan func(), backgroundQueueSize),
+		stop:       make(chan struct{}),
+	}
+	h.workers.Add(1)
+	go h.runBackground()
+	return h
+}
+
+// Close stops the timers and waits for the queued background work to
+// finish. Jobs queued after Close are dropped.
+func (h *Handler) Close() {
+	h.closeMu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.background)
+		close(h.stop)
+	}
+	h.closeMu.Unlock()
+	h.workers.Wait()
+}
+
+// runLater queues job for the background worker. When the queue is full the
+// job is dropped and logged, so that a burst of requests cannot pile up
+// goroutines.
+func (h *Handler) runLater(name string, job func()) {
+	h.closeMu.Lock()
+	defer h.closeMu.Unlock()
+
+	if h.closed {
+		log.Printf("Dropped %s: shutting down", name)
+		return
+	}
+	select {
+	case h.background <- job:
+	default:
+		log.Printf("Dropped %s: background queue is full", name)
+	}
+}
+
+// runBackground runs the queued jobs one at a time until Close
+func (h *Handler) runBackground() {
+	defer h.workers.Done()
+	for job := range h.background {
+		job()
+	}
+}
@@ -0,0 +1,185 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/handlers"
+	"server/mail"
+	"server/realtime"
+	"server/routes"
+	"server/store"
+	"server/store/memory"
+	"server/utils"
+)
+
+// testServer runs the API routes against the in-memory stores
+type testServer struct {
+	t       *testing.T
+	app     *fiber.App
+	hub     *realtime.Hub
+	handler *handlers.Handler
+	stores  store.Stores
+	mailer  *mail.Memory
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	hub := realtime.NewHub(realtime.DefaultOptions())
+	if err := hub.Start(); err != nil {
+		t.Fatalf("start hub: %v", err)
+	}
+	t.Cleanup(hub.Close)
+
+	stores := memory.New()
+	mailer := mail.NewMemory()
+	// The memory stores keep the strings they are given, which must not be
+	// backed by Fiber's reused request buffers
+	app := fiber.New(fiber.Config{Immutable: true, DisableStartupMessage: true})
+	h := handlers.New(stores, hub, mailer)
+	t.Cleanup(h.Close)
+	routes.SetupRoutes(app, hub, h, stores.Users)
+
+	return &testServer{t: t, app: app, hub: hub, handler: h, stores: stores, mailer: mailer}
+}
+
+// request describes a call to the API
+type request struct {
+	method string
+	path   string
+	body   interface{}
+	// token is a support user's JWT
+	token string
+	// customerToken is sent in the X-Customer-Token header
+	customerToken string
+}
+
+// do sends a request and decodes the JSON response into out, if given. It
+// returns the response status.
+func (s *testServer) do(req request, out interface{}) int {
+	s.t.Helper()
+
+	var body io.Reader
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			s.t.Fatalf("encode %s %s: %v", req.method, req.path, err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq := httptest.NewRequest(req.method, req.path, body)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if req.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	if req.customerToken != "" {
+		httpReq.Header.Set("X-Customer-Token", req.customerToken)
+	}
+
+	resp, err := s.app.Test(httpReq, -1)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			s.t.Fatalf("decode %s %s: %v", req.method, req.path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// expect sends a request, fails the test unless it gets the wanted status,
+// and decodes the JSON response into out, if given
+func (s *testServer) expect(want int, req request, out interface{}) {
+	s.t.Helper()
+
+	var raw json.RawMessage
+	if status := s.do(req, &raw); status != want {
+		s.t.Fatalf("%s %s: got status %d, want %d: %s", req.method, req.path, status, want, raw)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			s.t.Fatalf("decode %s %s: %v", req.method, req.path, err)
+		}
+	}
+}
+
+// user creates a support user and returns it with an access token
+func (s *testServer) user(name, email string) (models.User, string) {
+	s.t.Helper()
+
+	user := models.User{Name: name, Email: email}
+	if err := s.stores.Users.Create(&user); err != nil {
+		s.t.Fatalf("create user %s: %v", email, err)
+	}
+	token, err := utils.GenerateToken(user.ID, user.Email, user.Name, user.TokenVersion)
+	if err != nil {
+		s.t.Fatalf("generate token: %v", err)
+	}
+	return user, token
+}
+
+// portal creates a portal through the API
+func (s *testServer) portal(token, name string) models.Portal {
+	s.t.Helper()
+
+	var resp struct {
+		Portal models.Portal `json:"portal"`
+	}
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/", token: token, body: fiber.Map{"name": name}}, &resp)
+	return resp.Portal
+}
+
+// category adds a category to a portal through the API
+func (s *testServer) category(token, portalID, name string) models.Category {
+	s.t.Helper()
+
+	var resp struct {
+		Category models.Category `json:"category"`
+	}
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/" + portalID + "/categories", token: token, body: fiber.Map{"name": name}}, &resp)
+	return resp.Category
+}
+
+// conversation generates a link in a category and claims it as a customer,
+// returning the conversation and the customer's token
+func (s *testServer) conversation(token, portalID, category, customerName string) (models.Conversation, string) {
+	s.t.Helper()
+
+	var link handlers.LinkResponse
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/" + portalID + "/generate-link", token: token, body: fiber.Map{"category": category}}, &link)
+
+	var claimed struct {
+		Conversation  models.Conversation `json:"conversation"`
+		CustomerToken string              `json:"customerToken"`
+	}
+	s.expect(http.StatusOK, request{
+		method: "PUT",
+		path:   "/api/conversation/" + link.Conversation.ID + "/update-customer",
+		body:   fiber.Map{"customerName": customerName, "customerId": "customer-" + customerName},
+	}, &claimed)
+	return claimed.Conversation, claimed.CustomerToken
+}
+
+// customerMessage sends a message as a conversation's customer
+func (s *testServer) customerMessage(conversationID, customerToken, content string) {
+	s.t.Helper()
+
+	s.expect(http.StatusCreated, request{
+		method:        "POST",
+		path:          "/api/messages",
+		customerToken: customerToken,
+		body:          fiber.Map{"conversationId": conversationID, "content": content},
+	}, nil)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+	"server/store"
+)
+
+// linkErrors describes why a conversation link stopped working, by link state
+var linkErrors = map[string]string{
+	models.LinkExpired: "This conversation link has expired",
+	models.LinkRevoked: "This conversation link has been revoked",
+	models.LinkUsedUp:  "This conversation link has already been used",
+}
+
+// claimLink hands an unclaimed conversation to a customer, which counts as a
+// use of its link. Claiming revokes any token issued before, so only this
+// customer holds one, and disconnects real-time subscribers using one. It
+// returns the new customer token version.
+func (h *Handler) claimLink(conversation models.Conversation, customerID, customerName string) (int, error) {
+	now := time.Now()
+	if state := conversation.LinkState(now); state != models.LinkActive {
+		return 0, deadLinkError{state: state}
+	}
+
+	version, err := h.stores.Conversations.Claim(conversation.ID, customerID, customerName, now)
+	if errors.Is(err, store.ErrNotFound) {
+		// Someone else claimed it, or the link stopped working, in the meantime
+		conversation, err = h.stores.Conversations.GetByID(conversation.ID)
+		if err != nil {
+			return 0, err
+		}
+		if conversation.Claimed() {
+			return 0, errCustomerToken
+		}
+		if state := conversation.LinkState(now); state != models.LinkActive {
+			return 0, deadLinkError{state: state}
+		}
+		return 0, deadLinkError{state: models.LinkUsedUp}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	h.events.Publish(realtime.NewTokensRevokedEvent(conversation.ID, version))
+	return version, nil
+}
+
+// deadLinkError is returned when a conversation link no longer works
+type deadLinkError struct {
+	state string
+}
+
+func (e deadLinkError) Error() string {
+	return linkErrors[e.state]
+}
+
+// openLink checks a request for a conversation found through its link. The
+// customer holding a valid token always gets through; anyone else only while
+// the conversation is unclaimed and its link works. Opening the link does not
+// use it up; only claiming the conversation does.
+func openLink(c *fiber.Ctx, conversation models.Conversation) error {
+	if authorizeCustomer(c, conversation) == nil {
+		return nil
+	}
+	if conversation.Claimed() {
+		return errCustomerToken
+	}
+	if state := conversation.LinkState(time.Now()); state != models.LinkActive {
+		return deadLinkError{state: state}
+	}
+	return nil
+}
+
+// openLinkError responds to a failed openLink check
+func openLinkError(c *fiber.Ctx, err error) error {
+	var dead deadLinkError
+	switch {
+	case errors.Is(err, errCustomerToken):
+		return customerTokenError(c)
+	case errors.As(err, &dead):
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": dead.Error(),
+			"code":  "link_" + dead.state,
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to open conversation link",
+	})
+}
+
+// RevokeConversationLink turns off the link of a conversation, so that nobody
+// without a customer token can open it any more. A customer who already
+// claimed the conversation keeps access through their token.
+func (h *Handler) RevokeConversationLink(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Agents and above may revoke
+	conversation, _, err := h.authorizeConversation(conversationID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	revokedAt := time.Now()
+	if err := h.stores.Conversations.RevokeLink(conversation.ID, revokedAt); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke conversation link",
+		})
+	}
+	if conversation.LinkRevokedAt == nil {
+		conversation.LinkRevokedAt = &revokedAt
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":      true,
+		"conversation": conversation,
+	})
+}
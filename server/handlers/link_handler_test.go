@@ -0,0 +1,141 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/handlers"
+)
+
+// link generates a conversation link with the given options
+func (s *testServer) link(token, portalID string, options fiber.Map) models.Conversation {
+	s.t.Helper()
+
+	var link handlers.LinkResponse
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/" + portalID + "/generate-link", token: token, body: options}, &link)
+	return link.Conversation
+}
+
+// claim claims a conversation as a customer and returns the response status
+func (s *testServer) claim(conversationID, customerName string) int {
+	s.t.Helper()
+
+	return s.do(request{
+		method: "PUT",
+		path:   "/api/conversation/" + conversationID + "/update-customer",
+		body:   fiber.Map{"customerName": customerName, "customerId": "customer-" + customerName},
+	}, nil)
+}
+
+func TestViewingALinkDoesNotUseIt(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation := s.link(token, portal.ID, fiber.Map{"category": "Billing", "maxUses": 1})
+
+	// Link previews and reloads before the customer claims it are free
+	for i := 0; i < 3; i++ {
+		s.expect(http.StatusOK, request{method: "GET", path: "/api/conversation/code/" + conversation.UniqueCode}, nil)
+	}
+
+	if status := s.claim(conversation.ID, "Grace"); status != http.StatusOK {
+		t.Fatalf("claiming a fresh link: got status %d, want 200", status)
+	}
+
+	stored, _ := s.stores.Conversations.GetByID(conversation.ID)
+	if stored.LinkUses != 1 || stored.LinkState(stored.UpdatedAt) != models.LinkUsedUp {
+		t.Fatalf("link uses = %d (%s), want 1 (used_up)", stored.LinkUses, stored.LinkState(stored.UpdatedAt))
+	}
+
+	// The claimed conversation is only open to its customer now
+	s.expect(http.StatusUnauthorized, request{method: "GET", path: "/api/conversation/code/" + conversation.UniqueCode}, nil)
+	if status := s.claim(conversation.ID, "Linus"); status != http.StatusUnauthorized {
+		t.Fatalf("claiming a claimed link: got status %d, want 401", status)
+	}
+}
+
+func TestClaimRejectsDeadLinks(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+
+	revoked := s.link(token, portal.ID, fiber.Map{"category": "Billing"})
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + revoked.ID + "/link/revoke", token: token}, nil)
+
+	var dead struct {
+		Code string `json:"code"`
+	}
+	s.expect(http.StatusGone, request{method: "GET", path: "/api/conversation/code/" + revoked.UniqueCode}, &dead)
+	if dead.Code != "link_revoked" {
+		t.Fatalf("dead link code = %q, want link_revoked", dead.Code)
+	}
+	if status := s.claim(revoked.ID, "Grace"); status != http.StatusGone {
+		t.Fatalf("claiming a revoked link: got status %d, want 410", status)
+	}
+}
+
+func TestPublicConversationRoutesHideIDs(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	link := s.link(token, portal.ID, fiber.Map{"category": "Billing"})
+
+	type publicResponse struct {
+		Conversation map[string]interface{} `json:"conversation"`
+		Portal       map[string]interface{} `json:"portal"`
+	}
+	check := func(route string, resp publicResponse) {
+		t.Helper()
+		if resp.Conversation["id"] == nil {
+			t.Fatalf("%s returned no conversation: %v", route, resp.Conversation)
+		}
+		for _, field := range []string{"customerId", "ownerId", "assigneeId", "owner", "portal", "linkMaxUses", "linkUses"} {
+			if _, ok := resp.Conversation[field]; ok {
+				t.Errorf("%s exposes the conversation's %s: %v", route, field, resp.Conversation)
+			}
+		}
+		for _, field := range []string{"ownerId", "owner", "routingMode", "allowAdHocCategories"} {
+			if _, ok := resp.Portal[field]; ok {
+				t.Errorf("%s exposes the portal's %s: %v", route, field, resp.Portal)
+			}
+		}
+	}
+
+	var claimed struct {
+		publicResponse
+		CustomerToken string `json:"customerToken"`
+	}
+	s.expect(http.StatusOK, request{
+		method: "PUT",
+		path:   "/api/conversation/" + link.ID + "/update-customer",
+		body:   fiber.Map{"customerName": "Grace", "customerId": "customer-Grace"},
+	}, &claimed)
+	check("update-customer", claimed.publicResponse)
+
+	var created publicResponse
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/conversation/create", body: fiber.Map{"portalId": portal.ID, "customerName": "Linus", "category": "Billing"}}, &created)
+	check("create", created)
+
+	var opened publicResponse
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversation/category/" + portal.CustomName + "/billing"}, &opened)
+	check("category", opened)
+
+	for _, path := range []string{
+		"/api/conversation/code/" + link.UniqueCode,
+		"/api/conversation/find/" + portal.CustomName + "/billing/" + link.UniqueCode,
+		"/api/conversation/public/" + link.ID,
+	} {
+		var resp publicResponse
+		s.expect(http.StatusOK, request{method: "GET", path: path, customerToken: claimed.CustomerToken}, &resp)
+		if resp.Conversation["id"] != link.ID {
+			t.Fatalf("%s returned %v, want the conversation", path, resp.Conversation)
+		}
+		check(path, resp)
+	}
+}
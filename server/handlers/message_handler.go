@@ -1,26 +1,33 @@
 package handlers
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 
-	"server/config"
-	"server/database"
 	"server/database/models"
+	"server/realtime"
+	"server/utils"
 )
 
+// maxClientMessageIDLength matches the messages.client_message_id column
+const maxClientMessageIDLength = 64
+
 // SendMessageRequest represents the expected body for sending a message
 type SendMessageRequest struct {
 	Content        string `json:"content" validate:"required"`
 	ConversationID string `json:"conversationId" validate:"required"`
-	CustomerID     string `json:"customerId"`
-	CustomerName   string `json:"customerName"`
+	// CustomerID and CustomerName are no longer used: customers authenticate
+	// with their customer token and write as the conversation's customer
+	CustomerID   string `json:"customerId"`
+	CustomerName string `json:"customerName"`
+	// ClientMessageID makes retries safe: resending it returns the original message
+	ClientMessageID string `json:"clientMessageId"`
 }
 
 // SendMessage sends a message in a conversation
-func SendMessage(c *fiber.Ctx) error {
+func (h *Handler) SendMessage(c *fiber.Ctx) error {
 	// Parse request body
 	var req SendMessageRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -35,57 +42,86 @@ func SendMessage(c *fiber.Ctx) error {
 			"error": "Content and conversationId are required",
 		})
 	}
+	if len(req.ClientMessageID) > maxClientMessageIDLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "clientMessageId must be at most 64 characters",
+		})
+	}
 
 	// Check if this is from the owner (authenticated user)
 	isOwner := false
-	senderID := req.CustomerID
+	senderID := ""
 
 	// Get JWT token if present
 	authHeader := c.Get("Authorization")
 	if authHeader != "" {
 		// Extract userID from token
-		userID := extractUserID(authHeader)
+		userID := h.extractUserID(authHeader)
 		if userID != "" {
-			// Verify the user owns this conversation
-			var conversation models.Conversation
-			result := database.DB.Where("id = ?", req.ConversationID).First(&conversation)
-			if result.Error == nil && conversation.OwnerID == userID {
+			// Verify the user may answer in this conversation
+			_, _, err := h.authorizeConversation(req.ConversationID, userID, models.RoleAgent)
+			if errors.Is(err, errInsufficientRole) {
+				return accessError(c, err)
+			}
+			if err == nil {
 				isOwner = true
 				senderID = userID
 			}
 		}
 	}
 
+	// Get sender information
+	var sender models.User
+	if isOwner {
+		sender = h.teamSender(senderID)
+	} else {
+		// Customers need the token issued for the conversation and write as its customer
+		conversation, err := h.stores.Conversations.GetByID(req.ConversationID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Conversation not found",
+			})
+		}
+		if err := authorizeCustomer(c, conversation); err != nil {
+			return customerTokenError(c)
+		}
+
+		senderID = conversation.CustomerID
+		sender = models.User{
+			ID:   senderID,
+			Name: conversation.CustomerName,
+		}
+	}
+
 	// Create the message
 	message := models.Message{
 		Content:        req.Content,
 		SenderID:       senderID,
 		ConversationID: req.ConversationID,
 		IsOwner:        isOwner,
-		CreatedAt:      time.Now(),
+	}
+	if req.ClientMessageID != "" {
+		message.ClientMessageID = &req.ClientMessageID
 	}
 
-	result := database.DB.Create(&message)
-	if result.Error != nil {
+	created, err := h.createMessage(&message, sender)
+	if errors.Is(err, errConversationClosed) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create message",
 		})
 	}
 
-	// Update conversation timestamp
-	database.DB.Model(&models.Conversation{}).Where("id = ?", req.ConversationID).Update("updated_at", time.Now())
-
-	// Get sender information
-	var sender models.User
-	if isOwner {
-		database.DB.Select("id, name").Where("id = ?", senderID).First(&sender)
-		message.Sender = sender
-	} else {
-		// For customers, create a temporary sender object
-		message.Sender = models.User{
-			ID:   senderID,
-			Name: req.CustomerName,
-		}
+	// A replayed clientMessageId gets the message that was stored the first time
+	if !created {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":   message,
+			"duplicate": true,
+		})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -93,8 +129,70 @@ func SendMessage(c *fiber.Ctx) error {
 	})
 }
 
+// createMessage stores a message, bumps the conversation timestamp and announces the
+// message to real-time subscribers. Every transport that accepts messages goes
+// through here so that all of them emit the same new_message event.
+//
+// When the message carries a ClientMessageID that was already used in the
+// conversation, nothing is stored or broadcast: message is replaced by the
+// original and created is false. Closed conversations accept no messages.
+func (h *Handler) createMessage(message *models.Message, sender models.User) (created bool, err error) {
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+
+	conversation, err := h.stores.Conversations.GetByID(message.ConversationID)
+	if err == nil && conversation.Status == models.ConversationClosed {
+		return false, errConversationClosed
+	}
+
+	// The store also bumps the conversation timestamp
+	created, err = h.stores.Messages.Create(message)
+	if err != nil {
+		return false, err
+	}
+	message.Sender = sender
+	if !created {
+		return false, nil
+	}
+
+	// Broadcast to the room
+	h.events.Publish(realtime.NewMessageEvent(chatMessage(*message, realtime.Sender{ID: sender.ID, Name: sender.Name})))
+
+	// A customer reply brings the conversation back to the team
+	if !message.IsOwner {
+		h.reopenOnCustomerReply(message.ConversationID)
+		h.autoAssign(message.ConversationID)
+	}
+
+	return true, nil
+}
+
+// teamSender returns the public details of a support user who sent a message
+func (h *Handler) teamSender(userID string) models.User {
+	user, _ := h.stores.Users.GetByID(userID)
+	return models.User{ID: userID, Name: user.Name}
+}
+
+// chatMessage converts a stored message into its real-time representation
+func chatMessage(message models.Message, sender realtime.Sender) realtime.ChatMessage {
+	msg := realtime.ChatMessage{
+		ID:             message.ID,
+		Seq:            message.Seq,
+		ConversationID: message.ConversationID,
+		Content:        message.Content,
+		Sender:         sender,
+		IsOwner:        message.IsOwner,
+		CreatedAt:      message.CreatedAt,
+	}
+	if message.ClientMessageID != nil {
+		msg.ClientMessageID = *message.ClientMessageID
+	}
+	return msg
+}
+
 // Helper function to extract user ID from JWT token
-func extractUserID(authHeader string) string {
+func (h *Handler) extractUserID(authHeader string) string {
 	// Extract token from Authorization header
 	if len(authHeader) < 8 || authHeader[:7] != "Bearer " {
 		return ""
@@ -102,27 +200,25 @@ func extractUserID(authHeader string) string {
 	tokenString := authHeader[7:]
 
 	// Parse and validate the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the algorithm
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token signing method")
-		}
-		
-		// Return the secret key
-		cfg := config.LoadConfig()
-		return []byte(cfg.JWTSecret), nil
-	})
-
-	if err != nil || !token.Valid {
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
 		return ""
 	}
 
 	// Extract user ID from claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if userID, ok := claims["id"].(string); ok {
-			return userID
-		}
+	userID, ok := claims["id"].(string)
+	if !ok {
+		return ""
+	}
+
+	// Tokens from before the user logged out of all sessions no longer count
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		return ""
+	}
+	if version, _ := claims["ver"].(float64); int(version) != user.TokenVersion {
+		return ""
 	}
 
-	return ""
+	return userID
 }
\ No newline at end of file
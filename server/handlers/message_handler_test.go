@@ -0,0 +1,193 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+)
+
+// watch subscribes to a conversation's room the way the fallback transports do
+func (s *testServer) watch(conversationID string) *realtime.Client {
+	s.t.Helper()
+
+	client := s.hub.Subscribe(conversationID, realtime.Participant{ID: "watcher", Name: "Watcher"}, nil)
+	if client == nil {
+		s.t.Fatal("hub refused the subscriber")
+	}
+	s.t.Cleanup(func() { s.hub.Unsubscribe(client) })
+	return client
+}
+
+// nextMessage waits for the next new_message event queued for a subscriber,
+// skipping presence and other events
+func nextMessage(t *testing.T, client *realtime.Client) realtime.MessageData {
+	t.Helper()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case frame := <-client.Frames():
+			var event realtime.Event
+			if err := json.Unmarshal(frame.Data, &event); err != nil {
+				t.Fatalf("decode frame %s: %v", frame.Data, err)
+			}
+			if event.Type == realtime.EventNewMessage {
+				return event.Data.(realtime.MessageData)
+			}
+		case <-deadline:
+			t.Fatal("no message arrived")
+		}
+	}
+}
+
+// expectNoMessage fails if a new_message event reaches a subscriber
+func expectNoMessage(t *testing.T, client *realtime.Client) {
+	t.Helper()
+
+	deadline := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case frame := <-client.Frames():
+			var event realtime.Event
+			if err := json.Unmarshal(frame.Data, &event); err == nil && event.Type == realtime.EventNewMessage {
+				t.Fatalf("unexpected message %s", frame.Data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// dial connects to the WebSocket endpoint with the given query parameters
+func (s *testServer) dial(query url.Values) *fastws.Conn {
+	s.t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		s.t.Fatalf("listen: %v", err)
+	}
+	go s.app.Listener(ln)
+	s.t.Cleanup(func() { s.app.Shutdown() })
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws?"+query.Encode(), nil)
+	if err != nil {
+		s.t.Fatalf("dial: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readMessage reads WebSocket events until a new_message arrives
+func readMessage(t *testing.T, conn *fastws.Conn) realtime.MessageData {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var event realtime.Event
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if event.Type == realtime.EventNewMessage {
+			return event.Data.(realtime.MessageData)
+		}
+	}
+}
+
+// storedMessages returns the messages kept for a conversation
+func (s *testServer) storedMessages(conversationID string) []models.Message {
+	s.t.Helper()
+
+	messages, err := s.stores.Messages.ListByConversation(conversationID)
+	if err != nil {
+		s.t.Fatalf("list messages: %v", err)
+	}
+	return messages
+}
+
+func TestResentClientMessageIDReturnsTheOriginal(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+	watcher := s.watch(conversation.ID)
+
+	send := request{
+		method:        "POST",
+		path:          "/api/messages",
+		customerToken: customerToken,
+		body:          fiber.Map{"conversationId": conversation.ID, "content": "Hello", "clientMessageId": "client-1"},
+	}
+	var first, retried struct {
+		Message   models.Message `json:"message"`
+		Duplicate bool           `json:"duplicate"`
+	}
+	s.expect(http.StatusCreated, send, &first)
+	if broadcast := nextMessage(t, watcher); broadcast.ID != first.Message.ID {
+		t.Fatalf("broadcast message %s, want %s", broadcast.ID, first.Message.ID)
+	}
+
+	s.expect(http.StatusOK, send, &retried)
+	if !retried.Duplicate || retried.Message.ID != first.Message.ID || retried.Message.Seq != first.Message.Seq {
+		t.Fatalf("retry returned %+v, want duplicate of message %s with seq %d", retried, first.Message.ID, first.Message.Seq)
+	}
+	if stored := s.storedMessages(conversation.ID); len(stored) != 1 {
+		t.Fatalf("%d messages stored, want 1", len(stored))
+	}
+	expectNoMessage(t, watcher)
+
+	// The retry used up no sequence number
+	s.customerMessage(conversation.ID, customerToken, "Anyone there?")
+	if next := nextMessage(t, watcher); next.Seq != first.Message.Seq+1 {
+		t.Fatalf("next message has seq %d, want %d", next.Seq, first.Message.Seq+1)
+	}
+}
+
+func TestResentClientMessageIDOverWebSocket(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+	watcher := s.watch(conversation.ID)
+
+	conn := s.dial(url.Values{"customerToken": {customerToken}})
+	if err := conn.WriteJSON(realtime.Command{Type: realtime.CommandJoin, ConversationID: conversation.ID}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	var ack realtime.Event
+	if err := conn.ReadJSON(&ack); err != nil || ack.Type != realtime.EventJoinAck {
+		t.Fatalf("got %+v (%v), want a join ack", ack, err)
+	}
+
+	send := realtime.Command{Type: realtime.CommandMessage, ConversationID: conversation.ID, Content: "Hello", ClientMessageID: "client-1"}
+	if err := conn.WriteJSON(send); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	first := readMessage(t, conn)
+	if broadcast := nextMessage(t, watcher); broadcast.ID != first.ID {
+		t.Fatalf("broadcast message %s, want %s", broadcast.ID, first.ID)
+	}
+
+	// The resending client hears about the original again, the room does not
+	if err := conn.WriteJSON(send); err != nil {
+		t.Fatalf("resend: %v", err)
+	}
+	retried := readMessage(t, conn)
+	if retried.ID != first.ID || retried.Seq != first.Seq || retried.ClientMessageID != "client-1" {
+		t.Fatalf("resend answered with %+v, want message %s with seq %d", retried, first.ID, first.Seq)
+	}
+	expectNoMessage(t, watcher)
+	if stored := s.storedMessages(conversation.ID); len(stored) != 1 {
+		t.Fatalf("%d messages stored, want 1", len(stored))
+	}
+}
@@ -3,9 +3,11 @@ package handlers
 import (
 	"github.com/gofiber/fiber/v2"
 	"fmt"
-	"server/database"
+	"time"
+	"server/config"
 	"server/database/models"
-	"server/utils"
+	"server/slug"
+	"server/store"
 )
 
 // CreatePortalRequest represents the expected body for portal creation
@@ -16,7 +18,9 @@ type CreatePortalRequest struct {
 
 // GenerateLinkRequest represents the expected body for generating a conversation link
 type GenerateLinkRequest struct {
-	Category string `json:"category" validate:"required"`
+	Category  string     `json:"category" validate:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"` // the link stops working at this time
+	MaxUses   int        `json:"maxUses"`   // how many customers may claim the link; 0 means unlimited
 }
 
 // LinkResponse represents the response for a generated conversation link
@@ -25,39 +29,43 @@ type LinkResponse struct {
 	ConversationLink string             `json:"conversationLink"`
 }
 
-// GetPortals returns all portals owned by the authenticated user
-func GetPortals(c *fiber.Ctx) error {
+// GetPortals returns all portals the authenticated user is on the team of
+func (h *Handler) GetPortals(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
-	// Find all portals owned by the user
-	var portals []models.Portal
-	result := database.DB.Where("owner_id = ?", userID).Find(&portals)
-	if result.Error != nil {
+	// Find all portals owned by the user or where they are a member
+	portals, err := h.stores.Portals.ListForUser(userID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch portals",
 		})
 	}
 
+	for i := range portals {
+		portals[i].Role = h.portalRole(portals[i], userID)
+	}
+
+	user, _ := h.stores.Users.GetByID(userID)
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"portals": portals,
+		"portals":          portals,
+		"selectedPortalId": user.SelectedPortalID,
+		"portalLimit":      portalLimit(user),
 	})
 }
 
 // GetPortalByID returns a specific portal by ID
-func GetPortalByID(c *fiber.Ctx) error {
+func (h *Handler) GetPortalByID(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
-	portalId := c.Params("id")
+	portalId := h.portalIDParam(c)
 	fmt.Printf("Looking up portal with ID: %s\n", portalId)
 	
 	// Find the portal
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalId, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found",
-		})
+	portal, err := h.authorizePortal(portalId, userID, models.RoleViewer)
+	if err != nil {
+		return accessError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -65,46 +73,48 @@ func GetPortalByID(c *fiber.Ctx) error {
 	})
 }
 
-// GetPortalByCustomName returns a specific portal by custom name
-func GetPortalByCustomName(c *fiber.Ctx) error {
+// GetPortalByCustomName returns a specific portal by custom name. Names the
+// portal used before a rename still resolve; canonicalSlug tells the client
+// where to redirect.
+func (h *Handler) GetPortalByCustomName(c *fiber.Ctx) error {
 	// Get custom name from URL
 	customName := c.Params("customName")
 	
 	// Find the portal
-	var portal models.Portal
-	result := database.DB.Where("custom_name = ?", customName).Select("id, name, custom_name").First(&portal)
-	if result.Error != nil {
+	portal, redirect, err := h.findPortalBySlug(customName)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Portal not found",
 		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"portal": portal,
+		"portal":        models.Portal{ID: portal.ID, Name: portal.Name, CustomName: portal.CustomName},
+		"canonicalSlug": portal.CustomName,
+		"redirect":      redirect,
 	})
 }
 
 // GetPublicPortalByID returns public portal info
-func GetPublicPortalByID(c *fiber.Ctx) error {
+func (h *Handler) GetPublicPortalByID(c *fiber.Ctx) error {
 	// Get portal ID from URL
 	portalID := c.Params("id")
 
 	// Find the portal
-	var portal models.Portal
-	result := database.DB.Where("id = ?", portalID).Select("id, name, custom_name").First(&portal)
-	if result.Error != nil {
+	portal, err := h.stores.Portals.GetByID(portalID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Portal not found",
 		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"portal": portal,
+		"portal": models.Portal{ID: portal.ID, Name: portal.Name, CustomName: portal.CustomName},
 	})
 }
 
 // CreatePortal creates a new portal
-func CreatePortal(c *fiber.Ctx) error {
+func (h *Handler) CreatePortal(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
@@ -123,19 +133,26 @@ func CreatePortal(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if user already has a portal
-	var existingPortals []models.Portal
-	database.DB.Where("owner_id = ?", userID).Find(&existingPortals)
-	if len(existingPortals) > 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "You already have a portal created",
+	// Check the user's plan allows another portal
+	user, _ := h.stores.Users.GetByID(userID)
+	limit := portalLimit(user)
+
+	ownedPortals, err := h.stores.Portals.CountOwnedBy(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create portal",
+		})
+	}
+	if limit > 0 && ownedPortals >= int64(limit) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": fmt.Sprintf("You can create at most %d portals on your plan", limit),
+			"code":  "portal_limit_reached",
+			"limit": limit,
 		})
 	}
 
 	// Check if portal name is already taken
-	var existingPortal models.Portal
-	result := database.DB.Where("name = ?", req.Name).First(&existingPortal)
-	if result.RowsAffected > 0 {
+	if taken, _ := h.stores.Portals.NameTaken(req.Name); taken {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "This portal name is already taken",
 		})
@@ -144,17 +161,13 @@ func CreatePortal(c *fiber.Ctx) error {
 	// Generate custom name if not provided
 	customName := req.CustomName
 	if customName == "" {
-		customName = utils.Slugify(req.Name)
-	} else {
-		customName = utils.Slugify(customName)
+		customName = req.Name
 	}
 	
-	// Check if custom name is already taken
-	result = database.DB.Where("custom_name = ?", customName).First(&existingPortal)
-	if result.RowsAffected > 0 {
-		// Append a random string to make it unique
-		customName = fmt.Sprintf("%s-%s", customName, utils.GenerateRandomCode())
-	}
+	// If the custom name is already taken, append a numbered suffix to make it unique
+	customName = slug.Unique(slug.Make(customName), func(candidate string) bool {
+		return h.customNameTaken(candidate, "")
+	})
 
 	// Create the portal
 	portal := models.Portal{
@@ -163,12 +176,14 @@ func CreatePortal(c *fiber.Ctx) error {
 		OwnerID:    userID,
 	}
 
-	result = database.DB.Create(&portal)
-	if result.Error != nil {
+	// The creator joins the team as its owner, and a first portal becomes the
+	// dashboard's current portal
+	if err := h.stores.Portals.Create(&portal); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create portal",
 		})
 	}
+	portal.Role = models.RoleOwner
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"portal": portal,
@@ -176,31 +191,39 @@ func CreatePortal(c *fiber.Ctx) error {
 }
 
 // GetPortalConversations returns all conversations for a portal
-func GetPortalConversations(c *fiber.Ctx) error {
+func (h *Handler) GetPortalConversations(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
+	}
+
+	// Optionally filter by status (?status=open,pending)
+	statuses, err := statusFilter(c, nil)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
 	// Get all conversations for the portal
-	var conversations []models.Conversation
-	database.DB.Where("portal_id = ?", portalID).Order("updated_at DESC").Find(&conversations)
+	filter := assigneeFilter(c, store.ConversationFilter{Statuses: statuses}, userID)
+	conversations, err := h.stores.Conversations.ListByPortal(portalID, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch conversations",
+		})
+	}
 
 	// Count messages for each conversation
 	for i := range conversations {
-		var count int64
-		database.DB.Model(&models.Message{}).Where("conversation_id = ?", conversations[i].ID).Count(&count)
-		conversations[i].MessageCount = count
+		conversations[i].MessageCount, _ = h.stores.Messages.Count(conversations[i].ID)
+		conversations[i].UnreadCount = h.unreadCount(conversations[i].ID, userID, true)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -209,34 +232,41 @@ func GetPortalConversations(c *fiber.Ctx) error {
 }
 
 // GetPortalActiveConversations returns active conversations for a portal
-func GetPortalActiveConversations(c *fiber.Ctx) error {
+func (h *Handler) GetPortalActiveConversations(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
+	// Verify portal membership
+	if _, err := h.authorizePortal(portalID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
+	}
+
+	// Active conversations are open or pending unless another status is asked for
+	statuses, err := statusFilter(c, models.ActiveStatuses)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	// Get active conversations (those with customer and messages)
-	var conversations []models.Conversation
-	database.DB.Where("portal_id = ? AND customer_name != ?", portalID, "Unassigned").Order("updated_at DESC").Find(&conversations)
+	filter := assigneeFilter(c, store.ConversationFilter{Statuses: statuses}, userID)
+	conversations, err := h.stores.Conversations.ListByPortal(portalID, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch conversations",
+		})
+	}
 
-	// Filter conversations with messages and count them
+	// Links nobody has used yet have no messages, so leave them out and count the rest
 	var activeConversations []models.Conversation
 	for _, conv := range conversations {
-		var count int64
-		database.DB.Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Count(&count)
-		
+		count, _ := h.stores.Messages.Count(conv.ID)
 		if count > 0 {
 			conv.MessageCount = count
+			conv.UnreadCount = h.unreadCount(conv.ID, userID, true)
 			activeConversations = append(activeConversations, conv)
 		}
 	}
@@ -247,12 +277,12 @@ func GetPortalActiveConversations(c *fiber.Ctx) error {
 }
 
 // GenerateConversationLink generates a unique conversation link
-func GenerateConversationLink(c *fiber.Ctx) error {
+func (h *Handler) GenerateConversationLink(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
 	// Parse request body
 	var req GenerateLinkRequest
@@ -269,43 +299,44 @@ func GenerateConversationLink(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Expiry must be in the future",
+		})
+	}
+	if req.MaxUses < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Max uses must not be negative",
 		})
 	}
 
-	// Generate a unique code
-	uniqueCode := utils.GenerateRandomCode()
+	// Verify portal membership
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAgent)
+	if err != nil {
+		return accessError(c, err)
+	}
 
-	// Ensure the code is unique
-	for {
-		var existingConversation models.Conversation
-		result := database.DB.Where("unique_code = ?", uniqueCode).First(&existingConversation)
-		if result.Error != nil {
-			// No conversation found with this code, it's unique
-			break
-		}
-		// If code already exists, generate a new one
-		uniqueCode = utils.GenerateRandomCode()
+	// Only the portal's own categories can be used, unless it allows ad-hoc ones
+	categorySlug := slug.Make(req.Category)
+	category, err := h.resolveCategory(portal, categorySlug, req.Category)
+	if err != nil {
+		return h.categoryError(c, portal, categorySlug, err)
 	}
 
 	// Create a placeholder conversation
 	conversation := models.Conversation{
-		UniqueCode:   uniqueCode,
-		Category:     req.Category,
-		CategorySlug: utils.Slugify(req.Category),
-		CustomerID:   "placeholder", // Will be updated when a customer connects
-		CustomerName: "Unassigned",  // Will be updated when a customer connects
-		OwnerID:      userID,
-		PortalID:     portalID,
+		CategoryID:    &category.ID,
+		Category:      category.Name,
+		CategorySlug:  category.Slug,
+		CustomerID:    models.UnclaimedCustomerID, // Will be updated when a customer connects
+		CustomerName:  "Unassigned",               // Will be updated when a customer connects
+		LinkExpiresAt: req.ExpiresAt,
+		LinkMaxUses:   req.MaxUses,
+		OwnerID:       portal.OwnerID,
+		PortalID:      portalID,
 	}
 
-	result = database.DB.Create(&conversation)
-	if result.Error != nil {
+	if err := h.createWithCode(&conversation); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create conversation",
 		})
@@ -315,10 +346,87 @@ func GenerateConversationLink(c *fiber.Ctx) error {
 	conversationLink := fmt.Sprintf("/portal/%s/%s/%s", 
 		portal.CustomName, 
 		conversation.CategorySlug, 
-		uniqueCode)
+		conversation.UniqueCode)
 
 	return c.Status(fiber.StatusCreated).JSON(LinkResponse{
 		Conversation:    conversation,
 		ConversationLink: conversationLink,
 	})
+}
+
+// SelectPortal makes a portal the authenticated user's current portal, which
+// dashboard endpoints use when called with "current" as the portal ID
+func (h *Handler) SelectPortal(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Verify portal membership
+	portal, err := h.authorizePortal(c.Params("id"), userID, models.RoleViewer)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	if err := h.stores.Users.SelectPortal(userID, portal.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to select portal",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"portal": portal,
+	})
+}
+
+// portalIDParam returns the portal a dashboard request is scoped to. The
+// ":id" route parameter may be "current", which resolves to the X-Portal-ID
+// header or else the portal the user last selected.
+func (h *Handler) portalIDParam(c *fiber.Ctx) string {
+	portalID := c.Params("id")
+	if portalID != "current" {
+		return portalID
+	}
+
+	if header := c.Get("X-Portal-ID"); header != "" {
+		return header
+	}
+
+	userID, _ := c.Locals("userID").(string)
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil || user.SelectedPortalID == nil {
+		return ""
+	}
+	return *user.SelectedPortalID
+}
+
+// portalLimit returns how many portals the user may own, 0 meaning unlimited
+func portalLimit(user models.User) int {
+	if user.PortalLimit != nil {
+		return *user.PortalLimit
+	}
+	return config.LoadConfig().MaxPortalsPerUser
+}
+
+// findPortalBySlug finds a portal by its custom name or by a name it used
+// before being renamed. redirect reports whether customName is such an old
+// name, or is spelled differently from the portal's custom name.
+func (h *Handler) findPortalBySlug(customName string) (portal models.Portal, redirect bool, err error) {
+	requested := customName
+	customName = slug.FromPath(customName)
+
+	for _, candidate := range []string{customName, slug.Make(customName)} {
+		portal, err = h.stores.Portals.GetByCustomName(candidate)
+		if err == nil {
+			return portal, portal.CustomName != requested && portal.CustomName != customName, nil
+		}
+	}
+
+	portal, err = h.stores.Portals.GetByOldCustomName(customName)
+	return portal, err == nil, err
+}
+
+// customNameTaken reports whether a custom name is used by a portal other
+// than portalID, either currently or as a name kept for redirects
+func (h *Handler) customNameTaken(customName, portalID string) bool {
+	taken, _ := h.stores.Portals.CustomNameTaken(customName, portalID)
+	return taken
 }
\ No newline at end of file
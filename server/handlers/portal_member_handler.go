@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/config"
+	"server/database/models"
+	"server/mail"
+	"server/store"
+	"server/utils"
+)
+
+// Errors returned by the portal and conversation access checks
+var (
+	errPortalAccess       = errors.New("Portal not found or unauthorized")
+	errConversationAccess = errors.New("Conversation not found or unauthorized")
+	errInsufficientRole   = errors.New("Your role on this portal does not allow this")
+)
+
+// InvitePortalMemberRequest represents the expected body for inviting a team member
+type InvitePortalMemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required"`
+}
+
+// UpdatePortalMemberRequest represents the expected body for changing a member's role
+type UpdatePortalMemberRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// AcceptInvitationRequest represents the expected body for accepting an invitation
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// GetPortalMembers returns the team of a portal
+func (h *Handler) GetPortalMembers(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID from URL
+	portalID := h.portalIDParam(c)
+
+	// Any member may see the team
+	portal, err := h.authorizePortal(portalID, userID, models.RoleViewer)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	members, err := h.stores.Members.ListByPortal(portal.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch members",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"members": members,
+	})
+}
+
+// UpdatePortalMember changes the role of a team member
+func (h *Handler) UpdatePortalMember(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID and member ID from URL
+	portalID := h.portalIDParam(c)
+	memberID := c.Params("memberId")
+
+	// Parse request body
+	var req UpdatePortalMemberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Validate input
+	if !models.IsMemberRole(req.Role) || req.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be one of admin, agent or viewer",
+		})
+	}
+
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	member, err := h.manageableMember(portal, memberID)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	// Nobody can hand out more than they have
+	if !models.RoleAtLeast(portal.Role, req.Role) {
+		return accessError(c, errInsufficientRole)
+	}
+
+	member.Role = req.Role
+	if err := h.stores.Members.UpdateRole(member.ID, member.Role); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update member",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"member": member,
+	})
+}
+
+// RemovePortalMember removes someone from a portal's team. Members may also remove themselves.
+func (h *Handler) RemovePortalMember(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID and member ID from URL
+	portalID := h.portalIDParam(c)
+	memberID := c.Params("memberId")
+
+	portal, err := h.authorizePortal(portalID, userID, models.RoleViewer)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	member, err := h.stores.Members.GetByID(portal.ID, memberID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+
+	// Leaving is always allowed, except for the owner who would orphan the portal
+	if member.UserID != userID || member.Role == models.RoleOwner {
+		if !models.RoleAtLeast(portal.Role, models.RoleAdmin) {
+			return accessError(c, errInsufficientRole)
+		}
+		if member, err = h.manageableMember(portal, memberID); err != nil {
+			return accessError(c, err)
+		}
+	}
+
+	if err := h.stores.Members.Delete(member.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove member",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Member removed successfully",
+	})
+}
+
+// GetPortalInvitations returns the pending invitations of a portal
+func (h *Handler) GetPortalInvitations(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID from URL
+	portalID := h.portalIDParam(c)
+
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	invitations, err := h.stores.Invitations.ListPending(portal.ID, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch invitations",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"invitations": invitations,
+	})
+}
+
+// InvitePortalMember invites someone to join the portal's team by mailing them
+// a link with the accept token. The token only ever goes to the invitee's
+// address, so nobody else can accept in their place.
+func (h *Handler) InvitePortalMember(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID from URL
+	portalID := h.portalIDParam(c)
+
+	// Parse request body
+	var req InvitePortalMemberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Validate input
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if req.Email == "" || !strings.Contains(req.Email, "@") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A valid email is required",
+		})
+	}
+	if !models.IsMemberRole(req.Role) || req.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be one of admin, agent or viewer",
+		})
+	}
+
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
+	}
+	if !models.RoleAtLeast(portal.Role, req.Role) {
+		return accessError(c, errInsufficientRole)
+	}
+
+	// Refuse people who are already on the team
+	member, _ := h.stores.Members.HasEmail(portal.ID, req.Email)
+	if member || h.portalOwnerEmail(portal) == req.Email {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "This user is already a member of the portal",
+		})
+	}
+
+	token, err := utils.GenerateSecureToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate invitation token",
+		})
+	}
+
+	invitation := models.PortalInvitation{
+		PortalID:    portal.ID,
+		Email:       req.Email,
+		Role:        req.Role,
+		TokenHash:   utils.HashToken(token),
+		InvitedByID: userID,
+		ExpiresAt:   time.Now().Add(config.LoadConfig().InvitationExpiration),
+	}
+
+	if err := h.stores.Invitations.Create(&invitation); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create invitation",
+		})
+	}
+
+	// An invitation nobody received cannot be accepted, so drop it
+	if err := h.sendInvitation(portal, invitation, token); err != nil {
+		log.Printf("Failed to send invitation email: %v", err)
+		if err := h.stores.Invitations.Delete(portal.ID, invitation.ID); err != nil {
+			log.Printf("Failed to delete unsent invitation %s: %v", invitation.ID, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send invitation email",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"invitation": invitation,
+	})
+}
+
+// sendInvitation mails the invitee a link to accept the invitation
+func (h *Handler) sendInvitation(portal models.Portal, invitation models.PortalInvitation, token string) error {
+	inviter := "A team member"
+	if user, err := h.stores.Users.GetByID(invitation.InvitedByID); err == nil {
+		inviter = user.Name
+	}
+
+	cfg := config.LoadConfig()
+	link := cfg.AppURL + "/accept-invitation?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mail.Message{
+		To:      invitation.Email,
+		Subject: "Join the " + portal.Name + " team",
+		Body: "Hi,\n\n" + inviter + " invited you to join the " + portal.Name + " team as " + invitation.Role + ". " +
+			"To accept, sign in or create an account with this email address and follow this link:\n\n" + link + "\n\n" +
+			"The invitation expires in " + describeDuration(cfg.InvitationExpiration) + ". If you did not expect this email, you can ignore it.\n",
+	})
+}
+
+// RevokePortalInvitation deletes a pending invitation
+func (h *Handler) RevokePortalInvitation(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get portal ID and invitation ID from URL
+	portalID := h.portalIDParam(c)
+	invitationID := c.Params("invitationId")
+
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
+	}
+
+	err = h.stores.Invitations.Delete(portal.ID, invitationID)
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke invitation",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Invitation revoked successfully",
+	})
+}
+
+// AcceptInvitation adds the authenticated user to a portal's team. The
+// invitation must have been sent to the user's email address.
+func (h *Handler) AcceptInvitation(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Parse request body
+	var req AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invitation token is required",
+		})
+	}
+
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	member, err := h.acceptInvitation(user, req.Token)
+	switch {
+	case errors.Is(err, errInvitationInvalid):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, errInvitationEmail):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept invitation",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"member": member,
+	})
+}
+
+// Errors returned while accepting an invitation
+var (
+	errInvitationInvalid = errors.New("Invitation not found, already used or expired")
+	errInvitationEmail   = errors.New("This invitation was sent to a different email address")
+)
+
+// acceptInvitation adds the user to the team of the invitation with the given
+// token. Accepting never lowers a role the user already has.
+func (h *Handler) acceptInvitation(user models.User, token string) (models.PortalMember, error) {
+	now := time.Now()
+	invitation, err := h.stores.Invitations.GetPending(utils.HashToken(token), now)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.PortalMember{}, errInvitationInvalid
+	}
+	if err != nil {
+		return models.PortalMember{}, err
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return models.PortalMember{}, errInvitationEmail
+	}
+
+	// Someone else may have used the token in the meantime
+	member, err := h.stores.Invitations.Accept(invitation, user.ID, now)
+	if errors.Is(err, store.ErrNotFound) {
+		return member, errInvitationInvalid
+	}
+	return member, err
+}
+
+// portalRole returns the user's role on a portal, or "" if they are not on its team.
+// The portal's owner is always an owner, even without a member row.
+func (h *Handler) portalRole(portal models.Portal, userID string) string {
+	if portal.OwnerID == userID {
+		return models.RoleOwner
+	}
+
+	role, err := h.stores.Portals.MemberRole(portal.ID, userID)
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// authorizePortal loads a portal and checks that the user has at least minRole
+// on it. The user's role is returned in portal.Role.
+func (h *Handler) authorizePortal(portalID, userID, minRole string) (models.Portal, error) {
+	portal, err := h.stores.Portals.GetByID(portalID)
+	if err != nil {
+		return portal, errPortalAccess
+	}
+
+	portal.Role = h.portalRole(portal, userID)
+	if portal.Role == "" {
+		return portal, errPortalAccess
+	}
+	if !models.RoleAtLeast(portal.Role, minRole) {
+		return portal, errInsufficientRole
+	}
+	return portal, nil
+}
+
+// authorizeConversation loads a conversation and checks that the user has at
+// least minRole on its portal. It returns the user's role.
+func (h *Handler) authorizeConversation(conversationID, userID, minRole string) (models.Conversation, string, error) {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		return conversation, "", errConversationAccess
+	}
+
+	// Conversations carry their portal's owner, which saves loading the portal
+	role := h.portalRole(models.Portal{ID: conversation.PortalID, OwnerID: conversation.OwnerID}, userID)
+	if role == "" {
+		return conversation, "", errConversationAccess
+	}
+	if !models.RoleAtLeast(role, minRole) {
+		return conversation, role, errInsufficientRole
+	}
+	return conversation, role, nil
+}
+
+// accessError responds to a failed access check
+func accessError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusNotFound
+	if errors.Is(err, errInsufficientRole) {
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// manageableMember loads a member of the portal that the requesting user
+// (whose role is portal.Role) is allowed to change. Owners cannot be changed.
+func (h *Handler) manageableMember(portal models.Portal, memberID string) (models.PortalMember, error) {
+	member, err := h.stores.Members.GetByID(portal.ID, memberID)
+	if err != nil {
+		return member, errors.New("Member not found")
+	}
+	if member.Role == models.RoleOwner || member.UserID == portal.OwnerID {
+		return member, errInsufficientRole
+	}
+	if !models.RoleAtLeast(portal.Role, member.Role) {
+		return member, errInsufficientRole
+	}
+	return member, nil
+}
+
+// portalOwnerEmail returns the lower-cased email of the portal's owner
+func (h *Handler) portalOwnerEmail(portal models.Portal) string {
+	owner, _ := h.stores.Users.GetByID(portal.OwnerID)
+	return strings.ToLower(owner.Email)
+}
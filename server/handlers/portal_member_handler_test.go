@@ -0,0 +1,138 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+)
+
+// invite invites an email to a portal's team and returns the accept token
+// from the invitation email
+func (s *testServer) invite(token, portalID, email, role string) string {
+	s.t.Helper()
+
+	var resp map[string]interface{}
+	s.expect(http.StatusCreated, request{method: "POST", path: "/api/portals/" + portalID + "/invitations", token: token, body: fiber.Map{"email": email, "role": role}}, &resp)
+	if _, ok := resp["token"]; ok {
+		s.t.Fatalf("invitation response %v carries the accept token", resp)
+	}
+
+	sent := s.mailer.Sent()
+	if len(sent) == 0 || !strings.EqualFold(sent[len(sent)-1].To, email) {
+		s.t.Fatalf("sent %+v, want an invitation to %s", sent, email)
+	}
+	return mailToken(s.t, sent[len(sent)-1])
+}
+
+// member puts a user on a portal's team with a role through an invitation
+func (s *testServer) member(ownerToken, portalID string, user models.User, userToken, role string) models.PortalMember {
+	s.t.Helper()
+
+	var resp struct {
+		Member models.PortalMember `json:"member"`
+	}
+	invitation := s.invite(ownerToken, portalID, user.Email, role)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/invitations/accept", token: userToken, body: fiber.Map{"token": invitation}}, &resp)
+	return resp.Member
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	_, carolToken := s.user("Carol", "carol@example.com")
+	portal := s.portal(ownerToken, "Acme")
+
+	invitation := s.invite(ownerToken, portal.ID, "Bob@Example.com", models.RoleAgent)
+
+	// Only the invitee can accept
+	s.expect(http.StatusForbidden, request{method: "POST", path: "/api/invitations/accept", token: carolToken, body: fiber.Map{"token": invitation}}, nil)
+
+	var accepted struct {
+		Member models.PortalMember `json:"member"`
+	}
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/invitations/accept", token: bobToken, body: fiber.Map{"token": invitation}}, &accepted)
+	if accepted.Member.UserID != bob.ID || accepted.Member.Role != models.RoleAgent {
+		t.Fatalf("accepted member = %+v, want Bob as agent", accepted.Member)
+	}
+
+	// Invitations work once
+	s.expect(http.StatusNotFound, request{method: "POST", path: "/api/invitations/accept", token: bobToken, body: fiber.Map{"token": invitation}}, nil)
+
+	var team struct {
+		Members []models.PortalMember `json:"members"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/members", token: bobToken}, &team)
+	if len(team.Members) != 2 || team.Members[1].User.Email != "bob@example.com" {
+		t.Fatalf("team = %+v, want the owner and Bob", team.Members)
+	}
+
+	var pending struct {
+		Invitations []models.PortalInvitation `json:"invitations"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/invitations", token: ownerToken}, &pending)
+	if len(pending.Invitations) != 0 {
+		t.Fatalf("%d invitations still pending after acceptance", len(pending.Invitations))
+	}
+}
+
+func TestInviteRefusesTeamMembers(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+
+	s.expect(http.StatusConflict, request{method: "POST", path: "/api/portals/" + portal.ID + "/invitations", token: ownerToken, body: fiber.Map{"email": "BOB@example.com", "role": models.RoleAgent}}, nil)
+	s.expect(http.StatusConflict, request{method: "POST", path: "/api/portals/" + portal.ID + "/invitations", token: ownerToken, body: fiber.Map{"email": "ada@example.com", "role": models.RoleAgent}}, nil)
+}
+
+func TestRevokePortalInvitation(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	_, bobToken := s.user("Bob", "bob@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	invitation := s.invite(ownerToken, portal.ID, "bob@example.com", models.RoleAgent)
+
+	var pending struct {
+		Invitations []models.PortalInvitation `json:"invitations"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portal.ID + "/invitations", token: ownerToken}, &pending)
+	if len(pending.Invitations) != 1 {
+		t.Fatalf("%d invitations pending, want 1", len(pending.Invitations))
+	}
+
+	path := "/api/portals/" + portal.ID + "/invitations/" + pending.Invitations[0].ID
+	s.expect(http.StatusOK, request{method: "DELETE", path: path, token: ownerToken}, nil)
+	s.expect(http.StatusNotFound, request{method: "DELETE", path: path, token: ownerToken}, nil)
+	s.expect(http.StatusNotFound, request{method: "POST", path: "/api/invitations/accept", token: bobToken, body: fiber.Map{"token": invitation}}, nil)
+}
+
+func TestUpdateAndRemovePortalMember(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	carol, carolToken := s.user("Carol", "carol@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	bobMember := s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+	carolMember := s.member(ownerToken, portal.ID, carol, carolToken, models.RoleAgent)
+
+	// Agents cannot change the team
+	s.expect(http.StatusForbidden, request{method: "PUT", path: "/api/portals/" + portal.ID + "/members/" + carolMember.ID, token: bobToken, body: fiber.Map{"role": models.RoleViewer}}, nil)
+
+	var updated struct {
+		Member models.PortalMember `json:"member"`
+	}
+	s.expect(http.StatusOK, request{method: "PUT", path: "/api/portals/" + portal.ID + "/members/" + bobMember.ID, token: ownerToken, body: fiber.Map{"role": models.RoleAdmin}}, &updated)
+	if updated.Member.Role != models.RoleAdmin {
+		t.Fatalf("updated role = %q, want admin", updated.Member.Role)
+	}
+
+	// Now an admin, Bob may remove Carol, and Carol loses access
+	s.expect(http.StatusOK, request{method: "DELETE", path: "/api/portals/" + portal.ID + "/members/" + carolMember.ID, token: bobToken}, nil)
+	s.expect(http.StatusNotFound, request{method: "GET", path: "/api/portals/" + portal.ID + "/members", token: carolToken}, nil)
+}
@@ -2,23 +2,25 @@ package handlers
 
 import (
 	"github.com/gofiber/fiber/v2"
-	"server/database"
 	"server/database/models"
-	"server/utils"
+	"server/slug"
 )
 
 // UpdatePortalRequest represents the expected body for portal update
 type UpdatePortalRequest struct {
-	Name string `json:"name" validate:"required"`
+	Name                 string `json:"name"`
+	AllowAdHocCategories *bool  `json:"allowAdHocCategories"`
+	RoutingMode          string `json:"routingMode"`
 }
 
-// UpdatePortal updates a portal name if there are no existing conversations
-func UpdatePortal(c *fiber.Ctx) error {
+// UpdatePortal updates a portal's settings. Renaming also changes the custom
+// name; the old one is kept in the slug history so existing links redirect.
+func (h *Handler) UpdatePortal(c *fiber.Ctx) error {
 	// Get user ID from context
 	userID := c.Locals("userID").(string)
 
 	// Get portal ID from URL
-	portalID := c.Params("id")
+	portalID := h.portalIDParam(c)
 
 	// Parse request body
 	var req UpdatePortalRequest
@@ -29,54 +31,60 @@ func UpdatePortal(c *fiber.Ctx) error {
 	}
 
 	// Validate input
-	if req.Name == "" {
+	if req.RoutingMode != "" && !models.IsRoutingMode(req.RoutingMode) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Routing mode must be one of manual, round_robin or least_busy",
+		})
+	}
+	if req.Name == "" && req.AllowAdHocCategories == nil && req.RoutingMode == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Name is required",
 		})
 	}
 
-	// Verify portal ownership
-	var portal models.Portal
-	result := database.DB.Where("id = ? AND owner_id = ?", portalID, userID).First(&portal)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Portal not found or unauthorized",
-		})
+	// Verify portal membership
+	portal, err := h.authorizePortal(portalID, userID, models.RoleAdmin)
+	if err != nil {
+		return accessError(c, err)
 	}
 
-	// Check if name is already taken
-	var existingPortal models.Portal
-	result = database.DB.Where("name = ? AND id != ?", req.Name, portalID).First(&existingPortal)
-	if result.RowsAffected > 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "This portal name is already taken",
+	if req.AllowAdHocCategories != nil {
+		portal.AllowAdHocCategories = *req.AllowAdHocCategories
+	}
+	if req.RoutingMode != "" {
+		portal.RoutingMode = req.RoutingMode
+	}
+
+	// Settings-only updates leave the name alone
+	if req.Name == "" || req.Name == portal.Name {
+		if err := h.stores.Portals.Update(&portal); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update portal",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"portal": portal,
 		})
 	}
 
-	// Check if there are any conversations
-	var count int64
-	result = database.DB.Model(&models.Conversation{}).Where("portal_id = ?", portalID).Count(&count)
-	if count > 0 {
+	// Check if name is already taken
+	if taken, _ := h.stores.Portals.NameTaken(req.Name); taken {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Cannot update portal name when conversations exist. Please delete all conversations first.",
+			"error": "This portal name is already taken",
 		})
 	}
 
-	// Generate custom name based on new name
-	customName := utils.Slugify(req.Name)
-	
-	// Check if custom name is already taken
-	result = database.DB.Where("custom_name = ? AND id != ?", customName, portalID).First(&existingPortal)
-	if result.RowsAffected > 0 {
-		// Append a random string to make it unique
-		customName = customName + "-" + utils.GenerateRandomCode()
-	}
+	// Generate custom name based on new name, with a numbered suffix if it is already taken
+	customName := slug.Unique(slug.Make(req.Name), func(candidate string) bool {
+		return h.customNameTaken(candidate, portal.ID)
+	})
 
-	// Update the portal
+	// Update the portal, keeping the old custom name for redirects
+	oldCustomName := portal.CustomName
 	portal.Name = req.Name
 	portal.CustomName = customName
-	result = database.DB.Save(&portal)
-	if result.Error != nil {
+	if err := h.stores.Portals.Rename(&portal, oldCustomName); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update portal",
 		})
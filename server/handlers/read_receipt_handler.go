@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+)
+
+// MarkReadRequest represents the expected body for marking a conversation as read
+type MarkReadRequest struct {
+	// MessageID is the last message read; empty means the latest message
+	MessageID string `json:"messageId"`
+}
+
+// MarkConversationRead records that the authenticated owner has read a conversation
+func (h *Handler) MarkConversationRead(c *fiber.Ctx) error {
+	// Get user ID from context
+	userID := c.Locals("userID").(string)
+
+	// Get conversation ID from URL
+	conversationID := c.Params("id")
+
+	// Parse request body (optional)
+	var req MarkReadRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	// Verify portal membership
+	if _, _, err := h.authorizeConversation(conversationID, userID, models.RoleViewer); err != nil {
+		return accessError(c, err)
+	}
+
+	user := h.teamSender(userID)
+
+	receipt, err := h.markRead(conversationID, realtime.Participant{ID: user.ID, Name: user.Name, IsOwner: true}, req.MessageID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"receipt": receipt,
+	})
+}
+
+// markRead records that a participant has read a conversation up to a message
+// and announces it to real-time subscribers. Receipts never move backwards.
+func (h *Handler) markRead(conversationID string, reader realtime.Participant, messageID string) (*models.ReadReceipt, error) {
+	// Find the message being acknowledged, defaulting to the latest one
+	var message models.Message
+	var err error
+	if messageID != "" {
+		message, err = h.stores.Messages.Get(conversationID, messageID)
+	} else {
+		message, err = h.stores.Messages.Latest(conversationID)
+	}
+	if err != nil {
+		return nil, errors.New("Message not found")
+	}
+
+	receipt := models.ReadReceipt{
+		ConversationID:    conversationID,
+		ParticipantID:     reader.ID,
+		IsOwner:           reader.IsOwner,
+		LastReadMessageID: message.ID,
+		LastReadSeq:       message.Seq,
+		ReadAt:            time.Now(),
+	}
+
+	// Insert or advance the participant's receipt, and return what is stored,
+	// which may be further along than this request
+	advanced, err := h.stores.ReadReceipts.Advance(&receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	if advanced {
+		h.events.Publish(realtime.NewReadEvent(conversationID, reader, receipt.LastReadMessageID, receipt.LastReadSeq, receipt.ReadAt))
+	}
+
+	return &receipt, nil
+}
+
+// unreadCount returns how many messages from the other side a participant has not read yet
+func (h *Handler) unreadCount(conversationID, participantID string, isOwner bool) int64 {
+	count, _ := h.stores.Messages.CountUnread(conversationID, participantID, isOwner)
+	return count
+}
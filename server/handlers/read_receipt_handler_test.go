@@ -0,0 +1,83 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"server/database/models"
+)
+
+// unreadCounts returns the unread count of each conversation in a portal
+func (s *testServer) unreadCounts(token, portalID string) map[string]int64 {
+	s.t.Helper()
+
+	var resp struct {
+		Conversations []models.Conversation `json:"conversations"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/portals/" + portalID + "/conversations", token: token}, &resp)
+
+	counts := map[string]int64{}
+	for _, conversation := range resp.Conversations {
+		counts[conversation.ID] = conversation.UnreadCount
+	}
+	return counts
+}
+
+func TestMarkConversationReadClearsUnreadCount(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	bob, bobToken := s.user("Bob", "bob@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.member(ownerToken, portal.ID, bob, bobToken, models.RoleAgent)
+	s.category(ownerToken, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+	s.customerMessage(conversation.ID, customerToken, "Anyone there?")
+
+	if got := s.unreadCounts(ownerToken, portal.ID)[conversation.ID]; got != 2 {
+		t.Fatalf("unread count = %d, want 2", got)
+	}
+
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/read", token: ownerToken}, nil)
+
+	if got := s.unreadCounts(ownerToken, portal.ID)[conversation.ID]; got != 0 {
+		t.Fatalf("unread count after reading = %d, want 0", got)
+	}
+	// Receipts belong to one team member
+	if got := s.unreadCounts(bobToken, portal.ID)[conversation.ID]; got != 2 {
+		t.Fatalf("Bob's unread count = %d, want 2", got)
+	}
+
+	s.customerMessage(conversation.ID, customerToken, "Thanks")
+	if got := s.unreadCounts(ownerToken, portal.ID)[conversation.ID]; got != 1 {
+		t.Fatalf("unread count after a new message = %d, want 1", got)
+	}
+}
+
+func TestMarkConversationReadNeverMovesBack(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.user("Ada", "ada@example.com")
+	portal := s.portal(ownerToken, "Acme")
+	s.category(ownerToken, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(ownerToken, portal.ID, "Billing", "Grace")
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+
+	var first struct {
+		Receipt models.ReadReceipt `json:"receipt"`
+	}
+	s.expect(http.StatusOK, request{method: "GET", path: "/api/conversations/" + conversation.ID + "/messages", token: ownerToken}, nil)
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/read", token: ownerToken}, &first)
+
+	s.customerMessage(conversation.ID, customerToken, "Thanks")
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/read", token: ownerToken}, nil)
+
+	// Reading the older message again keeps the receipt on the newest one
+	var again struct {
+		Receipt models.ReadReceipt `json:"receipt"`
+	}
+	s.expect(http.StatusOK, request{method: "POST", path: "/api/conversations/" + conversation.ID + "/read", token: ownerToken, body: map[string]string{"messageId": first.Receipt.LastReadMessageID}}, &again)
+	if again.Receipt.LastReadMessageID == first.Receipt.LastReadMessageID {
+		t.Fatalf("receipt moved back to %q", again.Receipt.LastReadMessageID)
+	}
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"server/database/models"
+	"server/realtime"
+	"server/store"
+)
+
+// historyBatchSize caps how many missed messages are replayed on join
+const historyBatchSize = 200
+
+// Long-poll wait limits, in seconds
+const (
+	defaultPollTimeout = 25
+	maxPollTimeout     = 60
+)
+
+// realtimeIdentity is who a real-time subscriber authenticated as (see middleware.RealtimeAuth)
+type realtimeIdentity struct {
+	// UserID is set when the subscriber presented a support user's JWT
+	UserID string
+	// CustomerConversationID is set when the subscriber presented a customer token
+	CustomerConversationID string
+	// CustomerTokenVersion is the version of that token, checked against the conversation
+	CustomerTokenVersion int
+}
+
+// identityFromLocals reads the identity stored by middleware.RealtimeAuth
+func identityFromLocals(locals func(key string) interface{}) realtimeIdentity {
+	var identity realtimeIdentity
+	if userID, ok := locals("userID").(string); ok {
+		identity.UserID = userID
+	}
+	if conversationID, ok := locals("customerConversationID").(string); ok {
+		identity.CustomerConversationID = conversationID
+	}
+	if version, ok := locals("customerTokenVersion").(int); ok {
+		identity.CustomerTokenVersion = version
+	}
+	return identity
+}
+
+// authorizeRealtime checks that a subscriber may access a conversation and returns the
+// identity it acts as there. Team members act as themselves and need at least
+// minRole on the portal; customers act as the conversation's customer.
+func (h *Handler) authorizeRealtime(identity realtimeIdentity, conversationID, minRole string) (models.User, bool, error) {
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		return models.User{}, false, errors.New("Conversation not found")
+	}
+
+	// Support users must be on the portal's team
+	if identity.UserID != "" {
+		role := h.portalRole(models.Portal{ID: conversation.PortalID, OwnerID: conversation.OwnerID}, identity.UserID)
+		if role == "" {
+			return models.User{}, false, errConversationAccess
+		}
+		if !models.RoleAtLeast(role, minRole) {
+			return models.User{}, false, errInsufficientRole
+		}
+
+		return h.teamSender(identity.UserID), true, nil
+	}
+
+	// Customers may only access the conversation their token was issued for, until it is revoked
+	if identity.CustomerConversationID != "" && identity.CustomerConversationID == conversation.ID &&
+		identity.CustomerTokenVersion == conversation.CustomerTokenVersion {
+		return models.User{
+			ID:   conversation.CustomerID,
+			Name: conversation.CustomerName,
+		}, false, nil
+	}
+
+	return models.User{}, false, errors.New("Conversation not found or unauthorized")
+}
+
+// errHistory is reported when the messages after a client's cursor cannot be
+// loaded; the client should retry from the same cursor
+var errHistory = errors.New("Failed to fetch messages")
+
+// historySince builds the history batch of messages after a client's cursor
+func (h *Handler) historySince(conversationID, since string) (realtime.Event, error) {
+	// The cursor may be a sequence number, a timestamp or the ID of the last message seen
+	var messages []models.Message
+	var err error
+	if seq, parseErr := strconv.ParseInt(since, 10, 64); parseErr == nil {
+		messages, err = h.stores.Messages.ListAfterSeq(conversationID, seq, historyBatchSize+1)
+	} else if t, parseErr := time.Parse(time.RFC3339Nano, since); parseErr == nil {
+		messages, err = h.stores.Messages.ListAfterTime(conversationID, t, historyBatchSize+1)
+	} else {
+		// An unknown message ID replays from the start of the conversation
+		var last models.Message
+		last, err = h.stores.Messages.Get(conversationID, since)
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			messages, err = h.stores.Messages.ListAfterSeq(conversationID, last.Seq, historyBatchSize+1)
+		}
+	}
+	if err != nil {
+		return realtime.Event{}, err
+	}
+
+	hasMore := len(messages) > historyBatchSize
+	if hasMore {
+		messages = messages[:historyBatchSize]
+	}
+
+	// Resolve sender names the same way the public message endpoint does
+	conversation, err := h.stores.Conversations.GetByID(conversationID)
+	if err != nil {
+		return realtime.Event{}, err
+	}
+
+	history := make([]realtime.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		sender := realtime.Sender{ID: message.SenderID, Name: conversation.CustomerName}
+		if message.IsOwner {
+			sender.Name = h.teamSender(message.SenderID).Name
+		}
+
+		history = append(history, chatMessage(message, sender))
+	}
+
+	return realtime.NewHistoryEvent(conversationID, history, hasMore), nil
+}
+
+// ConversationEvents streams a conversation's real-time events as Server-Sent
+// Events, for networks that block WebSocket upgrades. A reconnecting client
+// resumes from the Last-Event-ID header (or lastEventId query parameter).
+func (h *Handler) ConversationEvents(hub *realtime.Hub) fiber.Handler {
+	// Streams are kept alive as often as WebSocket connections are pinged
+	keepAlive := hub.PingInterval()
+
+	return func(c *fiber.Ctx) error {
+		// Get conversation ID from URL
+		conversationID := c.Params("id")
+
+		// Refuse conversations the subscriber is not allowed to see
+		identity := identityFromLocals(func(key string) interface{} {
+			return c.Locals(key)
+		})
+		sender, isOwner, err := h.authorizeRealtime(identity, conversationID, models.RoleViewer)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		since := c.Get("Last-Event-ID")
+		if since == "" {
+			since = c.Query("lastEventId")
+		}
+
+		var replay func() []realtime.Event
+		var replayErr error
+		if since != "" {
+			replay = func() []realtime.Event {
+				history, err := h.historySince(conversationID, since)
+				if err != nil {
+					replayErr = err
+					return nil
+				}
+				return []realtime.Event{history}
+			}
+		}
+
+		participant := realtime.Participant{ID: sender.ID, Name: sender.Name, IsOwner: isOwner, TokenVersion: identity.CustomerTokenVersion}
+		client := hub.Subscribe(conversationID, participant, replay)
+		if client == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Server is shutting down",
+			})
+		}
+
+		// Streaming without what the client missed would skip it for good
+		if replayErr != nil {
+			hub.Unsubscribe(client)
+			log.Printf("Failed to replay conversation %s since %s: %v", conversationID, since, replayErr)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errHistory.Error(),
+			})
+		}
+
+		// Tell the client who else is here
+		client.Send(realtime.NewPresenceSnapshot(conversationID, hub.Participants(conversationID)))
+
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer hub.Unsubscribe(client)
+
+			ticker := time.NewTicker(keepAlive)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case frame := <-client.Frames():
+					if frame.ID != "" {
+						fmt.Fprintf(w, "id: %s\n", frame.ID)
+					}
+					fmt.Fprintf(w, "data: %s\n\n", frame.Data)
+				case <-ticker.C:
+					// Comments keep proxies from closing an idle stream
+					fmt.Fprint(w, ": keep-alive\n\n")
+				case <-client.Done():
+					return
+				}
+
+				// A failed flush means the client has gone away
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+
+		return nil
+	}
+}
+
+// PollConversationEvents is the long-polling fallback. It waits up to
+// `timeout` seconds for events after `cursor` (or Last-Event-ID) and returns
+// them together with the cursor to send on the next poll. A first poll
+// without a cursor starts from the conversation's latest message, so the
+// cursor it returns never skips what is sent in between.
+func (h *Handler) PollConversationEvents(hub *realtime.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get conversation ID from URL
+		conversationID := c.Params("id")
+
+		// Refuse conversations the subscriber is not allowed to see
+		identity := identityFromLocals(func(key string) interface{} {
+			return c.Locals(key)
+		})
+		_, isOwner, err := h.authorizeRealtime(identity, conversationID, models.RoleViewer)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		cursor := c.Query("cursor")
+		if cursor == "" {
+			cursor = c.Get("Last-Event-ID")
+		}
+		if cursor == "" {
+			latest, err := h.stores.Messages.Latest(conversationID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to fetch messages",
+				})
+			}
+			cursor = strconv.FormatInt(latest.Seq, 10)
+		}
+
+		timeout := c.QueryInt("timeout", defaultPollTimeout)
+		if timeout < 1 || timeout > maxPollTimeout {
+			timeout = defaultPollTimeout
+		}
+
+		// Messages missed since the cursor are returned straight away
+		var replayErr error
+		replay := func() []realtime.Event {
+			history, err := h.historySince(conversationID, cursor)
+			if err != nil {
+				replayErr = err
+				return nil
+			}
+			if data, ok := history.Data.(realtime.HistoryData); ok && len(data.Messages) == 0 {
+				return nil
+			}
+			return []realtime.Event{history}
+		}
+
+		// Polls come and go, so they do not count towards presence
+		participant := realtime.Participant{IsOwner: isOwner, TokenVersion: identity.CustomerTokenVersion}
+		client := hub.Subscribe(conversationID, participant, replay)
+		if client == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Server is shutting down",
+			})
+		}
+		defer hub.Unsubscribe(client)
+
+		// The client polls again from the same cursor
+		if replayErr != nil {
+			log.Printf("Failed to replay conversation %s since %s: %v", conversationID, cursor, replayErr)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errHistory.Error(),
+			})
+		}
+
+		events := []json.RawMessage{}
+		collect := func(frame realtime.Frame) {
+			events = append(events, json.RawMessage(frame.Data))
+			if frame.ID != "" {
+				cursor = frame.ID
+			}
+		}
+
+		// Wait for the first event
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		select {
+		case frame := <-client.Frames():
+			collect(frame)
+		case <-timer.C:
+		case <-client.Done():
+		}
+
+		// Return whatever else is already queued with it
+		for drained := false; !drained; {
+			select {
+			case frame := <-client.Frames():
+				collect(frame)
+			default:
+				drained = true
+			}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"events": events,
+			"cursor": cursor,
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"server/realtime"
+)
+
+// pollResponse is the body of the long-polling endpoint
+type pollResponse struct {
+	Events []realtime.Event `json:"events"`
+	Cursor string           `json:"cursor"`
+}
+
+// poll long-polls a conversation's events as its customer
+func (s *testServer) poll(conversationID, customerToken, cursor string) pollResponse {
+	s.t.Helper()
+
+	path := "/api/conversation/public/" + conversationID + "/poll?timeout=1&customerToken=" + customerToken
+	if cursor != "" {
+		path += "&cursor=" + cursor
+	}
+
+	var resp pollResponse
+	s.expect(http.StatusOK, request{method: "GET", path: path}, &resp)
+	return resp
+}
+
+func TestFirstPollStartsFromTheLatestMessage(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	conversation, customerToken := s.conversation(token, portal.ID, "Billing", "Grace")
+
+	// Without messages the cursor still lets the next poll catch the first one
+	empty := s.poll(conversation.ID, customerToken, "")
+	if empty.Cursor == "" || len(empty.Events) != 0 {
+		t.Fatalf("first poll = %+v, want a cursor and no events", empty)
+	}
+	s.customerMessage(conversation.ID, customerToken, "Hello")
+	caught := s.poll(conversation.ID, customerToken, empty.Cursor)
+	if len(caught.Events) != 1 || caught.Events[0].Type != realtime.EventHistory {
+		t.Fatalf("poll after a message = %+v, want its history", caught)
+	}
+
+	// Once there are messages a first poll skips them rather than replaying nothing forever
+	s.customerMessage(conversation.ID, customerToken, "Anyone there?")
+	first := s.poll(conversation.ID, customerToken, "")
+	if len(first.Events) != 0 {
+		t.Fatalf("first poll replayed %d events", len(first.Events))
+	}
+	s.customerMessage(conversation.ID, customerToken, "Hello again")
+	next := s.poll(conversation.ID, customerToken, first.Cursor)
+	var history realtime.HistoryData
+	if len(next.Events) == 1 {
+		history, _ = next.Events[0].Data.(realtime.HistoryData)
+	}
+	if len(history.Messages) != 1 || history.Messages[0].Content != "Hello again" {
+		data, _ := json.Marshal(next)
+		t.Fatalf("poll after the first = %s, want only the newest message", data)
+	}
+	if next.Cursor == first.Cursor {
+		t.Fatalf("cursor stayed at %s", next.Cursor)
+	}
+}
+
+func TestMessagesAreNumberedPerConversation(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.user("Ada", "ada@example.com")
+	portal := s.portal(token, "Acme")
+	s.category(token, portal.ID, "Billing")
+	first, firstToken := s.conversation(token, portal.ID, "Billing", "Grace")
+	second, secondToken := s.conversation(token, portal.ID, "Billing", "Linus")
+
+	s.customerMessage(first.ID, firstToken, "Hello")
+	s.customerMessage(second.ID, secondToken, "Hi")
+	s.customerMessage(first.ID, firstToken, "Anyone there?")
+
+	// Messages of other conversations leave no gaps in a conversation's numbers
+	caught := s.poll(first.ID, firstToken, "0")
+	var history realtime.HistoryData
+	if len(caught.Events) == 1 {
+		history, _ = caught.Events[0].Data.(realtime.HistoryData)
+	}
+	if len(history.Messages) != 2 || history.Messages[0].Seq != 1 || history.Messages[1].Seq != 2 {
+		data, _ := json.Marshal(caught)
+		t.Fatalf("poll from the start = %s, want messages 1 and 2", data)
+	}
+	if caught.Cursor != "2" {
+		t.Fatalf("cursor = %s, want 2", caught.Cursor)
+	}
+}
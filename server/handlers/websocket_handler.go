@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/websocket/v2"
+
+	"server/database/models"
+	"server/realtime"
+)
+
+// WebSocket returns the handler for authenticated WebSocket connections on /ws
+func (h *Handler) WebSocket(hub *realtime.Hub) func(*websocket.Conn) {
+	return func(c *websocket.Conn) {
+		// Attach the identity established during the handshake
+		identity := identityFromLocals(func(key string) interface{} {
+			return c.Locals(key)
+		})
+
+		hub.Serve(c, func(client *realtime.Client, cmd realtime.Command) {
+			// Handle different message types
+			switch cmd.Type {
+			case realtime.CommandJoin:
+				if cmd.PortalID != "" {
+					h.wsJoinPortal(hub, client, identity, cmd)
+				} else {
+					h.wsJoin(hub, client, identity, cmd)
+				}
+			case realtime.CommandLeave:
+				if cmd.PortalID != "" {
+					hub.Leave(client, realtime.PortalRoom(cmd.PortalID))
+				} else if cmd.ConversationID != "" {
+					hub.Leave(client, cmd.ConversationID)
+					log.Printf("WebSocket client left room: %s", cmd.ConversationID)
+				}
+			case realtime.CommandMessage:
+				h.wsMessage(client, identity, cmd)
+			case realtime.CommandTyping:
+				h.wsTyping(client, cmd)
+			case realtime.CommandRead:
+				h.wsRead(client, cmd)
+			}
+		})
+	}
+}
+
+// wsJoin subscribes a client to a conversation it is allowed to access
+func (h *Handler) wsJoin(hub *realtime.Hub, client *realtime.Client, identity realtimeIdentity, cmd realtime.Command) {
+	if cmd.ConversationID == "" {
+		return
+	}
+
+	// Refuse rooms the client is not allowed to see
+	sender, isOwner, err := h.authorizeRealtime(identity, cmd.ConversationID, models.RoleViewer)
+	if err != nil {
+		log.Printf("WebSocket join refused for room %s: %v", cmd.ConversationID, err)
+		client.Send(realtime.NewJoinError(cmd.ConversationID, err))
+		return
+	}
+
+	// Send acknowledgment
+	client.Send(realtime.NewJoinAck(cmd.ConversationID))
+
+	participant := realtime.Participant{ID: sender.ID, Name: sender.Name, IsOwner: isOwner, TokenVersion: identity.CustomerTokenVersion}
+	if cmd.Since == "" {
+		hub.Join(client, cmd.ConversationID, participant)
+	} else {
+		// Replay what the client missed before any live event
+		var replayErr error
+		hub.JoinWithReplay(client, cmd.ConversationID, participant, func() []realtime.Event {
+			history, err := h.historySince(cmd.ConversationID, cmd.Since)
+			if err != nil {
+				replayErr = err
+				return nil
+			}
+			return []realtime.Event{history}
+		})
+
+		// Live events without what the client missed would skip it for good,
+		// so the client has to join again
+		if replayErr != nil {
+			log.Printf("WebSocket replay failed for room %s since %s: %v", cmd.ConversationID, cmd.Since, replayErr)
+			hub.Leave(client, cmd.ConversationID)
+			client.Send(realtime.NewJoinError(cmd.ConversationID, errHistory))
+			return
+		}
+	}
+	log.Printf("WebSocket client joined room: %s", cmd.ConversationID)
+
+	// Tell the client who else is here
+	client.Send(realtime.NewPresenceSnapshot(cmd.ConversationID, hub.Participants(cmd.ConversationID)))
+}
+
+// wsJoinPortal subscribes a team member to their portal's team room, which
+// carries events such as assignments for every conversation of the portal
+func (h *Handler) wsJoinPortal(hub *realtime.Hub, client *realtime.Client, identity realtimeIdentity, cmd realtime.Command) {
+	if identity.UserID == "" {
+		client.Send(realtime.NewPortalJoinError(cmd.PortalID, errPortalAccess))
+		return
+	}
+
+	portal, err := h.authorizePortal(cmd.PortalID, identity.UserID, models.RoleViewer)
+	if err != nil {
+		log.Printf("WebSocket join refused for portal %s: %v", cmd.PortalID, err)
+		client.Send(realtime.NewPortalJoinError(cmd.PortalID, err))
+		return
+	}
+
+	client.Send(realtime.NewPortalJoinAck(portal.ID))
+	hub.Join(client, realtime.PortalRoom(portal.ID), h.teamParticipant(identity.UserID))
+	log.Printf("WebSocket client joined portal room: %s", portal.ID)
+}
+
+// wsTyping relays a typing indicator to the rest of the room
+func (h *Handler) wsTyping(client *realtime.Client, cmd realtime.Command) {
+	participant, joined := client.Participant(cmd.ConversationID)
+	if !joined {
+		return
+	}
+
+	h.events.Publish(realtime.NewTypingEvent(cmd.ConversationID, participant, cmd.IsTyping))
+}
+
+// wsRead stores a read receipt sent over the WebSocket
+func (h *Handler) wsRead(client *realtime.Client, cmd realtime.Command) {
+	participant, joined := client.Participant(cmd.ConversationID)
+	if !joined {
+		return
+	}
+
+	if _, err := h.markRead(cmd.ConversationID, participant, cmd.MessageID); err != nil {
+		log.Printf("Error storing read receipt for room %s: %v", cmd.ConversationID, err)
+	}
+}
+
+// wsMessage stores a message posted over the WebSocket and broadcasts it to the room
+func (h *Handler) wsMessage(client *realtime.Client, identity realtimeIdentity, cmd realtime.Command) {
+	if cmd.ConversationID == "" || cmd.Content == "" || len(cmd.ClientMessageID) > maxClientMessageIDLength {
+		return
+	}
+
+	// Only clients that joined the room may post to it
+	if !client.InRoom(cmd.ConversationID) {
+		log.Printf("WebSocket message refused for room %s: not joined", cmd.ConversationID)
+		return
+	}
+
+	// The sender is derived from the connection, never from the payload
+	sender, isOwner, err := h.authorizeRealtime(identity, cmd.ConversationID, models.RoleAgent)
+	if err != nil {
+		log.Printf("WebSocket message refused for room %s: %v", cmd.ConversationID, err)
+		return
+	}
+
+	// Save the message and broadcast it to the room
+	message := models.Message{
+		Content:        cmd.Content,
+		SenderID:       sender.ID,
+		ConversationID: cmd.ConversationID,
+		IsOwner:        isOwner,
+	}
+	if cmd.ClientMessageID != "" {
+		message.ClientMessageID = &cmd.ClientMessageID
+	}
+
+	created, err := h.createMessage(&message, sender)
+	if err != nil {
+		log.Printf("Error saving message to database: %v", err)
+		return
+	}
+
+	// The room already saw the original, so only the resending client hears about it again
+	if !created {
+		client.Send(realtime.NewMessageEvent(chatMessage(message, realtime.Sender{ID: sender.ID, Name: sender.Name})))
+		return
+	}
+	log.Printf("Broadcasted message to room %s", cmd.ConversationID)
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// File writes each message to a .eml file in a directory instead of sending
+// it, so that links in emails can be followed during local development
+type File struct {
+	dir  string
+	from string
+	seq  uint64
+}
+
+// NewFile returns a mailer that writes messages into dir, creating it if needed
+func NewFile(dir, from string) *File {
+	return &File{dir: dir, from: from}
+}
+
+// Send writes a message to a new file
+func (m *File) Send(msg Message) error {
+	body, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	// Keep file names sortable by time and free of path separators
+	recipient := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := fmt.Sprintf("%s-%04d-%s.eml", time.Now().Format("20060102T150405"), atomic.AddUint64(&m.seq, 1), recipient)
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o600)
+}
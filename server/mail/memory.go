@@ -0,0 +1,33 @@
+package mail
+
+import "sync"
+
+// Memory keeps sent messages in memory, for tests
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+// NewMemory returns a mailer that keeps every message it is given
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send stores a message
+func (m *Memory) Send(msg Message) error {
+	if _, err := format("", msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+)
+
+// SMTP sends mail through an SMTP server. The connection is upgraded with
+// STARTTLS when the server offers it.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTP returns a mailer that sends through the server at host:port as from.
+// Without a username no authentication is attempted.
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	m := &SMTP{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers a message. The envelope sender is the bare address of from,
+// which may carry a display name in the From header only.
+func (m *SMTP) Send(msg Message) error {
+	sender, err := netmail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	body, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, sender.Address, []string{msg.To}, body)
+}
@@ -2,53 +2,30 @@
 package main
 
 import (
-    "encoding/json"
     "log"
+    netmail "net/mail"
     "os"
-    "sync"
-    "time"
+    "os/signal"
+    "syscall"
 
     "github.com/gofiber/fiber/v2"
     "github.com/gofiber/fiber/v2/middleware/cors"
     "github.com/gofiber/fiber/v2/middleware/logger"
     recoverMiddleware "github.com/gofiber/fiber/v2/middleware/recover"
-    "github.com/gofiber/websocket/v2"
     "github.com/joho/godotenv"
 
+    "server/config"
     "server/database"
-    "server/database/models"
+    "server/handlers"
+    "server/mail"
+    "server/migrations"
+    "server/realtime"
+    "server/realtime/pgnotify"
     "server/routes"
+    "server/store/postgres"
     "server/utils"
 )
 
-// WebSocket related types and variables
-type WSClient struct {
-    Conn     *websocket.Conn
-    RoomsMtx sync.RWMutex
-    Rooms    map[string]bool
-}
-
-type WSMessage struct {
-    Type           string                 `json:"type"`
-    Content        string                 `json:"content,omitempty"`
-    ConversationID string                 `json:"conversationId,omitempty"`
-    SenderID       string                 `json:"senderId,omitempty"`
-    SenderName     string                 `json:"senderName,omitempty"`
-    IsOwner        bool                   `json:"isOwner,omitempty"`
-    Data           map[string]interface{} `json:"data,omitempty"`
-}
-
-var (
-    // Clients holds all connected websocket clients
-    wsClients = make(map[*WSClient]bool)
-    // ClientsMutex protects the clients map
-    wsClientsMutex sync.RWMutex
-    // RoomClients maps room names to sets of clients
-    wsRoomClients = make(map[string]map[*WSClient]bool)
-    // RoomMutex protects the roomClients map
-    wsRoomMutex sync.RWMutex
-)
-
 func main() {
     // Load environment variables from .env file
     if err := godotenv.Load(); err != nil {
@@ -60,9 +37,21 @@ func main() {
         log.Fatalf("Failed to connect to database: %v", err)
     }
     
-    // Run data migration for new URL structure
-    if shouldMigrate() {
-        utils.MigrateData()
+    // Refuse to run against an outdated schema; migrations are applied with cmd/migrate
+    if err := migrations.EnsureCurrent(database.DB); err != nil {
+        log.Fatalf("Database schema is not current: %v (run `go run ./cmd/migrate up`)", err)
+    }
+    stores := postgres.New(database.DB)
+
+    // Catch bad CONVERSATION_CODE_* settings before the first link is made
+    cfg := config.LoadConfig()
+    if err := utils.ValidateCodeSettings(cfg.ConversationCodeAlphabet, cfg.ConversationCodeLength); err != nil {
+        log.Fatalf("Invalid conversation code settings: %v", err)
+    }
+
+    // Refuse to start rather than ignore a deployment's outdated settings
+    if err := config.CheckRemovedSettings(); err != nil {
+        log.Fatalf("Invalid configuration: %v", err)
     }
 
     // Initialize Fiber app with custom settings
@@ -90,31 +79,65 @@ func main() {
     app.Use(cors.New(cors.Config{
         AllowOrigins:     "*",
         AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-        AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With",
+        AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-Customer-Token, X-Portal-ID",
         AllowCredentials: false,
         ExposeHeaders:    "Content-Length",
         MaxAge:           86400, // 24 hours
     }))
 
-    // WebSocket upgrade middleware
-    app.Use("/ws", func(c *fiber.Ctx) error {
-        // IsWebSocketUpgrade returns true if the client
-        // requested upgrade to the WebSocket protocol.
-        if websocket.IsWebSocketUpgrade(c) {
-            c.Locals("allowed", true)
-            return c.Next()
-        }
-        return fiber.ErrUpgradeRequired
+    // Fan events out through Postgres when running more than one instance
+    var backend realtime.Backend
+    switch cfg.RealtimeBackend {
+    case "memory":
+    case "postgres":
+        backend = pgnotify.New(database.DB, database.DSN(cfg), cfg.RealtimeChannel)
+    default:
+        log.Fatalf("Unknown REALTIME_BACKEND %q (expected memory or postgres)", cfg.RealtimeBackend)
+    }
+
+    hub := realtime.NewHub(realtime.Options{
+        SendQueueSize:  cfg.WSSendQueueSize,
+        WriteTimeout:   cfg.WSWriteTimeout,
+        PingInterval:   cfg.WSPingInterval,
+        PongTimeout:    cfg.WSPongTimeout,
+        MaxMessageSize: int64(cfg.WSMaxMessageSize),
+        Backend:        backend,
     })
+    if err := hub.Start(); err != nil {
+        log.Fatalf("Failed to start realtime hub: %v", err)
+    }
 
-    // WebSocket route
-    app.Get("/ws", websocket.New(func(c *websocket.Conn) {
-        // Handle new WebSocket connection
-        handleWebSocketConnection(c)
-    }))
+    // Account emails go out over SMTP, or into files during development.
+    // Outside development the backend must be chosen explicitly, so that a
+    // missing setting cannot leave reset links lying around on disk.
+    mailBackend := cfg.MailBackend
+    if mailBackend == "" && cfg.IsDevelopment() {
+        mailBackend = "file"
+    }
+    var mailer mail.Mailer
+    switch mailBackend {
+    case "smtp":
+        if _, err := netmail.ParseAddress(cfg.MailFrom); err != nil {
+            log.Fatalf("Invalid MAIL_FROM %q: %v", cfg.MailFrom, err)
+        }
+        mailer = mail.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+    case "file":
+        if !cfg.IsDevelopment() {
+            log.Printf("Warning: MAIL_BACKEND=file writes account emails to %s instead of sending them", cfg.MailDir)
+        }
+        mailer = mail.NewFile(cfg.MailDir, cfg.MailFrom)
+    case "":
+        log.Fatalf("MAIL_BACKEND must be set to smtp or file outside development")
+    default:
+        log.Fatalf("Unknown MAIL_BACKEND %q (expected smtp or file)", mailBackend)
+    }
+
+    // Reopen snoozed conversations once their snooze runs out
+    h := handlers.New(stores, hub, mailer)
+    h.StartSnoozeTimer(cfg.SnoozeCheckInterval)
 
-    // Setup regular API routes
-    routes.SetupRoutes(app)
+    // Setup WebSocket and regular API routes
+    routes.SetupRoutes(app, hub, h, stores.Users)
 
     // Add healthcheck endpoint
     app.Get("/health", func(c *fiber.Ctx) error {
@@ -128,219 +151,36 @@ func main() {
     }
 
     log.Printf("Server starting on port %s...\n", port)
-    if err := app.Listen(":" + port); err != nil {
-        log.Fatalf("Failed to start server: %v", err)
-    }
-}
-
-// shouldMigrate checks if migration should be performed
-func shouldMigrate() bool {
-    // Default to true - always migrate by default
-    migrate := true
-    
-    // Check for env variable to disable migration
-    skipMigrate := os.Getenv("SKIP_MIGRATION")
-    if skipMigrate == "true" || skipMigrate == "1" || skipMigrate == "yes" {
-        migrate = false
-    }
-    
-    return migrate
-}
-
-// handleWebSocketConnection handles a new WebSocket connection
-func handleWebSocketConnection(c *websocket.Conn) {
-    // Create a new client
-    client := &WSClient{
-        Conn:  c,
-        Rooms: make(map[string]bool),
-    }
-
-    // Register the client
-    wsClientsMutex.Lock()
-    wsClients[client] = true
-    wsClientsMutex.Unlock()
-
-    log.Println("WebSocket client connected")
-
-    // Handle WebSocket messages
-    defer func() {
-        // Handle panics
-        if r := recover(); r != nil {
-            log.Printf("Recovered from panic in WebSocket handler: %v", r)
-        }
-
-        // Remove client from rooms
-        client.RoomsMtx.RLock()
-        rooms := make([]string, 0, len(client.Rooms))
-        for room := range client.Rooms {
-            rooms = append(rooms, room)
+    go func() {
+        if err := app.Listen(":" + port); err != nil {
+            log.Fatalf("Failed to start server: %v", err)
         }
-        client.RoomsMtx.RUnlock()
-
-        for _, room := range rooms {
-            wsLeaveRoom(client, room)
-        }
-
-        // Remove client from clients map
-        wsClientsMutex.Lock()
-        delete(wsClients, client)
-        wsClientsMutex.Unlock()
-
-        log.Println("WebSocket client disconnected")
     }()
 
-    // Message handling loop
-    for {
-        _, rawMessage, err := c.ReadMessage()
-        if err != nil {
-            log.Printf("WebSocket read error: %v", err)
-            break
-        }
+    // Wait for a termination signal
+    quit := make(chan os.Signal, 1)
+    signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+    <-quit
 
-        var msg WSMessage
-        if err := json.Unmarshal(rawMessage, &msg); err != nil {
-            log.Printf("WebSocket JSON parse error: %v", err)
-            continue
-        }
-
-        log.Printf("Received WebSocket message: %+v", msg)
+    log.Println("Shutting down server...")
 
-        // Handle different message types
-        switch msg.Type {
-        case "join":
-            if msg.ConversationID != "" {
-                wsJoinRoom(client, msg.ConversationID)
-                log.Printf("WebSocket client joined room: %s", msg.ConversationID)
-
-                // Send acknowledgment
-                ack := WSMessage{
-                    Type: "join_ack",
-                    Data: map[string]interface{}{
-                        "conversationId": msg.ConversationID,
-                        "status":         "joined",
-                    },
-                }
-                wsSendToClient(client, ack)
-            }
+    // Tell WebSocket clients we are going away; they would otherwise hold the shutdown open
+    hub.Close()
 
-        case "leave":
-            if msg.ConversationID != "" {
-                wsLeaveRoom(client, msg.ConversationID)
-                log.Printf("WebSocket client left room: %s", msg.ConversationID)
-            }
-
-        case "message":
-            if msg.ConversationID != "" && msg.Content != "" {
-                // Create and save the message to database
-                message := models.Message{
-                    Content:        msg.Content,
-                    SenderID:       msg.SenderID,
-                    ConversationID: msg.ConversationID,
-                    IsOwner:        msg.IsOwner,
-                    CreatedAt:      time.Now(),
-                }
-
-                result := database.DB.Create(&message)
-                if result.Error != nil {
-                    log.Printf("Error saving message to database: %v", result.Error)
-                    continue
-                }
-
-                // Update conversation timestamp
-                database.DB.Model(&models.Conversation{}).Where("id = ?", msg.ConversationID).Update("updated_at", message.CreatedAt)
-
-                // Create a new message to broadcast
-                broadcastMsg := WSMessage{
-                    Type:           "new_message",
-                    Content:        message.Content,
-                    ConversationID: message.ConversationID,
-                    SenderID:       message.SenderID,
-                    SenderName:     msg.SenderName,
-                    IsOwner:        message.IsOwner,
-                    Data: map[string]interface{}{
-                        "id":          message.ID,
-                        "createdAt":   message.CreatedAt,
-                        "sender": map[string]interface{}{
-                            "id":   msg.SenderID,
-                            "name": msg.SenderName,
-                        },
-                    },
-                }
-
-                // Broadcast to the room
-                wsBroadcastToRoom(msg.ConversationID, broadcastMsg)
-                log.Printf("Broadcasted message to room %s", msg.ConversationID)
-            }
-        }
+    // Stop accepting connections and let in-flight requests finish
+    if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+        log.Printf("Error shutting down server: %v", err)
     }
-}
-
-// wsJoinRoom adds a client to a room
-func wsJoinRoom(client *WSClient, room string) {
-    // Add room to client's rooms
-    client.RoomsMtx.Lock()
-    client.Rooms[room] = true
-    client.RoomsMtx.Unlock()
-
-    // Add client to room
-    wsRoomMutex.Lock()
-    if _, exists := wsRoomClients[room]; !exists {
-        wsRoomClients[room] = make(map[*WSClient]bool)
-    }
-    wsRoomClients[room][client] = true
-    wsRoomMutex.Unlock()
-}
-
-// wsLeaveRoom removes a client from a room
-func wsLeaveRoom(client *WSClient, room string) {
-    // Remove room from client's rooms
-    client.RoomsMtx.Lock()
-    delete(client.Rooms, room)
-    client.RoomsMtx.Unlock()
-
-    // Remove client from room
-    wsRoomMutex.Lock()
-    if clients, exists := wsRoomClients[room]; exists {
-        delete(clients, client)
-        if len(clients) == 0 {
-            delete(wsRoomClients, room)
-        }
-    }
-    wsRoomMutex.Unlock()
-}
 
-// wsBroadcastToRoom sends a message to all clients in a room
-func wsBroadcastToRoom(room string, msg WSMessage) {
-    // Get all clients in the room
-    wsRoomMutex.RLock()
-    clients, exists := wsRoomClients[room]
-    if !exists {
-        wsRoomMutex.RUnlock()
-        return
-    }
+    // Stop the timers and send the emails that requests promised before the
+    // database goes away
+    h.Close()
 
-    // Make a copy of clients to avoid holding the lock while sending
-    clientList := make([]*WSClient, 0, len(clients))
-    for client := range clients {
-        clientList = append(clientList, client)
+    // Only now is it safe to release the database
+    if err := database.Close(); err != nil {
+        log.Printf("Error closing database: %v", err)
     }
-    wsRoomMutex.RUnlock()
 
-    // Broadcast the message to all clients in the room
-    for _, client := range clientList {
-        wsSendToClient(client, msg)
-    }
+    log.Println("Server stopped")
 }
 
-// wsSendToClient sends a message to a specific client
-func wsSendToClient(client *WSClient, msg WSMessage) {
-    jsonData, err := json.Marshal(msg)
-    if err != nil {
-        log.Printf("Error marshaling message: %v", err)
-        return
-    }
-
-    if err := client.Conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-        log.Printf("Error writing message: %v", err)
-    }
-}
@@ -1,18 +1,18 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 
-	"server/config"
-	"server/database"
-	"server/database/models"
+	"server/store"
+	"server/utils"
 )
 
 // Protected is a middleware that checks if the request has a valid JWT token
-func Protected() fiber.Handler {
+// of a user in users
+func Protected(users store.UserStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get authorization header
 		authHeader := c.Get("Authorization")
@@ -32,57 +32,48 @@ func Protected() fiber.Handler {
 			})
 		}
 		
-		// Extract the token
-		tokenString := headerParts[1]
-		
-		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the algorithm
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token signing method")
-			}
-			
-			// Return the secret key
-			cfg := config.LoadConfig()
-			return []byte(cfg.JWTSecret), nil
-		})
-		
-		// Handle parsing errors
+		// Extract the token and resolve the user it belongs to
+		userID, err := authenticateUser(users, headerParts[1])
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Unauthorized - " + err.Error(),
 			})
 		}
 		
-		// Validate the token claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Get user ID from claims
-			userID, ok := claims["id"].(string)
-			if !ok {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error": "Unauthorized - Invalid user ID in token",
-				})
-			}
-			
-			// Verify that the user exists
-			var user models.User
-			result := database.DB.First(&user, "id = ?", userID)
-			if result.Error != nil {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error": "Unauthorized - User not found",
-				})
-			}
-			
-			// Set user ID in the context for later use
-			c.Locals("userID", userID)
-			
-			// Continue with the request
-			return c.Next()
-		}
+		// Set user ID in the context for later use
+		c.Locals("userID", userID)
 		
-		// If token is invalid
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized - Invalid token",
-		})
+		// Continue with the request
+		return c.Next()
+	}
+}
+
+// authenticateUser validates a user JWT and verifies that the user still exists
+// and has not revoked the token by logging out of all sessions
+func authenticateUser(users store.UserStore, tokenString string) (string, error) {
+	// Parse and validate the token
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	// Get user ID from claims
+	userID, ok := claims["id"].(string)
+	if !ok {
+		return "", errors.New("Invalid user ID in token")
+	}
+
+	// Verify that the user exists
+	user, err := users.GetByID(userID)
+	if err != nil {
+		return "", errors.New("User not found")
 	}
-}
\ No newline at end of file
+
+	// Numbers in JWT claims are decoded as float64
+	version, _ := claims["ver"].(float64)
+	if int(version) != user.TokenVersion {
+		return "", errors.New("Token has been revoked")
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+
+	"server/store"
+	"server/utils"
+)
+
+// WebSocketAuth authenticates a WebSocket handshake before the connection is upgraded
+func WebSocketAuth(users store.UserStore) fiber.Handler {
+	auth := RealtimeAuth(users)
+
+	return func(c *fiber.Ctx) error {
+		// IsWebSocketUpgrade returns true if the client
+		// requested upgrade to the WebSocket protocol.
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+
+		c.Locals("allowed", true)
+		return auth(c)
+	}
+}
+
+// RealtimeAuth authenticates a real-time subscription (WebSocket, SSE or long poll).
+// Browsers cannot set headers on WebSocket or EventSource requests, so credentials
+// may be passed as query parameters: `token` for support users (checked like
+// Protected) or `customerToken` for a customer's per-conversation token.
+func RealtimeAuth(users store.UserStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Support users authenticate with their JWT
+		token := c.Query("token")
+		if token == "" {
+			if authHeader := c.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+				token = strings.TrimPrefix(authHeader, "Bearer ")
+			}
+		}
+
+		if token != "" {
+			userID, err := authenticateUser(users, token)
+			if err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Unauthorized - " + err.Error(),
+				})
+			}
+
+			c.Locals("userID", userID)
+			return c.Next()
+		}
+
+		// Customers authenticate with the token issued for their conversation
+		if customerToken := c.Query("customerToken"); customerToken != "" {
+			claims, err := utils.ParseCustomerToken(customerToken)
+			if err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Unauthorized - " + err.Error(),
+				})
+			}
+
+			c.Locals("customerConversationID", claims.ConversationID)
+			c.Locals("customerTokenVersion", claims.Version)
+			return c.Next()
+		}
+
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - No token provided",
+		})
+	}
+}
@@ -0,0 +1,11 @@
+-- Drop the whole baseline schema, dependents first
+DROP TABLE IF EXISTS read_receipts;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS portal_invitations;
+DROP TABLE IF EXISTS portal_members;
+DROP TABLE IF EXISTS slug_lookups;
+DROP TABLE IF EXISTS portal_slugs;
+DROP TABLE IF EXISTS portals;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,173 @@
+-- Baseline schema. Databases created before versioned migrations were made
+-- by AutoMigrate, and may date from before any of the columns added since,
+-- so every statement only creates what is missing: tables that exist get the
+-- missing columns added before any index refers to them. This also absorbs
+-- the loose add_url_structure, add_categories, add_portal_slugs and
+-- add_slug_lookups scripts that used to live in this directory.
+
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(36) PRIMARY KEY,
+    email VARCHAR(255),
+    password VARCHAR(255),
+    name VARCHAR(255),
+    selected_portal_id VARCHAR(36),
+    portal_limit BIGINT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS selected_portal_id VARCHAR(36);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS portal_limit BIGINT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS portals (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255),
+    custom_name VARCHAR(255),
+    owner_id VARCHAR(36) CONSTRAINT fk_users_portals REFERENCES users(id),
+    allow_ad_hoc_categories BOOLEAN DEFAULT false,
+    routing_mode VARCHAR(20) DEFAULT 'manual',
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+ALTER TABLE portals ADD COLUMN IF NOT EXISTS allow_ad_hoc_categories BOOLEAN DEFAULT false;
+ALTER TABLE portals ADD COLUMN IF NOT EXISTS routing_mode VARCHAR(20) DEFAULT 'manual';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portals_name ON portals(name);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portals_custom_name ON portals(custom_name);
+
+-- Custom names portals used before a rename
+CREATE TABLE IF NOT EXISTS portal_slugs (
+    id VARCHAR(36) PRIMARY KEY,
+    portal_id VARCHAR(36),
+    slug VARCHAR(255),
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portal_slugs_slug ON portal_slugs(slug);
+CREATE INDEX IF NOT EXISTS idx_portal_slugs_portal_id ON portal_slugs(portal_id);
+
+-- Names that slugs were made from
+CREATE TABLE IF NOT EXISTS slug_lookups (
+    scope VARCHAR(20),
+    slug VARCHAR(255),
+    source VARCHAR(255),
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (scope, slug)
+);
+
+CREATE TABLE IF NOT EXISTS portal_members (
+    id VARCHAR(36) PRIMARY KEY,
+    portal_id VARCHAR(36),
+    user_id VARCHAR(36) CONSTRAINT fk_portal_members_user REFERENCES users(id),
+    role VARCHAR(20),
+    last_assigned_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+ALTER TABLE portal_members ADD COLUMN IF NOT EXISTS last_assigned_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_portal_members_user_id ON portal_members(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portal_members_user ON portal_members(portal_id, user_id);
+
+CREATE TABLE IF NOT EXISTS portal_invitations (
+    id VARCHAR(36) PRIMARY KEY,
+    portal_id VARCHAR(36),
+    email VARCHAR(255),
+    role VARCHAR(20),
+    token_hash VARCHAR(64),
+    invited_by_id VARCHAR(36),
+    expires_at TIMESTAMPTZ,
+    accepted_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_portal_invitations_portal_id ON portal_invitations(portal_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portal_invitations_token_hash ON portal_invitations(token_hash);
+CREATE INDEX IF NOT EXISTS idx_portal_invitations_email ON portal_invitations(email);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id VARCHAR(36) PRIMARY KEY,
+    portal_id VARCHAR(36) CONSTRAINT fk_categories_portal REFERENCES portals(id),
+    name VARCHAR(255),
+    slug VARCHAR(255),
+    description TEXT,
+    sort_order BIGINT DEFAULT 0,
+    enabled BOOLEAN DEFAULT true,
+    routing_mode VARCHAR(20),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+-- The loose add_categories script created the table without routing_mode
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS routing_mode VARCHAR(20);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_portal_slug ON categories(portal_id, slug);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id VARCHAR(36) PRIMARY KEY,
+    unique_code VARCHAR(10),
+    category_id VARCHAR(36),
+    category VARCHAR(255),
+    category_slug VARCHAR(255),
+    customer_id VARCHAR(255),
+    customer_name VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'open',
+    snoozed_until TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    assignee_id VARCHAR(36),
+    assigned_at TIMESTAMPTZ,
+    owner_id VARCHAR(36) CONSTRAINT fk_conversations_owner REFERENCES users(id),
+    portal_id VARCHAR(36) CONSTRAINT fk_portals_conversations REFERENCES portals(id),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS category_id VARCHAR(36);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS status VARCHAR(20) DEFAULT 'open';
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMPTZ;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMPTZ;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS assignee_id VARCHAR(36);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS assigned_at TIMESTAMPTZ;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversations_unique_code ON conversations(unique_code);
+CREATE INDEX IF NOT EXISTS idx_conversations_assignee_id ON conversations(assignee_id);
+CREATE INDEX IF NOT EXISTS idx_conversations_status ON conversations(status);
+CREATE INDEX IF NOT EXISTS idx_conversations_category_id ON conversations(category_id);
+CREATE INDEX IF NOT EXISTS idx_conversations_category_slug ON conversations(category_slug);
+CREATE INDEX IF NOT EXISTS idx_conversations_url_params ON conversations(portal_id, category_slug, unique_code);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id VARCHAR(36) PRIMARY KEY,
+    seq BIGSERIAL,
+    content TEXT,
+    sender_id VARCHAR(255),
+    conversation_id VARCHAR(36) CONSTRAINT fk_conversations_messages REFERENCES conversations(id),
+    is_owner BOOLEAN DEFAULT false,
+    client_message_id VARCHAR(64),
+    created_at TIMESTAMPTZ
+);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_message_id VARCHAR(64);
+
+-- Number messages that predate seq in the order they were sent, rather than
+-- in whatever order a plain BIGSERIAL column would fill them in
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'messages' AND column_name = 'seq'
+    ) THEN
+        ALTER TABLE messages ADD COLUMN seq BIGINT;
+        CREATE SEQUENCE messages_seq_seq OWNED BY messages.seq;
+        UPDATE messages SET seq = numbered.n
+        FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM messages) AS numbered
+        WHERE messages.id = numbered.id;
+        PERFORM setval('messages_seq_seq', COALESCE((SELECT MAX(seq) FROM messages), 0) + 1, false);
+        ALTER TABLE messages ALTER COLUMN seq SET DEFAULT nextval('messages_seq_seq');
+        ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+    END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_message_id ON messages(conversation_id, client_message_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_seq ON messages(seq);
+
+CREATE TABLE IF NOT EXISTS read_receipts (
+    id VARCHAR(36) PRIMARY KEY,
+    conversation_id VARCHAR(36),
+    participant_id VARCHAR(255),
+    is_owner BOOLEAN DEFAULT false,
+    last_read_message_id VARCHAR(36),
+    last_read_seq BIGINT,
+    read_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_read_receipts_participant ON read_receipts(conversation_id, participant_id);
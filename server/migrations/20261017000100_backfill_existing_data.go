@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+
+	"server/utils"
+)
+
+// The data backfill used to run on every boot; it now runs once, after the
+// baseline schema exists
+func init() {
+	register(Migration{
+		Version: "20261017000100",
+		Name:    "backfill_existing_data",
+		Up:      utils.MigrateData,
+		// The backfill only fills in missing values, so there is nothing to undo
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,2 @@
+-- Customer tokens carry the conversation's token version; bumping it revokes them
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS customer_token_version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- Fails while any conversation has a code longer than 10 characters
+ALTER TABLE conversations ALTER COLUMN unique_code TYPE VARCHAR(10);
@@ -0,0 +1,3 @@
+-- Conversation codes have a configurable length of up to 32 characters. The
+-- unique index on the column stays and is what keeps codes unique.
+ALTER TABLE conversations ALTER COLUMN unique_code TYPE VARCHAR(32);
@@ -0,0 +1,4 @@
+ALTER TABLE conversations DROP COLUMN IF EXISTS link_revoked_at;
+ALTER TABLE conversations DROP COLUMN IF EXISTS link_uses;
+ALTER TABLE conversations DROP COLUMN IF EXISTS link_max_uses;
+ALTER TABLE conversations DROP COLUMN IF EXISTS link_expires_at;
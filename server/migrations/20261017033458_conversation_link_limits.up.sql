@@ -0,0 +1,5 @@
+-- Generated conversation links can expire, run out of uses or be revoked
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS link_expires_at TIMESTAMPTZ;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS link_max_uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS link_uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS link_revoked_at TIMESTAMPTZ;
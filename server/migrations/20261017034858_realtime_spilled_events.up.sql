@@ -0,0 +1,9 @@
+-- Events too large for a NOTIFY payload, kept briefly for the other server
+-- instances to fetch. Instances that ran before this migration created the
+-- table themselves, so it may already exist.
+CREATE TABLE IF NOT EXISTS realtime_spilled_events (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_realtime_spilled_events_created_at ON realtime_spilled_events(created_at);
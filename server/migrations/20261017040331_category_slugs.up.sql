@@ -0,0 +1,10 @@
+-- Slugs categories used before a rename, so that links using them keep working
+CREATE TABLE IF NOT EXISTS category_slugs (
+    id VARCHAR(36) PRIMARY KEY,
+    portal_id VARCHAR(36),
+    category_id VARCHAR(36),
+    slug VARCHAR(255),
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_category_slugs_portal_slug ON category_slugs(portal_id, slug);
+CREATE INDEX IF NOT EXISTS idx_category_slugs_category_id ON category_slugs(category_id);
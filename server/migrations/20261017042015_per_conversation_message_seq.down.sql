@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_messages_conversation_seq;
+ALTER TABLE conversations DROP COLUMN IF EXISTS last_seq;
+
+-- Numbers repeat across conversations, so number messages across all of them
+-- again in the order they were sent
+UPDATE messages SET seq = numbered.n
+FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM messages) AS numbered
+WHERE messages.id = numbered.id;
+SELECT setval('messages_seq_seq', COALESCE((SELECT MAX(seq) FROM messages), 0) + 1, false);
+ALTER TABLE messages ALTER COLUMN seq SET DEFAULT nextval('messages_seq_seq');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_seq ON messages(seq);
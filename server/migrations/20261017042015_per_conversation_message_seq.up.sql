@@ -0,0 +1,13 @@
+-- Messages are numbered per conversation from a counter on the conversation
+-- row. Numbering a message takes the row's lock until it is stored, so the
+-- messages of a conversation commit in seq order and a client syncing after
+-- a seq cannot miss one that commits late with a lower number.
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+UPDATE conversations SET last_seq = latest.seq
+FROM (SELECT conversation_id, MAX(seq) AS seq FROM messages GROUP BY conversation_id) AS latest
+WHERE conversations.id = latest.conversation_id;
+
+-- Existing numbers are kept, so cursors clients already hold stay valid
+ALTER TABLE messages ALTER COLUMN seq DROP DEFAULT;
+DROP INDEX IF EXISTS idx_messages_seq;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_conversation_seq ON messages(conversation_id, seq);
@@ -0,0 +1,391 @@
+// Package migrations holds the versioned database schema and the runner that
+// applies it.
+//
+// A migration is either a pair of SQL files, <version>_<name>.up.sql and
+// <version>_<name>.down.sql, or a Go file that registers its steps from an
+// init function. Versions are UTC timestamps (yyyymmddhhmmss). Migrations run
+// in version order, each in its own transaction, and the applied versions are
+// recorded in the schema_migrations table.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one step of the schema history
+type Migration struct {
+	Version string
+	Name    string
+	// Up applies the migration
+	Up func(tx *gorm.DB) error
+	// Down reverts it; nil means the migration cannot be reverted
+	Down func(tx *gorm.DB) error
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the schema_migrations table
+type schemaMigration struct {
+	Version   string    `gorm:"primaryKey;type:varchar(14)"`
+	Name      string    `gorm:"type:varchar(255)"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName overrides the table name used by schemaMigration
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// ErrPending is returned by EnsureCurrent when migrations have not been applied
+var ErrPending = errors.New("database has pending migrations")
+
+// lockKey identifies the Postgres advisory lock held while a migration runs,
+// so that several runners started at once apply each migration only once
+const lockKey = 4_517_302_118
+
+var (
+	//go:embed *.sql
+	sqlFiles embed.FS
+
+	// goMigrations are the migrations registered from Go files
+	goMigrations []Migration
+
+	sqlFileName  = regexp.MustCompile(`^(\d{14})_([a-z0-9_]+)\.(up|down)\.sql$`)
+	nameAllowed  = regexp.MustCompile(`^[a-z0-9_]+$`)
+	versionStamp = "20060102150405"
+)
+
+// register adds a Go migration. Call it from the init function of the file
+// that defines the migration.
+func register(migration Migration) {
+	goMigrations = append(goMigrations, migration)
+}
+
+// All returns every known migration in version order
+func All() ([]Migration, error) {
+	byVersion := map[string]*Migration{}
+
+	entries, err := fs.ReadDir(sqlFiles, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		parts := sqlFileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.(up|down).sql", entry.Name())
+		}
+		version, name, direction := parts[1], parts[2], parts[3]
+
+		content, err := sqlFiles.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %s is named both %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = sqlStep(string(content))
+		} else {
+			migration.Down = sqlStep(string(content))
+		}
+	}
+
+	for i := range goMigrations {
+		if _, ok := byVersion[goMigrations[i].Version]; ok {
+			return nil, fmt.Errorf("migration version %s is used more than once", goMigrations[i].Version)
+		}
+		byVersion[goMigrations[i].Version] = &goMigrations[i]
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %s has no up step", label(*migration))
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// sqlStep runs the statements of a migration file
+func sqlStep(statements string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(statements).Error
+	}
+}
+
+// Statuses returns every known migration along with when it was applied.
+// Applied versions this build does not know about are included without steps.
+func Statuses(db *gorm.DB) ([]Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		status := Status{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		appliedAt := row.AppliedAt
+		statuses = append(statuses, Status{
+			Migration: Migration{Version: row.Version, Name: row.Name},
+			AppliedAt: &appliedAt,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied, in version order
+func Pending(db *gorm.DB) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// EnsureCurrent returns an error wrapping ErrPending if any migration has not
+// been applied. It never changes the database.
+func EnsureCurrent(db *gorm.DB) error {
+	pending, err := Pending(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d to apply, starting with %s", ErrPending, len(pending), label(pending[0]))
+	}
+	return nil
+}
+
+// Up applies pending migrations in version order, at most steps of them when
+// steps is positive, and returns the migrations it applied
+func Up(db *gorm.DB, steps int) ([]Migration, error) {
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			done, err := lockVersion(tx, migration.Version)
+			if err != nil || done {
+				return err
+			}
+
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("applying %s: %w", label(migration), err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the migrations it reverted
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := statuses[i].Migration
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		if migration.Up == nil {
+			return reverted, fmt.Errorf("%s was applied but is not known to this build", label(migration))
+		}
+		if migration.Down == nil {
+			return reverted, fmt.Errorf("%s cannot be reverted", label(migration))
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			done, err := lockVersion(tx, migration.Version)
+			if err != nil || !done {
+				return err
+			}
+
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting %s: %w", label(migration), err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// Create writes the files of a new migration to dir and returns their paths.
+// A Go migration is a single file; a SQL migration is an up and a down file.
+func Create(dir, name string, goMigration bool) ([]string, error) {
+	name = strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(name)))
+	if !nameAllowed.MatchString(name) {
+		return nil, fmt.Errorf("migration name %q may only contain letters, digits and underscores", name)
+	}
+	version := time.Now().UTC().Format(versionStamp)
+	base := dir + "/" + version + "_" + name
+
+	files := map[string]string{
+		base + ".up.sql":   fmt.Sprintf("-- %s\n", name),
+		base + ".down.sql": fmt.Sprintf("-- Revert %s\n", name),
+	}
+	if goMigration {
+		files = map[string]string{
+			base + ".go": fmt.Sprintf(goTemplate, version, name),
+		}
+	}
+
+	var paths []string
+	for path, content := range files {
+		if err := writeNewFile(path, content); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// writeNewFile writes a file that must not exist yet
+func writeNewFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// goTemplate is the skeleton of a new Go migration
+const goTemplate = `package migrations
+
+import "gorm.io/gorm"
+
+func init() {
+	register(Migration{
+		Version: "%s",
+		Name:    "%s",
+		Up: func(tx *gorm.DB) error {
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`
+
+// createTable creates the schema_migrations table if it does not exist yet
+func createTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(14) PRIMARY KEY,
+		name VARCHAR(255),
+		applied_at TIMESTAMPTZ NOT NULL
+	)`).Error
+}
+
+// appliedVersions returns the rows of schema_migrations by version. A missing
+// table means nothing has been applied.
+func appliedVersions(db *gorm.DB) (map[string]schemaMigration, error) {
+	applied := map[string]schemaMigration{}
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// lockVersion takes the migration lock for the rest of tx and reports whether
+// version is applied, as another runner may have changed that in the meantime
+func lockVersion(tx *gorm.DB, version string) (applied bool, err error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&count).Error
+	return count > 0, err
+}
+
+// label names a migration in messages
+func label(migration Migration) string {
+	return migration.Version + "_" + migration.Name
+}
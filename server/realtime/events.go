@@ -0,0 +1,523 @@
+package realtime
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Event types sent from the server to clients
+const (
+	EventJoinAck    = "join_ack"
+	EventJoinError  = "join_error"
+	EventNewMessage = "new_message"
+	EventHistory    = "history"
+	EventTyping     = "typing"
+	EventPresence   = "presence"
+	EventRead       = "read"
+	EventStatus     = "status"
+	EventAssignment = "assignment"
+	// EventTokensRevoked tells a conversation that its customer tokens were
+	// revoked; customers connected with an older token are disconnected
+	EventTokensRevoked = "customer_tokens_revoked"
+)
+
+// Command types sent from clients to the server
+const (
+	CommandJoin    = "join"
+	CommandLeave   = "leave"
+	CommandMessage = "message"
+	CommandTyping  = "typing"
+	CommandRead    = "read"
+)
+
+// Command is a frame sent by a client over the WebSocket
+type Command struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversationId,omitempty"`
+	// PortalID is sent with join and leave commands for a portal's team room
+	PortalID string `json:"portalId,omitempty"`
+	Content  string `json:"content,omitempty"`
+	// Since is the last message the client has seen (message ID, sequence
+	// number or RFC 3339 timestamp); a join with Since replays what was missed
+	Since string `json:"since,omitempty"`
+	// IsTyping is sent with typing commands
+	IsTyping bool `json:"isTyping,omitempty"`
+	// MessageID is the last message read, sent with read commands
+	MessageID string `json:"messageId,omitempty"`
+	// ClientMessageID is the sender's idempotency key, sent with message commands
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+	// Sender fields are client-supplied and must not be trusted by authenticated servers
+	SenderID   string `json:"senderId,omitempty"`
+	SenderName string `json:"senderName,omitempty"`
+	IsOwner    bool   `json:"isOwner,omitempty"`
+}
+
+// Event is a frame delivered to clients subscribed to a conversation
+type Event struct {
+	Type           string    `json:"type"`
+	ConversationID string    `json:"conversationId,omitempty"`
+	Content        string    `json:"content,omitempty"`
+	SenderID       string    `json:"senderId,omitempty"`
+	SenderName     string    `json:"senderName,omitempty"`
+	IsOwner        bool      `json:"isOwner,omitempty"`
+	Data           EventData `json:"data,omitempty"`
+	// Room overrides the room the event is delivered to, which is otherwise
+	// the conversation's own room
+	Room string `json:"room,omitempty"`
+}
+
+// PortalRoom returns the room shared by a portal's team, as opposed to the
+// per-conversation rooms that customers join
+func PortalRoom(portalID string) string {
+	return "portal:" + portalID
+}
+
+// room returns the room the event is delivered to
+func (e Event) room() string {
+	if e.Room != "" {
+		return e.Room
+	}
+	return e.ConversationID
+}
+
+// EventData is the typed payload carried in an event's data field
+type EventData interface {
+	eventData()
+}
+
+// Sender identifies who sent a message
+type Sender struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Participant is someone present in a conversation
+type Participant struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsOwner bool   `json:"isOwner"`
+	// TokenVersion is the version of the customer token a customer joined
+	// with. It stays on this instance and decides who a revocation disconnects.
+	TokenVersion int `json:"-"`
+}
+
+// JoinData is the payload of join_ack and join_error events
+type JoinData struct {
+	ConversationID string `json:"conversationId,omitempty"`
+	PortalID       string `json:"portalId,omitempty"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MessageData is the payload of new_message events
+type MessageData struct {
+	ID        string    `json:"id,omitempty"`
+	Seq       int64     `json:"seq,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Sender    Sender    `json:"sender"`
+	// ClientMessageID lets the sender match the event to its optimistic copy
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+// HistoryData is the payload of history events: the messages a client missed,
+// oldest first. HasMore is set when the gap was larger than one batch.
+type HistoryData struct {
+	ConversationID string        `json:"conversationId"`
+	Messages       []ChatMessage `json:"messages"`
+	HasMore        bool          `json:"hasMore"`
+}
+
+// TypingData is the payload of typing events
+type TypingData struct {
+	ConversationID string      `json:"conversationId"`
+	Participant    Participant `json:"participant"`
+	IsTyping       bool        `json:"isTyping"`
+}
+
+// PresenceData is the payload of presence events. A change carries the
+// participant that came online or went offline; the snapshot sent after a
+// join carries everyone currently present instead.
+type PresenceData struct {
+	ConversationID string        `json:"conversationId"`
+	Participant    *Participant  `json:"participant,omitempty"`
+	Online         bool          `json:"online"`
+	Participants   []Participant `json:"participants,omitempty"`
+}
+
+// ReadData is the payload of read events
+type ReadData struct {
+	ConversationID string      `json:"conversationId"`
+	Reader         Participant `json:"reader"`
+	MessageID      string      `json:"messageId"`
+	Seq            int64       `json:"seq"`
+	ReadAt         time.Time   `json:"readAt"`
+}
+
+// StatusData is the payload of status events, sent when a conversation moves
+// through its lifecycle (open, pending, snoozed, resolved, closed)
+type StatusData struct {
+	ConversationID string     `json:"conversationId"`
+	Status         string     `json:"status"`
+	SnoozedUntil   *time.Time `json:"snoozedUntil,omitempty"`
+	ChangedAt      time.Time  `json:"changedAt"`
+}
+
+// AssignmentData is the payload of assignment events, sent to a portal's team
+// room when a conversation is assigned, reassigned or unassigned
+type AssignmentData struct {
+	ConversationID string `json:"conversationId"`
+	PortalID       string `json:"portalId"`
+	// Assignee is nil when the conversation was unassigned
+	Assignee *Participant `json:"assignee"`
+	// AssignedBy is nil when the conversation was routed automatically
+	AssignedBy *Participant `json:"assignedBy,omitempty"`
+	// Routing is how the assignee was chosen: manual, round_robin or least_busy
+	Routing    string    `json:"routing"`
+	AssignedAt time.Time `json:"assignedAt"`
+}
+
+// TokensRevokedData is the payload of customer_tokens_revoked events. Only
+// customer tokens of Version or later still work.
+type TokensRevokedData struct {
+	ConversationID string `json:"conversationId"`
+	Version        int    `json:"version"`
+}
+
+// RawData carries the payload of an event type this build does not know about
+type RawData json.RawMessage
+
+// MarshalJSON writes the payload unchanged
+func (r RawData) MarshalJSON() ([]byte, error) {
+	return json.RawMessage(r).MarshalJSON()
+}
+
+func (JoinData) eventData()          {}
+func (MessageData) eventData()       {}
+func (HistoryData) eventData()       {}
+func (TypingData) eventData()        {}
+func (PresenceData) eventData()      {}
+func (ReadData) eventData()          {}
+func (StatusData) eventData()        {}
+func (AssignmentData) eventData()    {}
+func (TokensRevokedData) eventData() {}
+func (RawData) eventData()           {}
+
+// UnmarshalJSON decodes an event, picking the payload type from the event type
+// so that events survive a round trip through a broadcast backend
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var wire struct {
+		Type           string          `json:"type"`
+		ConversationID string          `json:"conversationId"`
+		Content        string          `json:"content"`
+		SenderID       string          `json:"senderId"`
+		SenderName     string          `json:"senderName"`
+		IsOwner        bool            `json:"isOwner"`
+		Data           json.RawMessage `json:"data"`
+		Room           string          `json:"room"`
+	}
+	if err := json.Unmarshal(b, &wire); err != nil {
+		return err
+	}
+
+	*e = Event{
+		Type:           wire.Type,
+		ConversationID: wire.ConversationID,
+		Content:        wire.Content,
+		SenderID:       wire.SenderID,
+		SenderName:     wire.SenderName,
+		IsOwner:        wire.IsOwner,
+		Room:           wire.Room,
+	}
+	if len(wire.Data) == 0 || string(wire.Data) == "null" {
+		return nil
+	}
+
+	var data EventData
+	var err error
+	switch wire.Type {
+	case EventJoinAck, EventJoinError:
+		data, err = decodeData[JoinData](wire.Data)
+	case EventNewMessage:
+		data, err = decodeData[MessageData](wire.Data)
+	case EventHistory:
+		data, err = decodeData[HistoryData](wire.Data)
+	case EventTyping:
+		data, err = decodeData[TypingData](wire.Data)
+	case EventPresence:
+		data, err = decodeData[PresenceData](wire.Data)
+	case EventRead:
+		data, err = decodeData[ReadData](wire.Data)
+	case EventStatus:
+		data, err = decodeData[StatusData](wire.Data)
+	case EventAssignment:
+		data, err = decodeData[AssignmentData](wire.Data)
+	case EventTokensRevoked:
+		data, err = decodeData[TokensRevokedData](wire.Data)
+	default:
+		data = RawData(wire.Data)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.Data = data
+	return nil
+}
+
+// decodeData unmarshals an event payload into its concrete type
+func decodeData[T EventData](raw json.RawMessage) (EventData, error) {
+	var data T
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ChatMessage describes a chat message to announce to a conversation
+type ChatMessage struct {
+	ID             string    `json:"id"`
+	Seq            int64     `json:"seq"`
+	ConversationID string    `json:"conversationId"`
+	Content        string    `json:"content"`
+	Sender         Sender    `json:"sender"`
+	IsOwner        bool      `json:"isOwner"`
+	CreatedAt      time.Time `json:"createdAt"`
+	// ClientMessageID is the sender's idempotency key, if it supplied one
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+// NewJoinAck builds the acknowledgment sent after a successful join
+func NewJoinAck(conversationID string) Event {
+	return Event{
+		Type: EventJoinAck,
+		Data: JoinData{
+			ConversationID: conversationID,
+			Status:         "joined",
+		},
+	}
+}
+
+// NewPortalJoinAck builds the acknowledgment sent after joining a portal's team room
+func NewPortalJoinAck(portalID string) Event {
+	return Event{
+		Type: EventJoinAck,
+		Data: JoinData{
+			PortalID: portalID,
+			Status:   "joined",
+		},
+	}
+}
+
+// NewPortalJoinError builds the reply sent when joining a portal's team room is refused
+func NewPortalJoinError(portalID string, err error) Event {
+	return Event{
+		Type: EventJoinError,
+		Data: JoinData{
+			PortalID: portalID,
+			Status:   "refused",
+			Error:    err.Error(),
+		},
+	}
+}
+
+// NewJoinError builds the reply sent when a join is refused
+func NewJoinError(conversationID string, err error) Event {
+	return Event{
+		Type: EventJoinError,
+		Data: JoinData{
+			ConversationID: conversationID,
+			Status:         "refused",
+			Error:          err.Error(),
+		},
+	}
+}
+
+// NewMessageEvent builds the new_message event for a chat message
+func NewMessageEvent(msg ChatMessage) Event {
+	return Event{
+		Type:           EventNewMessage,
+		ConversationID: msg.ConversationID,
+		Content:        msg.Content,
+		SenderID:       msg.Sender.ID,
+		SenderName:     msg.Sender.Name,
+		IsOwner:        msg.IsOwner,
+		Data: MessageData{
+			ID:              msg.ID,
+			Seq:             msg.Seq,
+			CreatedAt:       msg.CreatedAt,
+			Sender:          msg.Sender,
+			ClientMessageID: msg.ClientMessageID,
+		},
+	}
+}
+
+// NewHistoryEvent builds the batch of missed messages replayed after a join
+func NewHistoryEvent(conversationID string, messages []ChatMessage, hasMore bool) Event {
+	if messages == nil {
+		messages = []ChatMessage{}
+	}
+
+	return Event{
+		Type:           EventHistory,
+		ConversationID: conversationID,
+		Data: HistoryData{
+			ConversationID: conversationID,
+			Messages:       messages,
+			HasMore:        hasMore,
+		},
+	}
+}
+
+// NewTypingEvent builds the event announcing that a participant started or stopped typing
+func NewTypingEvent(conversationID string, participant Participant, isTyping bool) Event {
+	return Event{
+		Type:           EventTyping,
+		ConversationID: conversationID,
+		SenderID:       participant.ID,
+		SenderName:     participant.Name,
+		IsOwner:        participant.IsOwner,
+		Data: TypingData{
+			ConversationID: conversationID,
+			Participant:    participant,
+			IsTyping:       isTyping,
+		},
+	}
+}
+
+// NewPresenceEvent builds the event announcing that a participant came online or went offline
+func NewPresenceEvent(conversationID string, participant Participant, online bool) Event {
+	return Event{
+		Type:           EventPresence,
+		ConversationID: conversationID,
+		Data: PresenceData{
+			ConversationID: conversationID,
+			Participant:    &participant,
+			Online:         online,
+		},
+	}
+}
+
+// NewPresenceSnapshot builds the list of participants sent to a client after it joins
+func NewPresenceSnapshot(conversationID string, participants []Participant) Event {
+	if participants == nil {
+		participants = []Participant{}
+	}
+
+	return Event{
+		Type:           EventPresence,
+		ConversationID: conversationID,
+		Data: PresenceData{
+			ConversationID: conversationID,
+			Online:         true,
+			Participants:   participants,
+		},
+	}
+}
+
+// NewReadEvent builds the read receipt event for a participant
+func NewReadEvent(conversationID string, reader Participant, messageID string, seq int64, readAt time.Time) Event {
+	return Event{
+		Type:           EventRead,
+		ConversationID: conversationID,
+		SenderID:       reader.ID,
+		SenderName:     reader.Name,
+		IsOwner:        reader.IsOwner,
+		Data: ReadData{
+			ConversationID: conversationID,
+			Reader:         reader,
+			MessageID:      messageID,
+			Seq:            seq,
+			ReadAt:         readAt,
+		},
+	}
+}
+
+// NewStatusEvent builds the event announcing a conversation's new status
+func NewStatusEvent(conversationID, status string, snoozedUntil *time.Time, changedAt time.Time) Event {
+	return Event{
+		Type:           EventStatus,
+		ConversationID: conversationID,
+		Data: StatusData{
+			ConversationID: conversationID,
+			Status:         status,
+			SnoozedUntil:   snoozedUntil,
+			ChangedAt:      changedAt,
+		},
+	}
+}
+
+// NewAssignmentEvent builds the event telling a portal's team who a
+// conversation is assigned to
+func NewAssignmentEvent(portalID, conversationID string, assignee, assignedBy *Participant, routing string, assignedAt time.Time) Event {
+	return Event{
+		Type:           EventAssignment,
+		ConversationID: conversationID,
+		Room:           PortalRoom(portalID),
+		Data: AssignmentData{
+			ConversationID: conversationID,
+			PortalID:       portalID,
+			Assignee:       assignee,
+			AssignedBy:     assignedBy,
+			Routing:        routing,
+			AssignedAt:     assignedAt,
+		},
+	}
+}
+
+// NewTokensRevokedEvent builds the event telling a conversation that customer
+// tokens issued before version no longer work
+func NewTokensRevokedEvent(conversationID string, version int) Event {
+	return Event{
+		Type:           EventTokensRevoked,
+		ConversationID: conversationID,
+		Data: TokensRevokedData{
+			ConversationID: conversationID,
+			Version:        version,
+		},
+	}
+}
+
+// Cursor returns the position a subscriber has reached once it has seen the
+// event: the sequence number of the newest message it carries, or "" for
+// events that carry no messages. Fallback transports use it as the event ID.
+func (e Event) Cursor() string {
+	var seq int64
+	switch data := e.Data.(type) {
+	case MessageData:
+		seq = data.Seq
+	case HistoryData:
+		if len(data.Messages) > 0 {
+			seq = data.Messages[len(data.Messages)-1].Seq
+		}
+	}
+
+	if seq == 0 {
+		return ""
+	}
+	return strconv.FormatInt(seq, 10)
+}
+
+// frame encodes the event for a client queue
+func (e Event) frame() (Frame, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{ID: e.Cursor(), Data: data}, nil
+}
+
+// messageIDs returns the IDs of the chat messages an event carries
+func (e Event) messageIDs() []string {
+	switch data := e.Data.(type) {
+	case MessageData:
+		return []string{data.ID}
+	case HistoryData:
+		ids := make([]string, 0, len(data.Messages))
+		for _, msg := range data.Messages {
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+	return nil
+}
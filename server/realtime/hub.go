@@ -0,0 +1,591 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// Publisher delivers events to everyone subscribed to the event's conversation
+type Publisher interface {
+	Publish(event Event)
+}
+
+// Backend fans events out to the other server instances. Without one a hub
+// only reaches its own clients, which is all a single-node deployment needs.
+type Backend interface {
+	// Publish forwards an event published on this instance to the others
+	Publish(event Event) error
+	// Subscribe starts delivering events published by other instances
+	Subscribe(deliver func(Event)) error
+	// Close stops the subscription
+	Close() error
+}
+
+// Handler processes a command received from a client
+type Handler func(client *Client, cmd Command)
+
+// Options tunes how a hub treats its connections
+type Options struct {
+	// SendQueueSize is how many outgoing frames may be buffered per client
+	// before the client is considered too slow and evicted
+	SendQueueSize int
+	// WriteTimeout bounds how long a single frame write may take
+	WriteTimeout time.Duration
+	// PingInterval is how often the server pings each client
+	PingInterval time.Duration
+	// PongTimeout is how long a client may stay silent (no pong or frame)
+	// before it is considered dead; it must be longer than PingInterval
+	PongTimeout time.Duration
+	// MaxMessageSize is the largest frame, in bytes, accepted from a client
+	MaxMessageSize int64
+	// Backend shares events with other instances; nil keeps fan-out in memory
+	Backend Backend
+}
+
+// DefaultOptions returns the options used when a field is left unset
+func DefaultOptions() Options {
+	return Options{
+		SendQueueSize:  64,
+		WriteTimeout:   10 * time.Second,
+		PingInterval:   30 * time.Second,
+		PongTimeout:    60 * time.Second,
+		MaxMessageSize: 64 * 1024,
+	}
+}
+
+// Frame is an encoded event queued for a client
+type Frame struct {
+	// ID is the event's resume cursor (see Event.Cursor); empty for most events
+	ID   string
+	Data []byte
+}
+
+// Client is a subscriber registered with a hub. WebSocket clients own a writer
+// goroutine; subscribers from the fallback transports read Frames themselves.
+// Either way everything sent to a client goes through a bounded queue.
+type Client struct {
+	hub      *Hub
+	conn     *websocket.Conn
+	send     chan Frame
+	done     chan struct{}
+	doneOnce sync.Once
+	closing  sync.WaitGroup
+	roomsMtx sync.RWMutex
+	// rooms maps each joined room to who the client is present as there
+	rooms map[string]Participant
+	// held buffers live events for rooms whose history is still being replayed
+	heldMtx sync.Mutex
+	held    map[string][]heldEvent
+}
+
+// heldEvent is a live event waiting for a replay to finish
+type heldEvent struct {
+	event Event
+	frame Frame
+}
+
+// Hub tracks connected clients and the conversation rooms they have joined
+type Hub struct {
+	opts      Options
+	mu        sync.RWMutex
+	clients   map[*Client]bool
+	rooms     map[string]map[*Client]bool
+	closed    bool
+	evictions atomic.Uint64
+}
+
+// NewHub creates an empty hub
+func NewHub(opts Options) *Hub {
+	defaults := DefaultOptions()
+	if opts.SendQueueSize <= 0 {
+		opts.SendQueueSize = defaults.SendQueueSize
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaults.WriteTimeout
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = defaults.PingInterval
+	}
+	if opts.PongTimeout <= opts.PingInterval {
+		opts.PongTimeout = 2 * opts.PingInterval
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = defaults.MaxMessageSize
+	}
+
+	return &Hub{
+		opts:    opts,
+		clients: make(map[*Client]bool),
+		rooms:   make(map[string]map[*Client]bool),
+	}
+}
+
+// Serve registers a connection, dispatches its commands to handler until it
+// disconnects and then removes it from every room
+func (h *Hub) Serve(conn *websocket.Conn, handler Handler) {
+	client := h.register(conn)
+	if client == nil {
+		conn.Close()
+		return
+	}
+
+	log.Println("WebSocket client connected")
+
+	// Reap peers that stop answering pings and refuse oversized frames
+	conn.SetReadLimit(h.opts.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(h.opts.PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(h.opts.PongTimeout))
+	})
+
+	// The connection must not be released while the writer still uses it
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		client.writePump()
+	}()
+
+	defer func() {
+		// Handle panics
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in WebSocket handler: %v", r)
+		}
+
+		h.unregister(client)
+		client.stop()
+		<-writerDone
+		client.closing.Wait()
+		log.Println("WebSocket client disconnected")
+	}()
+
+	// Message handling loop
+	for {
+		_, rawMessage, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("WebSocket read error: %v", err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(h.opts.PongTimeout))
+
+		var cmd Command
+		if err := json.Unmarshal(rawMessage, &cmd); err != nil {
+			log.Printf("WebSocket JSON parse error: %v", err)
+			continue
+		}
+
+		handler(client, cmd)
+	}
+}
+
+// Join adds a client to a room as the given participant. The room is told
+// when the participant comes online (its first connection in the room).
+func (h *Hub) Join(client *Client, room string, who Participant) {
+	// Add room to client's rooms
+	client.roomsMtx.Lock()
+	_, rejoined := client.rooms[room]
+	client.rooms[room] = who
+	client.roomsMtx.Unlock()
+
+	// Add client to room
+	h.mu.Lock()
+	cameOnline := !rejoined && who.ID != "" && !h.presentLocked(room, who.ID)
+	if _, exists := h.rooms[room]; !exists {
+		h.rooms[room] = make(map[*Client]bool)
+	}
+	h.rooms[room][client] = true
+	h.mu.Unlock()
+
+	if cameOnline {
+		h.Publish(NewPresenceEvent(room, who, true))
+	}
+}
+
+// Leave removes a client from a room. The room is told when the participant
+// goes offline (its last connection in the room).
+func (h *Hub) Leave(client *Client, room string) {
+	// Remove room from client's rooms
+	client.roomsMtx.Lock()
+	who, joined := client.rooms[room]
+	delete(client.rooms, room)
+	client.roomsMtx.Unlock()
+
+	// Remove client from room
+	h.mu.Lock()
+	if clients, exists := h.rooms[room]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	wentOffline := joined && who.ID != "" && !h.presentLocked(room, who.ID)
+	h.mu.Unlock()
+
+	if wentOffline {
+		h.Publish(NewPresenceEvent(room, who, false))
+	}
+}
+
+// Participants returns who is currently present in a room on this instance.
+// Other instances announce their participants through presence events.
+func (h *Hub) Participants(room string) []Participant {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	participants := make([]Participant, 0, len(h.rooms[room]))
+	for client := range h.rooms[room] {
+		who, ok := client.Participant(room)
+		if !ok || who.ID == "" || seen[who.ID] {
+			continue
+		}
+		seen[who.ID] = true
+		participants = append(participants, who)
+	}
+	return participants
+}
+
+// presentLocked reports whether any client in a room is present as the
+// participant; the caller must hold h.mu
+func (h *Hub) presentLocked(room, participantID string) bool {
+	for client := range h.rooms[room] {
+		if who, ok := client.Participant(room); ok && who.ID == participantID {
+			return true
+		}
+	}
+	return false
+}
+
+// Start connects the hub to its backend, if any, so that events published on
+// other instances reach this instance's clients
+func (h *Hub) Start() error {
+	if h.opts.Backend == nil {
+		return nil
+	}
+	return h.opts.Backend.Subscribe(h.broadcast)
+}
+
+// Publish queues an event for all clients in the event's conversation room,
+// on this instance and, through the backend, on every other instance
+func (h *Hub) Publish(event Event) {
+	h.broadcast(event)
+
+	if h.opts.Backend != nil {
+		if err := h.opts.Backend.Publish(event); err != nil {
+			log.Printf("Error forwarding event to realtime backend: %v", err)
+		}
+	}
+}
+
+// broadcast queues an event for this instance's clients in the event's room
+func (h *Hub) broadcast(event Event) {
+	frame, err := event.frame()
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	// Make a copy of clients to avoid holding the lock while sending
+	h.mu.RLock()
+	room := event.room()
+	clients := make([]*Client, 0, len(h.rooms[room]))
+	for client := range h.rooms[room] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.deliver(event, frame)
+	}
+
+	// Customers connected with a revoked token lose access straight away
+	if revoked, ok := event.Data.(TokensRevokedData); ok {
+		for _, client := range clients {
+			if who, ok := client.Participant(room); ok && !who.IsOwner && who.TokenVersion < revoked.Version {
+				client.closeWith(websocket.ClosePolicyViolation, "customer token revoked")
+			}
+		}
+	}
+}
+
+// Subscribe registers a subscriber without a WebSocket connection, for the
+// fallback transports. The client receives the room's frames on Frames until
+// it is passed to Unsubscribe or the hub drops it (Done is closed). A non-nil
+// replay is sent first, as with JoinWithReplay. It returns nil once the hub
+// is closed.
+func (h *Hub) Subscribe(room string, who Participant, replay func() []Event) *Client {
+	client := h.register(nil)
+	if client == nil {
+		return nil
+	}
+
+	if replay != nil {
+		h.JoinWithReplay(client, room, who, replay)
+	} else {
+		h.Join(client, room, who)
+	}
+	return client
+}
+
+// Unsubscribe removes a subscriber created by Subscribe
+func (h *Hub) Unsubscribe(client *Client) {
+	h.unregister(client)
+	client.stop()
+}
+
+// JoinWithReplay adds a client to a room and sends it the events returned by
+// replay before any live event published to the room from this point on.
+// Live messages that replay already covered are not sent twice.
+func (h *Hub) JoinWithReplay(client *Client, room string, who Participant, replay func() []Event) {
+	// Hold live events until the backlog has been queued
+	client.heldMtx.Lock()
+	if client.held == nil {
+		client.held = make(map[string][]heldEvent)
+	}
+	client.held[room] = []heldEvent{}
+	client.heldMtx.Unlock()
+
+	h.Join(client, room, who)
+	backlog := replay()
+
+	client.heldMtx.Lock()
+	defer client.heldMtx.Unlock()
+
+	replayed := make(map[string]bool)
+	for _, event := range backlog {
+		for _, id := range event.messageIDs() {
+			replayed[id] = true
+		}
+		client.Send(event)
+	}
+
+	for _, held := range client.held[room] {
+		if ids := held.event.messageIDs(); len(ids) == 1 && replayed[ids[0]] {
+			continue
+		}
+		client.enqueue(held.frame)
+	}
+	delete(client.held, room)
+}
+
+// Evictions returns how many clients have been dropped for falling behind
+func (h *Hub) Evictions() uint64 {
+	return h.evictions.Load()
+}
+
+// PingInterval returns how often the hub pings WebSocket clients
+func (h *Hub) PingInterval() time.Duration {
+	return h.opts.PingInterval
+}
+
+// Close sends a "going away" close frame to every client, disconnects them
+// and stops accepting new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		client.closeWith(websocket.CloseGoingAway, "server going away")
+	}
+	for _, client := range clients {
+		client.closing.Wait()
+	}
+
+	if h.opts.Backend != nil {
+		if err := h.opts.Backend.Close(); err != nil {
+			log.Printf("Error closing realtime backend: %v", err)
+		}
+	}
+}
+
+// register adds a connection to the hub, or returns nil once the hub is closed
+func (h *Hub) register(conn *websocket.Conn) *Client {
+	client := &Client{
+		hub:   h,
+		conn:  conn,
+		send:  make(chan Frame, h.opts.SendQueueSize),
+		done:  make(chan struct{}),
+		rooms: make(map[string]Participant),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.clients[client] = true
+
+	return client
+}
+
+// unregister removes a client from all of its rooms and from the hub
+func (h *Hub) unregister(client *Client) {
+	for _, room := range client.Rooms() {
+		h.Leave(client, room)
+	}
+
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+}
+
+// evict drops a client whose send queue is full
+func (h *Hub) evict(client *Client) {
+	if client.closeWith(websocket.CloseTryAgainLater, "slow consumer") {
+		count := h.evictions.Add(1)
+		log.Printf("Evicting slow WebSocket client (%d evictions so far)", count)
+	}
+}
+
+// Rooms returns the rooms the client has joined
+func (c *Client) Rooms() []string {
+	c.roomsMtx.RLock()
+	defer c.roomsMtx.RUnlock()
+
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// InRoom reports whether the client has joined a room
+func (c *Client) InRoom(room string) bool {
+	_, ok := c.Participant(room)
+	return ok
+}
+
+// Participant returns who the client is present as in a room it has joined
+func (c *Client) Participant(room string) (Participant, bool) {
+	c.roomsMtx.RLock()
+	defer c.roomsMtx.RUnlock()
+	who, ok := c.rooms[room]
+	return who, ok
+}
+
+// Send queues an event for the client
+func (c *Client) Send(event Event) {
+	frame, err := event.frame()
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	c.enqueue(frame)
+}
+
+// Frames delivers the client's queued frames to fallback transports
+func (c *Client) Frames() <-chan Frame {
+	return c.send
+}
+
+// Done is closed when the client has been dropped by the hub
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// deliver queues a live event, or holds it while the event's room is replaying
+func (c *Client) deliver(event Event, frame Frame) {
+	c.heldMtx.Lock()
+	if held, replaying := c.held[event.room()]; replaying {
+		c.held[event.room()] = append(held, heldEvent{event: event, frame: frame})
+		c.heldMtx.Unlock()
+		return
+	}
+	c.heldMtx.Unlock()
+
+	c.enqueue(frame)
+}
+
+// enqueue hands a frame to the writer without blocking, evicting the client
+// when its queue is full
+func (c *Client) enqueue(frame Frame) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- frame:
+	default:
+		c.hub.evict(c)
+	}
+}
+
+// closeWith stops the writer, sends a close frame and closes the connection.
+// It reports whether this call was the one that closed the client.
+func (c *Client) closeWith(code int, reason string) bool {
+	closed := false
+	c.doneOnce.Do(func() {
+		closed = true
+		close(c.done)
+		if c.conn == nil {
+			return
+		}
+
+		// Close frames may be written concurrently with the writer goroutine, but
+		// doing it inline would stall the broadcast that noticed a slow client
+		c.closing.Add(1)
+		go func() {
+			defer c.closing.Done()
+			deadline := time.Now().Add(c.hub.opts.WriteTimeout)
+			closeMessage := websocket.FormatCloseMessage(code, reason)
+			c.conn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+			c.conn.Close()
+		}()
+	})
+	return closed
+}
+
+// stop tells the writer goroutine to exit
+func (c *Client) stop() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump is the only goroutine that writes data frames to the connection.
+// It also pings the client so dead peers are noticed by the read deadline.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame.Data); err != nil {
+				log.Printf("Error writing message: %v", err)
+				// Unblock the read loop so the client is cleaned up
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Error writing ping: %v", err)
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Discard is a Publisher that drops every event
+var Discard Publisher = discard{}
+
+type discard struct{}
+
+func (discard) Publish(Event) {}
@@ -0,0 +1,319 @@
+package realtime_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+
+	"server/realtime"
+)
+
+// next waits for the next event queued for a subscriber
+func next(t *testing.T, client *realtime.Client) realtime.Event {
+	t.Helper()
+
+	select {
+	case frame := <-client.Frames():
+		var event realtime.Event
+		if err := json.Unmarshal(frame.Data, &event); err != nil {
+			t.Fatalf("decode frame %s: %v", frame.Data, err)
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event arrived")
+	}
+	return realtime.Event{}
+}
+
+// expectNothing fails if an event is queued for a subscriber
+func expectNothing(t *testing.T, client *realtime.Client) {
+	t.Helper()
+
+	select {
+	case frame := <-client.Frames():
+		t.Fatalf("unexpected event %s", frame.Data)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// closed reports whether the hub has dropped a subscriber
+func closed(client *realtime.Client) bool {
+	select {
+	case <-client.Done():
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func message(conversationID, id string, seq int64) realtime.ChatMessage {
+	return realtime.ChatMessage{
+		ID:             id,
+		Seq:            seq,
+		ConversationID: conversationID,
+		Content:        "Hello",
+		Sender:         realtime.Sender{ID: "customer", Name: "Grace"},
+		CreatedAt:      time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestJoinAndLeaveAnnouncePresence(t *testing.T) {
+	hub := realtime.NewHub(realtime.DefaultOptions())
+	defer hub.Close()
+
+	ada := realtime.Participant{ID: "ada", Name: "Ada", IsOwner: true}
+	grace := realtime.Participant{ID: "grace", Name: "Grace"}
+
+	watcher := hub.Subscribe("conversation", ada, nil)
+	if event := next(t, watcher); event.Type != realtime.EventPresence {
+		t.Fatalf("got %+v, want Ada's own presence", event)
+	}
+	first := hub.Subscribe("conversation", grace, nil)
+
+	event := next(t, watcher)
+	presence, ok := event.Data.(realtime.PresenceData)
+	if event.Type != realtime.EventPresence || !ok || !presence.Online || presence.Participant.ID != "grace" {
+		t.Fatalf("got %+v, want grace coming online", event)
+	}
+
+	// A second connection of someone already present changes nothing
+	second := hub.Subscribe("conversation", grace, nil)
+	expectNothing(t, watcher)
+	if got := len(hub.Participants("conversation")); got != 2 {
+		t.Fatalf("%d participants, want 2", got)
+	}
+
+	hub.Unsubscribe(first)
+	expectNothing(t, watcher)
+
+	hub.Unsubscribe(second)
+	event = next(t, watcher)
+	presence, ok = event.Data.(realtime.PresenceData)
+	if !ok || presence.Online || presence.Participant.ID != "grace" {
+		t.Fatalf("got %+v, want grace going offline", event)
+	}
+	if participants := hub.Participants("conversation"); len(participants) != 1 || participants[0] != ada {
+		t.Fatalf("participants = %+v, want only Ada", participants)
+	}
+}
+
+func TestPublishReachesOnlyTheEventRoom(t *testing.T) {
+	hub := realtime.NewHub(realtime.DefaultOptions())
+	defer hub.Close()
+
+	inRoom := hub.Subscribe("one", realtime.Participant{}, nil)
+	elsewhere := hub.Subscribe("two", realtime.Participant{}, nil)
+	team := hub.Subscribe(realtime.PortalRoom("portal"), realtime.Participant{}, nil)
+
+	hub.Publish(realtime.NewMessageEvent(message("one", "m1", 1)))
+	if event := next(t, inRoom); event.Type != realtime.EventNewMessage || event.ConversationID != "one" {
+		t.Fatalf("got %+v, want the new message", event)
+	}
+	expectNothing(t, elsewhere)
+	expectNothing(t, team)
+
+	// Assignment events go to the portal's team room, not the conversation's
+	hub.Publish(realtime.NewAssignmentEvent("portal", "one", nil, nil, "manual", time.Now()))
+	if event := next(t, team); event.Type != realtime.EventAssignment {
+		t.Fatalf("got %+v, want the assignment", event)
+	}
+	expectNothing(t, inRoom)
+}
+
+func TestSlowSubscribersAreEvicted(t *testing.T) {
+	hub := realtime.NewHub(realtime.Options{SendQueueSize: 2})
+	defer hub.Close()
+
+	slow := hub.Subscribe("conversation", realtime.Participant{}, nil)
+	fast := hub.Subscribe("conversation", realtime.Participant{}, nil)
+
+	for i := int64(1); i <= 3; i++ {
+		hub.Publish(realtime.NewMessageEvent(message("conversation", "m", i)))
+		next(t, fast)
+	}
+
+	if !closed(slow) {
+		t.Fatal("a subscriber with a full queue was kept")
+	}
+	if hub.Evictions() != 1 {
+		t.Fatalf("%d evictions, want 1", hub.Evictions())
+	}
+
+	// Nothing more is queued for an evicted subscriber
+	hub.Publish(realtime.NewMessageEvent(message("conversation", "m", 4)))
+	next(t, fast)
+	if len(slow.Frames()) != 2 {
+		t.Fatalf("%d frames queued for the evicted subscriber, want 2", len(slow.Frames()))
+	}
+}
+
+func TestRevokingTokensDisconnectsOlderCustomers(t *testing.T) {
+	hub := realtime.NewHub(realtime.DefaultOptions())
+	defer hub.Close()
+
+	revoked := hub.Subscribe("conversation", realtime.Participant{TokenVersion: 1}, nil)
+	current := hub.Subscribe("conversation", realtime.Participant{TokenVersion: 2}, nil)
+	team := hub.Subscribe("conversation", realtime.Participant{IsOwner: true}, nil)
+	elsewhere := hub.Subscribe("other", realtime.Participant{TokenVersion: 1}, nil)
+
+	hub.Publish(realtime.NewTokensRevokedEvent("conversation", 2))
+
+	if !closed(revoked) {
+		t.Fatal("a customer with a revoked token stayed connected")
+	}
+	for _, client := range []*realtime.Client{current, team, elsewhere} {
+		select {
+		case <-client.Done():
+			t.Fatal("a revocation disconnected a subscriber whose access still works")
+		default:
+		}
+	}
+	if event := next(t, team); event.Type != realtime.EventTokensRevoked {
+		t.Fatalf("got %+v, want the revocation", event)
+	}
+}
+
+func TestJoinWithReplaySendsHistoryFirst(t *testing.T) {
+	hub := realtime.NewHub(realtime.DefaultOptions())
+	defer hub.Close()
+
+	live := message("conversation", "live", 2)
+	client := hub.Subscribe("conversation", realtime.Participant{}, func() []realtime.Event {
+		// Published while the backlog is loaded, and part of it as well
+		hub.Publish(realtime.NewMessageEvent(live))
+		hub.Publish(realtime.NewMessageEvent(message("conversation", "later", 3)))
+		return []realtime.Event{realtime.NewHistoryEvent("conversation", []realtime.ChatMessage{message("conversation", "old", 1), live}, false)}
+	})
+
+	if event := next(t, client); event.Type != realtime.EventHistory || event.Cursor() != "2" {
+		t.Fatalf("got %+v, want the history up to seq 2", event)
+	}
+	if event := next(t, client); event.Cursor() != "3" {
+		t.Fatalf("got %+v, want only the message the history missed", event)
+	}
+	expectNothing(t, client)
+}
+
+func TestCloseSendsGoingAway(t *testing.T) {
+	hub := realtime.NewHub(realtime.DefaultOptions())
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		hub.Serve(c, func(client *realtime.Client, cmd realtime.Command) {
+			hub.Join(client, cmd.ConversationID, realtime.Participant{})
+			client.Send(realtime.NewJoinAck(cmd.ConversationID))
+		})
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Once the join is acknowledged the connection is registered with the hub
+	if err := conn.WriteJSON(realtime.Command{Type: realtime.CommandJoin, ConversationID: "conversation"}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	var ack realtime.Event
+	if err := conn.ReadJSON(&ack); err != nil || ack.Type != realtime.EventJoinAck {
+		t.Fatalf("got %+v (%v), want a join ack", ack, err)
+	}
+	subscriber := hub.Subscribe("conversation", realtime.Participant{}, nil)
+
+	hub.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *fastws.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != fastws.CloseGoingAway {
+		t.Fatalf("read after Close: %v, want a going away close frame", err)
+	}
+	if !closed(subscriber) {
+		t.Fatal("Close kept a subscriber")
+	}
+	if hub.Subscribe("conversation", realtime.Participant{}, nil) != nil {
+		t.Fatal("a closed hub accepted a subscriber")
+	}
+}
+
+func TestEventsSurviveJSON(t *testing.T) {
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	ada := realtime.Participant{ID: "ada", Name: "Ada", IsOwner: true}
+	grace := realtime.Participant{ID: "grace", Name: "Grace"}
+
+	events := []realtime.Event{
+		realtime.NewJoinAck("conversation"),
+		realtime.NewPortalJoinError("portal", errors.New("not a member")),
+		realtime.NewMessageEvent(message("conversation", "m1", 1)),
+		realtime.NewHistoryEvent("conversation", []realtime.ChatMessage{message("conversation", "m1", 1)}, true),
+		realtime.NewTypingEvent("conversation", grace, true),
+		realtime.NewPresenceEvent("conversation", grace, false),
+		realtime.NewPresenceSnapshot("conversation", []realtime.Participant{ada, grace}),
+		realtime.NewReadEvent("conversation", ada, "m1", 1, at),
+		realtime.NewStatusEvent("conversation", "snoozed", &at, at),
+		realtime.NewAssignmentEvent("portal", "conversation", &ada, nil, "round_robin", at),
+		realtime.NewTokensRevokedEvent("conversation", 3),
+		{Type: "from_a_newer_build", ConversationID: "conversation", Data: realtime.RawData(`{"some":"payload"}`)},
+	}
+
+	for _, want := range events {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("encode %s: %v", want.Type, err)
+		}
+		var got realtime.Event
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("decode %s: %v", data, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip of %s:\n got  %#v\n want %#v", want.Type, got, want)
+		}
+	}
+}
+
+func TestEventCursor(t *testing.T) {
+	history := realtime.NewHistoryEvent("conversation", []realtime.ChatMessage{message("conversation", "m1", 4), message("conversation", "m2", 7)}, false)
+
+	for _, tc := range []struct {
+		event realtime.Event
+		want  string
+	}{
+		{realtime.NewMessageEvent(message("conversation", "m1", 12)), "12"},
+		{history, "7"},
+		{realtime.NewHistoryEvent("conversation", nil, false), ""},
+		{realtime.NewTypingEvent("conversation", realtime.Participant{ID: "grace"}, true), ""},
+	} {
+		if got := tc.event.Cursor(); got != tc.want {
+			t.Errorf("cursor of %s = %q, want %q", tc.event.Type, got, tc.want)
+		}
+	}
+
+	data, _ := json.Marshal(history)
+	if !strings.Contains(string(data), `"hasMore":false`) {
+		t.Errorf("history event %s has no hasMore field", data)
+	}
+}
+
+func TestPingIntervalFallsBackToTheDefault(t *testing.T) {
+	if got := realtime.NewHub(realtime.Options{PingInterval: 5 * time.Second}).PingInterval(); got != 5*time.Second {
+		t.Errorf("PingInterval() = %v, want 5s", got)
+	}
+	if got, want := realtime.NewHub(realtime.Options{}).PingInterval(), realtime.DefaultOptions().PingInterval; got != want {
+		t.Errorf("PingInterval() without a setting = %v, want %v", got, want)
+	}
+}
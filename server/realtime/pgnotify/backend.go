@@ -0,0 +1,199 @@
+// Package pgnotify shares real-time events between server instances through
+// PostgreSQL LISTEN/NOTIFY.
+package pgnotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+
+	"server/realtime"
+)
+
+// maxPayload keeps notifications under PostgreSQL's 8000 byte limit;
+// larger events are stored in a table and the notification carries their ID
+const maxPayload = 7900
+
+// spillPrefix marks a notification that refers to a stored event
+const spillPrefix = "@"
+
+// spillRetention is how long stored events are kept for listeners to fetch
+const spillRetention = time.Minute
+
+// envelope wraps an event with the instance that published it
+type envelope struct {
+	Origin string         `json:"o"`
+	Event  realtime.Event `json:"e"`
+}
+
+// spilledEvent is an event too large to travel in a notification. Its table
+// is created by the realtime_spilled_events migration.
+type spilledEvent struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	Payload   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName sets the table used for oversized events
+func (spilledEvent) TableName() string {
+	return "realtime_spilled_events"
+}
+
+// Backend is a realtime.Backend that fans events out over a NOTIFY channel
+type Backend struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+	origin  string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a backend that publishes through db and listens on its own
+// connection opened from dsn
+func New(db *gorm.DB, dsn, channel string) *Backend {
+	return &Backend{
+		db:      db,
+		dsn:     dsn,
+		channel: channel,
+		origin:  uuid.New().String(),
+	}
+}
+
+// Publish notifies the other instances of an event
+func (b *Backend) Publish(event realtime.Event) error {
+	payload, err := json.Marshal(envelope{Origin: b.origin, Event: event})
+	if err != nil {
+		return err
+	}
+
+	notification := string(payload)
+	if len(payload) > maxPayload {
+		spilled := spilledEvent{Payload: notification}
+		if err := b.db.Create(&spilled).Error; err != nil {
+			return fmt.Errorf("failed to store oversized event: %w", err)
+		}
+		b.db.Where("created_at < ?", time.Now().Add(-spillRetention)).Delete(&spilledEvent{})
+		notification = spillPrefix + strconv.FormatInt(spilled.ID, 10)
+	}
+
+	return b.db.Exec("SELECT pg_notify(?, ?)", b.channel, notification).Error
+}
+
+// Subscribe listens for events from other instances. The first connection is
+// made before returning; afterwards the listener reconnects on its own.
+func (b *Backend) Subscribe(deliver func(realtime.Event)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := b.listen(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	b.cancel = cancel
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run(ctx, conn, deliver)
+	}()
+
+	log.Printf("Realtime backend listening on channel %s", b.channel)
+	return nil
+}
+
+// Close stops listening
+func (b *Backend) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+	return nil
+}
+
+// listen opens a dedicated connection subscribed to the channel
+func (b *Backend) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect realtime listener: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to listen on %s: %w", b.channel, err)
+	}
+
+	return conn, nil
+}
+
+// run delivers notifications until ctx is cancelled, reconnecting with
+// backoff when the listener connection drops. Events sent while disconnected
+// are lost; clients recover them by rejoining with a since cursor.
+func (b *Backend) run(ctx context.Context, conn *pgx.Conn, deliver func(realtime.Event)) {
+	backoff := time.Second
+	for {
+		if conn != nil {
+			for {
+				notification, err := conn.WaitForNotification(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Realtime listener error: %v", err)
+					}
+					break
+				}
+				backoff = time.Second
+				b.handle(notification.Payload, deliver)
+			}
+			conn.Close(context.Background())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+
+		var err error
+		if conn, err = b.listen(ctx); err != nil {
+			log.Printf("Realtime listener reconnect failed: %v", err)
+			conn = nil
+		}
+	}
+}
+
+// handle decodes a notification and delivers events from other instances
+func (b *Backend) handle(payload string, deliver func(realtime.Event)) {
+	if strings.HasPrefix(payload, spillPrefix) {
+		var spilled spilledEvent
+		if err := b.db.Where("id = ?", strings.TrimPrefix(payload, spillPrefix)).First(&spilled).Error; err != nil {
+			log.Printf("Error loading oversized realtime event: %v", err)
+			return
+		}
+		payload = spilled.Payload
+	}
+
+	var env envelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		log.Printf("Error decoding realtime event: %v", err)
+		return
+	}
+
+	// Events from this instance were already delivered locally
+	if env.Origin == b.origin {
+		return
+	}
+
+	deliver(env.Event)
+}
@@ -4,15 +4,34 @@ import (
 	"github.com/gofiber/fiber/v2"
 
 	"server/handlers"
+	"server/middleware"
+	"server/store"
 )
 
 // setupAuthRoutes configures authentication routes
-func setupAuthRoutes(api fiber.Router) {
+func setupAuthRoutes(api fiber.Router, h *handlers.Handler, users store.UserStore) {
 	auth := api.Group("/auth")
 
 	// Register a new user
-	auth.Post("/register", handlers.Register)
+	auth.Post("/register", h.Register)
 
 	// Login
-	auth.Post("/login", handlers.Login)
+	auth.Post("/login", h.Login)
+
+	// Exchange a refresh token for new tokens
+	auth.Post("/refresh", h.Refresh)
+
+	// End the session of a refresh token
+	auth.Post("/logout", h.Logout)
+
+	// End every session of the authenticated user
+	auth.Post("/logout-all", middleware.Protected(users), h.LogoutAll)
+
+	// Mail a password reset link, and set a new password with it
+	auth.Post("/forgot-password", h.ForgotPassword)
+	auth.Post("/reset-password", h.ResetPassword)
+
+	// Verify an email address, and mail a new verification link
+	auth.Post("/verify-email", h.VerifyEmail)
+	auth.Post("/resend-verification", middleware.Protected(users), h.ResendVerification)
 }
\ No newline at end of file
@@ -6,35 +6,57 @@ import (
 
 	"server/handlers"
 	"server/middleware"
+	"server/store"
 )
 
 // setupConversationRoutes configures conversation routes
-func setupConversationRoutes(api fiber.Router) {
+func setupConversationRoutes(api fiber.Router, h *handlers.Handler, users store.UserStore) {
 	conversations := api.Group("/conversations")
 
 	// Protected routes (require authentication)
-	conversations.Use(middleware.Protected())
+	conversations.Use(middleware.Protected(users))
 
 	// Get conversation by ID
-	conversations.Get("/:id", handlers.GetConversation)
+	conversations.Get("/:id", h.GetConversation)
 
 	// Delete conversation
-	conversations.Delete("/:id", handlers.DeleteConversation)
+	conversations.Delete("/:id", h.DeleteConversation)
 
 	// Get messages for a conversation (authenticated)
-	conversations.Get("/:id/messages", handlers.GetConversationMessages)
+	conversations.Get("/:id/messages", h.GetConversationMessages)
 
-	// Public routes (don't require authentication)
-	api.Get("/conversation/code/:uniqueCode", handlers.GetConversationByCode)
-	api.Get("/conversation/find/:portalName/:categorySlug/:uniqueCode", handlers.GetConversationByURLParams)
+	// Mark a conversation as read by the owner
+	conversations.Post("/:id/read", h.MarkConversationRead)
+
+	// Change conversation status (open, pending, snoozed, resolved, closed)
+	conversations.Put("/:id/status", h.UpdateConversationStatus)
+	conversations.Post("/:id/resolve", h.ResolveConversation)
+	conversations.Post("/:id/reopen", h.ReopenConversation)
+
+	// Assign, reassign or unassign a conversation
+	conversations.Put("/:id/assignee", h.AssignConversation)
+	conversations.Delete("/:id/assignee", h.UnassignConversation)
+
+	// Revoke the customer's tokens and issue a new one
+	conversations.Post("/:id/customer-token/revoke", h.RevokeCustomerTokens)
+
+	// Revoke the conversation's link, so that nobody new can open it
+	conversations.Post("/:id/link/revoke", h.RevokeConversationLink)
+
+	// Public routes (don't require authentication). Apart from looking up a
+	// conversation nobody has claimed yet, and claiming it, they need the
+	// customer token in the X-Customer-Token header. Lookups without a token
+	// count as uses of the conversation's link and fail once it is dead.
+	api.Get("/conversation/code/:uniqueCode", h.GetConversationByCode)
+	api.Get("/conversation/find/:portalName/:categorySlug/:uniqueCode", h.GetConversationByURLParams)
 	
 	// New endpoint to handle category access and generate a new conversation
-	api.Get("/conversation/category/:portalName/:categorySlug", handlers.HandleCategoryAccess)
+	api.Get("/conversation/category/:portalName/:categorySlug", h.HandleCategoryAccess)
 	
-	api.Put("/conversation/:id/update-customer", handlers.UpdateCustomerInfo)
-	api.Post("/conversation/create", handlers.CreateConversation)
-	api.Get("/conversation/public/:id", handlers.GetPublicConversation)
+	api.Put("/conversation/:id/update-customer", h.UpdateCustomerInfo)
+	api.Post("/conversation/create", h.CreateConversation)
+	api.Get("/conversation/public/:id", h.GetPublicConversation)
 	
 	// Public endpoint to get messages for a conversation
-	api.Get("/conversation/public/:id/messages", handlers.GetPublicConversationMessages)
+	api.Get("/conversation/public/:id/messages", h.GetPublicConversationMessages)
 }
\ No newline at end of file
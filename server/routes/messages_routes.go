@@ -7,7 +7,7 @@ import (
 )
 
 // setupMessageRoutes configures message routes
-func setupMessageRoutes(api fiber.Router) {
+func setupMessageRoutes(api fiber.Router, h *handlers.Handler) {
 	// Send a message (can be from customer or owner)
-	api.Post("/messages", handlers.SendMessage)
+	api.Post("/messages", h.SendMessage)
 }
\ No newline at end of file
@@ -5,51 +5,74 @@ import (
 
 	"server/handlers"
 	"server/middleware"
+	"server/store"
 )
 
 // setupPortalRoutes configures portal routes
-func setupPortalRoutes(api fiber.Router) {
+func setupPortalRoutes(api fiber.Router, h *handlers.Handler, users store.UserStore) {
 	portals := api.Group("/portals")
 
 	// Protected routes (require authentication)
-	portals.Use(middleware.Protected())
+	portals.Use(middleware.Protected(users))
 
 	// Get all portals owned by authenticated user
-	portals.Get("/", handlers.GetPortals)
+	portals.Get("/", h.GetPortals)
 
 	// Get portal by ID
-	portals.Get("/:id", handlers.GetPortalByID)
+	portals.Get("/:id", h.GetPortalByID)
 
 	// Create a new portal
-	portals.Post("/", handlers.CreatePortal)
+	portals.Post("/", h.CreatePortal)
 	
 	// Update a portal
-	portals.Put("/:id", handlers.UpdatePortal)
+	portals.Put("/:id", h.UpdatePortal)
+
+	// Make a portal the current one for "current" portal IDs
+	portals.Put("/:id/select", h.SelectPortal)
 
 	// Get all conversations for a portal
-	portals.Get("/:id/conversations", handlers.GetPortalConversations)
+	portals.Get("/:id/conversations", h.GetPortalConversations)
 
 	// Get active conversations for a portal
-	portals.Get("/:id/active-conversations", handlers.GetPortalActiveConversations)
+	portals.Get("/:id/active-conversations", h.GetPortalActiveConversations)
 	
 	// Get all categories for a portal
-	portals.Get("/:id/categories", handlers.GetPortalCategories)
+	portals.Get("/:id/categories", h.GetPortalCategories)
 	
 	// Add a new category to a portal
-	portals.Post("/:id/categories", handlers.AddCategory)
+	portals.Post("/:id/categories", h.AddCategory)
+
+	// Get a category (by ID or slug)
+	portals.Get("/:id/categories/:categoryId", h.GetCategory)
+
+	// Update a category
+	portals.Put("/:id/categories/:categoryId", h.UpdateCategory)
 	
 	// Delete a category
-	portals.Delete("/:id/categories/:categorySlug", handlers.DeleteCategory)
+	portals.Delete("/:id/categories/:categoryId", h.DeleteCategory)
+
+	// Team members (owner, admin, agent, viewer)
+	portals.Get("/:id/members", h.GetPortalMembers)
+	portals.Put("/:id/members/:memberId", h.UpdatePortalMember)
+	portals.Delete("/:id/members/:memberId", h.RemovePortalMember)
+
+	// Team invitations
+	portals.Get("/:id/invitations", h.GetPortalInvitations)
+	portals.Post("/:id/invitations", h.InvitePortalMember)
+	portals.Delete("/:id/invitations/:invitationId", h.RevokePortalInvitation)
+
+	// Accept an invitation as the authenticated user
+	api.Post("/invitations/accept", middleware.Protected(users), h.AcceptInvitation)
 
 	// Public routes (don't require authentication)
 	portalPublic := api.Group("/portal")
 
 	// Get public portal info by ID
-	portalPublic.Get("/:id", handlers.GetPublicPortalByID)
+	portalPublic.Get("/:id", h.GetPublicPortalByID)
 	
 	// Get public portal info by custom name
-	portalPublic.Get("/by-name/:customName", handlers.GetPortalByCustomName)
+	portalPublic.Get("/by-name/:customName", h.GetPortalByCustomName)
 
 	// Generate conversation link
-	portals.Post("/:id/generate-link", handlers.GenerateConversationLink)
+	portals.Post("/:id/generate-link", h.GenerateConversationLink)
 }
\ No newline at end of file
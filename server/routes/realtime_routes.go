@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+
+	"server/handlers"
+	"server/middleware"
+	"server/realtime"
+	"server/store"
+)
+
+// setupRealtimeRoutes configures the WebSocket endpoint and its fallback transports
+func setupRealtimeRoutes(app *fiber.App, api fiber.Router, hub *realtime.Hub, h *handlers.Handler, users store.UserStore) {
+	// WebSocket upgrade middleware (authenticates the handshake)
+	app.Use("/ws", middleware.WebSocketAuth(users))
+
+	// WebSocket route
+	app.Get("/ws", websocket.New(h.WebSocket(hub)))
+
+	// Server-Sent Events stream for networks that block WebSocket upgrades
+	api.Get("/conversation/public/:id/events", middleware.RealtimeAuth(users), h.ConversationEvents(hub))
+
+	// Long-polling fallback
+	api.Get("/conversation/public/:id/poll", middleware.RealtimeAuth(users), h.PollConversationEvents(hub))
+}
@@ -2,24 +2,32 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+
+	"server/handlers"
+	"server/realtime"
+	"server/store"
 )
 
-// SetupRoutes configures all application routes
-func SetupRoutes(app *fiber.App) {
+// SetupRoutes configures all application routes, served by h. Protected routes
+// authenticate support users against users.
+func SetupRoutes(app *fiber.App, hub *realtime.Hub, h *handlers.Handler, users store.UserStore) {
 	// API routes group
 	api := app.Group("/api")
 
+	// Real-time routes (WebSocket, SSE and long polling)
+	setupRealtimeRoutes(app, api, hub, h, users)
+
 	// Auth routes
-	setupAuthRoutes(api)
+	setupAuthRoutes(api, h, users)
 
 	// Portal routes
-	setupPortalRoutes(api)
+	setupPortalRoutes(api, h, users)
 
 	// Conversation routes
-	setupConversationRoutes(api)
+	setupConversationRoutes(api, h, users)
 
 	// Message routes
-	setupMessageRoutes(api)
+	setupMessageRoutes(api, h)
 
 	// Health check route
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -0,0 +1,136 @@
+// Package slug builds the URL-friendly names used in portal and category links.
+//
+// Latin letters lose their diacritics and Cyrillic and Greek are transliterated,
+// so "Café Привет" becomes "cafe-privet". Other scripts (Devanagari, CJK,
+// Arabic, ...) have no single accepted transliteration and are kept as
+// Unicode letters; clients percent-encode them in URLs and FromPath decodes
+// them again.
+package slug
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fallback is the slug of a name that has nothing left to put in a URL
+const Fallback = "untitled"
+
+// transliterations maps lower-case runes that do not decompose into a Latin
+// letter plus marks to their Latin spelling
+var transliterations = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ł': "l", 'ı': "i", 'ħ': "h", 'ŋ': "ng",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u", 'ј': "j", 'љ': "lj",
+	'њ': "nj", 'ћ': "c", 'џ': "dz", 'ђ': "dj", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Make converts a name to a slug. Spaces and underscores become hyphens and
+// other ASCII punctuation is dropped. Compatibility characters are folded
+// first (NFKC), so the ligature in "ﬁle" gives the same slug as "file".
+func Make(input string) string {
+	var sb strings.Builder
+	pendingHyphen := false
+	afterLatin := false
+
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		if pendingHyphen && sb.Len() > 0 {
+			sb.WriteByte('-')
+		}
+		pendingHyphen = false
+		sb.WriteString(s)
+	}
+
+	var add func(r rune)
+	add = func(r rune) {
+		if latin, ok := transliterations[r]; ok {
+			write(latin)
+			afterLatin = latin != ""
+			return
+		}
+
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			write(string(r))
+			afterLatin = true
+		case r == ' ' || r == '-' || r == '_':
+			pendingHyphen = true
+			afterLatin = false
+		case r < unicode.MaxASCII:
+			// Other ASCII punctuation is dropped without a separator
+		case unicode.Is(unicode.Mn, r) && afterLatin:
+			// An accent on a Latin letter
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			// Decompose accented letters that have a Latin spelling so
+			// that the accent can be dropped
+			if decomposed := []rune(norm.NFD.String(string(r))); len(decomposed) > 1 && hasLatinSpelling(decomposed[0]) {
+				for _, part := range decomposed {
+					add(part)
+				}
+				return
+			}
+			write(string(r))
+			afterLatin = false
+		default:
+			// Unicode spaces and punctuation separate words
+			pendingHyphen = true
+			afterLatin = false
+		}
+	}
+
+	for _, r := range norm.NFC.String(strings.ToLower(norm.NFKC.String(input))) {
+		add(r)
+	}
+
+	result := norm.NFC.String(sb.String())
+	if result == "" {
+		return Fallback
+	}
+	return result
+}
+
+// hasLatinSpelling reports whether Make writes r in Latin letters
+func hasLatinSpelling(r rune) bool {
+	_, ok := transliterations[r]
+	return ok || (r >= 'a' && r <= 'z')
+}
+
+// Unique returns base, or base with the first of the suffixes -2, -3, ... for
+// which taken reports false. The same names in the same scope therefore
+// always get the same slugs.
+func Unique(base string, taken func(slug string) bool) string {
+	candidate := base
+	for n := 2; taken(candidate); n++ {
+		candidate = base + "-" + strconv.Itoa(n)
+	}
+	return candidate
+}
+
+// FromPath normalizes a slug taken from a request path, which may still be
+// percent-encoded and may use a different Unicode normalization form
+func FromPath(raw string) string {
+	if decoded, err := url.PathUnescape(raw); err == nil {
+		raw = decoded
+	}
+	return norm.NFC.String(strings.ToLower(norm.NFKC.String(raw)))
+}
@@ -0,0 +1,93 @@
+package slug_test
+
+import (
+	"testing"
+
+	"server/slug"
+)
+
+func TestMake(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		// ASCII
+		{"Billing Questions", "billing-questions"},
+		{"  Tech -- Support!  ", "tech-support"},
+		{"snake_case_name", "snake-case-name"},
+		{"C++ & Go", "c-go"},
+		{"", slug.Fallback},
+		{"!!!", slug.Fallback},
+
+		// Latin transliteration
+		{"Café Crème", "cafe-creme"},
+		{"Straße", "strasse"},
+		{"Łódź", "lodz"},
+		{"Ærøskøbing", "aeroskobing"},
+		{"ﬁle", "file"},
+		{"Ｆｕｌｌ Ｗｉｄｔｈ", "full-width"},
+
+		// Cyrillic and Greek
+		{"Привет мир", "privet-mir"},
+		{"Ελληνικά", "ellinika"},
+
+		// Scripts kept as Unicode
+		{"नमस्ते दुनिया", "नमस्ते-दुनिया"},
+		{"中文 支持", "中文-支持"},
+		{"日本語、テスト", "日本語-テスト"},
+	} {
+		if got := slug.Make(tc.in); got != tc.want {
+			t.Errorf("Make(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMakeIgnoresNormalizationForm(t *testing.T) {
+	composed := "café"
+	decomposed := "cafe\u0301"
+	if slug.Make(composed) != slug.Make(decomposed) {
+		t.Errorf("Make(%q) = %q but Make(%q) = %q", composed, slug.Make(composed), decomposed, slug.Make(decomposed))
+	}
+}
+
+func TestUnique(t *testing.T) {
+	taken := map[string]bool{}
+	var got []string
+	for i := 0; i < 3; i++ {
+		s := slug.Unique("billing", func(s string) bool { return taken[s] })
+		taken[s] = true
+		got = append(got, s)
+	}
+	want := []string{"billing", "billing-2", "billing-3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Unique gave %v, want %v", got, want)
+		}
+	}
+
+	// A freed slug is handed out again before the next suffix
+	delete(taken, "billing-2")
+	if s := slug.Unique("billing", func(s string) bool { return taken[s] }); s != "billing-2" {
+		t.Errorf("Unique after freeing billing-2 = %q", s)
+	}
+}
+
+func TestFromPath(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"billing", "billing"},
+		{"Billing", "billing"},
+		{"%E0%A4%A8%E0%A4%AE%E0%A4%B8%E0%A5%8D%E0%A4%A4%E0%A5%87", "नमस्ते"},
+		{"%E4%B8%AD%E6%96%87", "中文"},
+		{"café", "café"},
+		{"%ZZ", "%zz"},
+	} {
+		if got := slug.FromPath(tc.in); got != tc.want {
+			t.Errorf("FromPath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	// A slug survives the trip through a URL
+	for _, name := range []string{"नमस्ते दुनिया", "中文 支持", "Café"} {
+		made := slug.Make(name)
+		if got := slug.FromPath(made); got != made {
+			t.Errorf("FromPath(Make(%q)) = %q, want %q", name, got, made)
+		}
+	}
+}
@@ -0,0 +1,1415 @@
+// Package memory implements the stores with maps guarded by a mutex. It is
+// meant for tests and local experiments; nothing survives a restart. Strings
+// are kept as given, so a Fiber app serving from these stores must run with
+// Immutable set.
+package memory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"server/database/models"
+	"server/store"
+)
+
+// state is the data shared by the stores returned from one call to New
+type state struct {
+	mu sync.RWMutex
+
+	users         map[string]models.User
+	portals       map[string]models.Portal
+	oldSlugs      map[string]string                 // old custom name -> portal ID
+	members       map[[2]string]models.PortalMember // by portal ID and user ID
+	invitations   map[string]models.PortalInvitation
+	categories    map[string]models.Category
+	categorySlugs map[string]string    // slug -> name it was first made from
+	oldCategories map[[2]string]string // by portal ID and old slug -> category ID
+	conversations map[string]models.Conversation
+	messages      map[string][]models.Message      // by conversation ID
+	receipts      map[[2]string]models.ReadReceipt // by conversation ID and participant ID
+	refreshTokens map[string]models.RefreshToken
+	userTokens    map[string]models.UserToken
+}
+
+// New returns empty stores that share their data
+func New() store.Stores {
+	s := &state{
+		users:         map[string]models.User{},
+		portals:       map[string]models.Portal{},
+		oldSlugs:      map[string]string{},
+		members:       map[[2]string]models.PortalMember{},
+		invitations:   map[string]models.PortalInvitation{},
+		categories:    map[string]models.Category{},
+		categorySlugs: map[string]string{},
+		oldCategories: map[[2]string]string{},
+		conversations: map[string]models.Conversation{},
+		messages:      map[string][]models.Message{},
+		receipts:      map[[2]string]models.ReadReceipt{},
+		refreshTokens: map[string]models.RefreshToken{},
+		userTokens:    map[string]models.UserToken{},
+	}
+	return store.Stores{
+		Users:         &Users{s},
+		Portals:       &Portals{s},
+		Members:       &Members{s},
+		Invitations:   &Invitations{s},
+		Categories:    &Categories{s},
+		Conversations: &Conversations{s},
+		Messages:      &Messages{s},
+		ReadReceipts:  &ReadReceipts{s},
+		RefreshTokens: &RefreshTokens{s},
+		UserTokens:    &UserTokens{s},
+	}
+}
+
+// stamp fills in the timestamps the database would set
+func stamp(createdAt, updatedAt *time.Time) {
+	now := time.Now()
+	if createdAt != nil && createdAt.IsZero() {
+		*createdAt = now
+	}
+	if updatedAt != nil {
+		*updatedAt = now
+	}
+}
+
+// Users is a store.UserStore
+type Users struct {
+	*state
+}
+
+// GetByID returns the user with the given ID
+func (s *Users) GetByID(id string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, store.ErrNotFound
+	}
+	return user, nil
+}
+
+// GetByEmail returns the user with the given email
+func (s *Users) GetByEmail(email string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, store.ErrNotFound
+}
+
+// Create stores a new user
+func (s *Users) Create(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := user.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&user.CreatedAt, &user.UpdatedAt)
+	s.users[user.ID] = *user
+	return nil
+}
+
+// SelectPortal stores the portal the user's dashboard works on
+func (s *Users) SelectPortal(userID, portalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.SelectedPortalID = &portalID
+	s.users[userID] = user
+	return nil
+}
+
+// UpdatePassword stores a new password hash for the user
+func (s *Users) UpdatePassword(userID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.Password = passwordHash
+	stamp(nil, &user.UpdatedAt)
+	s.users[userID] = user
+	return nil
+}
+
+// MarkEmailVerified records when the user verified their email
+func (s *Users) MarkEmailVerified(userID string, verifiedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	stamp(nil, &user.UpdatedAt)
+	s.users[userID] = user
+	return nil
+}
+
+// RevokeTokens bumps the user's token version
+func (s *Users) RevokeTokens(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	user.TokenVersion++
+	s.users[userID] = user
+	return user.TokenVersion, nil
+}
+
+// Portals is a store.PortalStore
+type Portals struct {
+	*state
+}
+
+// GetByID returns the portal with the given ID
+func (s *Portals) GetByID(id string) (models.Portal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	portal, ok := s.portals[id]
+	if !ok {
+		return models.Portal{}, store.ErrNotFound
+	}
+	return portal, nil
+}
+
+// GetByCustomName returns the portal with the given custom name
+func (s *Portals) GetByCustomName(customName string) (models.Portal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, portal := range s.portals {
+		if portal.CustomName == customName {
+			return portal, nil
+		}
+	}
+	return models.Portal{}, store.ErrNotFound
+}
+
+// GetByOldCustomName finds a portal by a custom name it used before a rename
+func (s *Portals) GetByOldCustomName(customName string) (models.Portal, error) {
+	s.mu.RLock()
+	portalID, ok := s.oldSlugs[customName]
+	s.mu.RUnlock()
+
+	if !ok {
+		return models.Portal{}, store.ErrNotFound
+	}
+	return s.GetByID(portalID)
+}
+
+// ListForUser returns the portals the user owns or is a member of
+func (s *Portals) ListForUser(userID string) ([]models.Portal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	portals := []models.Portal{}
+	for _, portal := range s.portals {
+		if _, member := s.members[[2]string{portal.ID, userID}]; member || portal.OwnerID == userID {
+			portals = append(portals, portal)
+		}
+	}
+	sort.Slice(portals, func(i, j int) bool {
+		return portals[i].CreatedAt.Before(portals[j].CreatedAt)
+	})
+	return portals, nil
+}
+
+// CountOwnedBy returns how many portals the user owns
+func (s *Portals) CountOwnedBy(userID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, portal := range s.portals {
+		if portal.OwnerID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// NameTaken reports whether a portal already has the given name
+func (s *Portals) NameTaken(name string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, portal := range s.portals {
+		if portal.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CustomNameTaken reports whether a portal other than exceptPortalID uses
+// customName, either currently or as a name kept for redirects
+func (s *Portals) CustomNameTaken(customName, exceptPortalID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, portal := range s.portals {
+		if portal.CustomName == customName && portal.ID != exceptPortalID {
+			return true, nil
+		}
+	}
+	portalID, ok := s.oldSlugs[customName]
+	return ok && portalID != exceptPortalID, nil
+}
+
+// Create stores a portal along with its owner's membership. A user's first
+// portal becomes their selected portal.
+func (s *Portals) Create(portal *models.Portal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.portals {
+		if existing.Name == portal.Name || existing.CustomName == portal.CustomName {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := portal.BeforeCreate(nil); err != nil {
+		return err
+	}
+	if portal.RoutingMode == "" {
+		portal.RoutingMode = models.RoutingManual
+	}
+	stamp(&portal.CreatedAt, &portal.UpdatedAt)
+	s.portals[portal.ID] = *portal
+
+	member := models.PortalMember{PortalID: portal.ID, UserID: portal.OwnerID, Role: models.RoleOwner}
+	if err := member.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&member.CreatedAt, &member.UpdatedAt)
+	s.members[[2]string{portal.ID, portal.OwnerID}] = member
+
+	if user, ok := s.users[portal.OwnerID]; ok && user.SelectedPortalID == nil {
+		user.SelectedPortalID = &portal.ID
+		s.users[user.ID] = user
+	}
+	return nil
+}
+
+// Update stores a portal's settings
+func (s *Portals) Update(portal *models.Portal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.portals[portal.ID]; !ok {
+		return store.ErrNotFound
+	}
+	stamp(nil, &portal.UpdatedAt)
+	s.portals[portal.ID] = *portal
+	return nil
+}
+
+// Rename stores a portal under its new name and custom name, keeping the old
+// custom name so that links using it redirect
+func (s *Portals) Rename(portal *models.Portal, oldCustomName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.portals[portal.ID]; !ok {
+		return store.ErrNotFound
+	}
+	if portal.CustomName != oldCustomName {
+		if s.oldSlugs[portal.CustomName] == portal.ID {
+			delete(s.oldSlugs, portal.CustomName)
+		}
+		if oldCustomName != "" {
+			s.oldSlugs[oldCustomName] = portal.ID
+		}
+	}
+	stamp(nil, &portal.UpdatedAt)
+	s.portals[portal.ID] = *portal
+	return nil
+}
+
+// MemberRole returns the user's role on the portal's team
+func (s *Portals) MemberRole(portalID, userID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	member, ok := s.members[[2]string{portalID, userID}]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return member.Role, nil
+}
+
+// Members is a store.MemberStore
+type Members struct {
+	*state
+}
+
+// ListByPortal returns a portal's members with their users loaded
+func (s *Members) ListByPortal(portalID string) ([]models.PortalMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := []models.PortalMember{}
+	for _, member := range s.members {
+		if member.PortalID == portalID {
+			member.User = s.users[member.UserID]
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].CreatedAt.Before(members[j].CreatedAt)
+	})
+	return members, nil
+}
+
+// GetByID returns the portal's member with the given ID
+func (s *Members) GetByID(portalID, id string) (models.PortalMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, member := range s.members {
+		if member.ID == id && member.PortalID == portalID {
+			return member, nil
+		}
+	}
+	return models.PortalMember{}, store.ErrNotFound
+}
+
+// UpdateRole changes a member's role
+func (s *Members) UpdateRole(id, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, member := range s.members {
+		if member.ID == id {
+			member.Role = role
+			stamp(nil, &member.UpdatedAt)
+			s.members[key] = member
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+// Delete removes a member from their portal's team
+func (s *Members) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, member := range s.members {
+		if member.ID == id {
+			delete(s.members, key)
+		}
+	}
+	return nil
+}
+
+// HasEmail reports whether the user with the given email is on the portal's team
+func (s *Members) HasEmail(portalID, email string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, member := range s.members {
+		if member.PortalID == portalID && strings.ToLower(s.users[member.UserID].Email) == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Invitations is a store.InvitationStore
+type Invitations struct {
+	*state
+}
+
+// pending reports whether an invitation can still be accepted at now
+func pending(invitation models.PortalInvitation, now time.Time) bool {
+	return invitation.AcceptedAt == nil && invitation.ExpiresAt.After(now)
+}
+
+// ListPending returns a portal's pending invitations, newest first
+func (s *Invitations) ListPending(portalID string, now time.Time) ([]models.PortalInvitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invitations := []models.PortalInvitation{}
+	for _, invitation := range s.invitations {
+		if invitation.PortalID == portalID && pending(invitation, now) {
+			invitations = append(invitations, invitation)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt.After(invitations[j].CreatedAt)
+	})
+	return invitations, nil
+}
+
+// Create stores a new invitation
+func (s *Invitations) Create(invitation *models.PortalInvitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.invitations {
+		if existing.TokenHash == invitation.TokenHash {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := invitation.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&invitation.CreatedAt, nil)
+	s.invitations[invitation.ID] = *invitation
+	return nil
+}
+
+// Delete removes a pending invitation of the portal
+func (s *Invitations) Delete(portalID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, ok := s.invitations[id]
+	if !ok || invitation.PortalID != portalID || invitation.AcceptedAt != nil {
+		return store.ErrNotFound
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+// GetPending finds a pending invitation by the hash of its token
+func (s *Invitations) GetPending(tokenHash string, now time.Time) (models.PortalInvitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, invitation := range s.invitations {
+		if invitation.TokenHash == tokenHash && pending(invitation, now) {
+			return invitation, nil
+		}
+	}
+	return models.PortalInvitation{}, store.ErrNotFound
+}
+
+// Accept marks an invitation as accepted and gives the user its role
+func (s *Invitations) Accept(invitation models.PortalInvitation, userID string, now time.Time) (models.PortalMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.invitations[invitation.ID]
+	if !ok || stored.AcceptedAt != nil {
+		return models.PortalMember{}, store.ErrNotFound
+	}
+	stored.AcceptedAt = &now
+	s.invitations[stored.ID] = stored
+
+	key := [2]string{stored.PortalID, userID}
+	member, ok := s.members[key]
+	if ok && models.RoleAtLeast(member.Role, stored.Role) {
+		return member, nil
+	}
+	if !ok {
+		member = models.PortalMember{PortalID: stored.PortalID, UserID: userID}
+		if err := member.BeforeCreate(nil); err != nil {
+			return member, err
+		}
+		stamp(&member.CreatedAt, nil)
+	}
+	member.Role = stored.Role
+	stamp(nil, &member.UpdatedAt)
+	s.members[key] = member
+	return member, nil
+}
+
+// Categories is a store.CategoryStore
+type Categories struct {
+	*state
+}
+
+// ListByPortal returns a portal's categories in display order
+func (s *Categories) ListByPortal(portalID string) ([]models.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categories := []models.Category{}
+	for _, category := range s.categories {
+		if category.PortalID == portalID {
+			categories = append(categories, category)
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories, nil
+}
+
+// CountActive returns how many active (open or pending) conversations the
+// portal has per category ID
+func (s *Categories) CountActive(portalID string) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, conversation := range s.conversations {
+		if conversation.PortalID != portalID || conversation.CategoryID == nil ||
+			!matches(conversation, store.ConversationFilter{Statuses: models.ActiveStatuses}) {
+			continue
+		}
+		counts[*conversation.CategoryID]++
+	}
+	return counts, nil
+}
+
+// Find returns the portal's category with the given ID or slug
+func (s *Categories) Find(portalID, ref string) (models.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, category := range s.categories {
+		if category.PortalID == portalID && (category.ID == ref || category.Slug == ref) {
+			return category, nil
+		}
+	}
+	return models.Category{}, store.ErrNotFound
+}
+
+// GetBySlug returns the portal's category with the given slug
+func (s *Categories) GetBySlug(portalID, slug string) (models.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	category, ok := s.bySlug(portalID, slug)
+	if !ok {
+		return models.Category{}, store.ErrNotFound
+	}
+	return category, nil
+}
+
+// bySlug looks up a category; callers hold the lock
+func (s *Categories) bySlug(portalID, slug string) (models.Category, bool) {
+	for _, category := range s.categories {
+		if category.PortalID == portalID && category.Slug == slug {
+			return category, true
+		}
+	}
+	return models.Category{}, false
+}
+
+// GetByOldSlug finds a portal's category by a slug it used before a rename
+func (s *Categories) GetByOldSlug(portalID, slug string) (models.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	category, ok := s.categories[s.oldCategories[[2]string{portalID, slug}]]
+	if !ok {
+		return models.Category{}, store.ErrNotFound
+	}
+	return category, nil
+}
+
+// SlugTaken reports whether another category of the portal uses slug, either
+// currently or as a slug kept for old links
+func (s *Categories) SlugTaken(portalID, slug, exceptID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if category, ok := s.bySlug(portalID, slug); ok && category.ID != exceptID {
+		return true, nil
+	}
+	categoryID, ok := s.oldCategories[[2]string{portalID, slug}]
+	return ok && categoryID != exceptID, nil
+}
+
+// Create stores a new category
+func (s *Categories) Create(category *models.Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(category)
+}
+
+// create stores a new category; callers hold the lock
+func (s *Categories) create(category *models.Category) error {
+	if err := category.BeforeCreate(nil); err != nil {
+		return err
+	}
+	if _, ok := s.bySlug(category.PortalID, category.Slug); ok {
+		return store.ErrDuplicate
+	}
+	if category.Enabled == nil {
+		enabled := true
+		category.Enabled = &enabled
+	}
+	stamp(&category.CreatedAt, &category.UpdatedAt)
+	s.categories[category.ID] = *category
+	s.rememberSlug(*category)
+	return nil
+}
+
+// rememberSlug records the name a category's slug was first made from
+func (s *Categories) rememberSlug(category models.Category) {
+	if _, ok := s.categorySlugs[category.Slug]; !ok && category.Slug != "" && category.Name != "" {
+		s.categorySlugs[category.Slug] = category.Name
+	}
+}
+
+// Ensure stores a category unless the portal has one with its slug already
+func (s *Categories) Ensure(category *models.Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if category.Slug == "" {
+		if err := category.BeforeCreate(nil); err != nil {
+			return err
+		}
+	}
+	if existing, ok := s.bySlug(category.PortalID, category.Slug); ok {
+		*category = existing
+		return nil
+	}
+	return s.create(category)
+}
+
+// Update stores a category along with the name and slug on its conversations,
+// keeping the old slug so that links using it keep working
+func (s *Categories) Update(category *models.Category, oldSlug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.categories[category.ID]; !ok {
+		return store.ErrNotFound
+	}
+	if existing, ok := s.bySlug(category.PortalID, category.Slug); ok && existing.ID != category.ID {
+		return store.ErrDuplicate
+	}
+	if category.Slug != oldSlug {
+		key := [2]string{category.PortalID, category.Slug}
+		if s.oldCategories[key] == category.ID {
+			delete(s.oldCategories, key)
+		}
+		if oldSlug != "" {
+			s.oldCategories[[2]string{category.PortalID, oldSlug}] = category.ID
+		}
+	}
+	stamp(nil, &category.UpdatedAt)
+	s.categories[category.ID] = *category
+	s.rememberSlug(*category)
+
+	for id, conversation := range s.conversations {
+		if conversation.CategoryID != nil && *conversation.CategoryID == category.ID {
+			conversation.Category = category.Name
+			conversation.CategorySlug = category.Slug
+			s.conversations[id] = conversation
+		}
+	}
+	return nil
+}
+
+// Delete removes a category along with its conversations, their messages,
+// read receipts and old slugs
+func (s *Categories) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conversationID, conversation := range s.conversations {
+		if conversation.CategoryID != nil && *conversation.CategoryID == id {
+			s.deleteConversation(conversationID)
+		}
+	}
+	for key, categoryID := range s.oldCategories {
+		if categoryID == id {
+			delete(s.oldCategories, key)
+		}
+	}
+	delete(s.categories, id)
+	return nil
+}
+
+// SlugSource returns the name a category slug was first made from
+func (s *Categories) SlugSource(slug string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	source, ok := s.categorySlugs[slug]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return source, nil
+}
+
+// Conversations is a store.ConversationStore
+type Conversations struct {
+	*state
+}
+
+// withPublicPortal fills in the portal fields customers may see
+func (s *Conversations) withPublicPortal(conversation models.Conversation) models.Conversation {
+	portal := s.portals[conversation.PortalID]
+	conversation.Portal = models.Portal{ID: portal.ID, Name: portal.Name, CustomName: portal.CustomName}
+	return conversation
+}
+
+// GetByID returns the conversation with the given ID
+func (s *Conversations) GetByID(id string) (models.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return models.Conversation{}, store.ErrNotFound
+	}
+	return conversation, nil
+}
+
+// GetByCode finds a conversation by its unique code
+func (s *Conversations) GetByCode(code string) (models.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, conversation := range s.conversations {
+		if conversation.UniqueCode == code {
+			return s.withPublicPortal(conversation), nil
+		}
+	}
+	return models.Conversation{}, store.ErrNotFound
+}
+
+// GetByURL finds a conversation by the parts of its link
+func (s *Conversations) GetByURL(portalID, categorySlug, code string) (models.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, conversation := range s.conversations {
+		if conversation.PortalID == portalID && conversation.CategorySlug == categorySlug && conversation.UniqueCode == code {
+			return s.withPublicPortal(conversation), nil
+		}
+	}
+	return models.Conversation{}, store.ErrNotFound
+}
+
+// Create stores a new conversation
+func (s *Conversations) Create(conversation *models.Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.conversations {
+		if existing.UniqueCode == conversation.UniqueCode {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := conversation.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&conversation.CreatedAt, &conversation.UpdatedAt)
+	s.conversations[conversation.ID] = *conversation
+	return nil
+}
+
+// UpdateCustomer stores who the customer of a conversation is
+func (s *Conversations) UpdateCustomer(id, customerID, customerName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	conversation.CustomerID = customerID
+	conversation.CustomerName = customerName
+	stamp(nil, &conversation.UpdatedAt)
+	s.conversations[id] = conversation
+	return nil
+}
+
+// Claim hands an unclaimed conversation to a customer through its link
+func (s *Conversations) Claim(id, customerID, customerName string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok || conversation.Claimed() || conversation.LinkState(now) != models.LinkActive {
+		return 0, store.ErrNotFound
+	}
+	conversation.CustomerID = customerID
+	conversation.CustomerName = customerName
+	conversation.CustomerTokenVersion++
+	conversation.LinkUses++
+	stamp(nil, &conversation.UpdatedAt)
+	s.conversations[id] = conversation
+	return conversation.CustomerTokenVersion, nil
+}
+
+// RevokeCustomerTokens bumps the customer token version of a conversation
+func (s *Conversations) RevokeCustomerTokens(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	conversation.CustomerTokenVersion++
+	s.conversations[id] = conversation
+	return conversation.CustomerTokenVersion, nil
+}
+
+// RevokeLink turns a conversation link off, keeping the time it was first revoked
+func (s *Conversations) RevokeLink(id string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if conversation.LinkRevokedAt == nil {
+		conversation.LinkRevokedAt = &revokedAt
+	}
+	stamp(nil, &conversation.UpdatedAt)
+	s.conversations[id] = conversation
+	return nil
+}
+
+// UpdateStatus stores a conversation's status along with its snooze and resolution times
+func (s *Conversations) UpdateStatus(id, status string, snoozedUntil, resolvedAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	conversation.Status = status
+	conversation.SnoozedUntil = snoozedUntil
+	conversation.ResolvedAt = resolvedAt
+	stamp(nil, &conversation.UpdatedAt)
+	s.conversations[id] = conversation
+	return nil
+}
+
+// WakeSnoozed reopens the conversations whose snooze ran out
+func (s *Conversations) WakeSnoozed(now time.Time) ([]models.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	woken := []models.Conversation{}
+	for id, conversation := range s.conversations {
+		if conversation.Status != models.ConversationSnoozed || conversation.SnoozedUntil == nil || conversation.SnoozedUntil.After(now) {
+			continue
+		}
+		conversation.Status = models.ConversationOpen
+		conversation.SnoozedUntil = nil
+		stamp(nil, &conversation.UpdatedAt)
+		s.conversations[id] = conversation
+		woken = append(woken, conversation)
+	}
+	return woken, nil
+}
+
+// Assign stores a conversation's assignee and when they were assigned
+func (s *Conversations) Assign(id, portalID string, assigneeID *string, assignedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	s.assign(conversation, assigneeID, assignedAt)
+	return nil
+}
+
+// assign stores an assignment; callers hold the lock
+func (s *Conversations) assign(conversation models.Conversation, assigneeID *string, assignedAt time.Time) {
+	conversation.AssigneeID = assigneeID
+	conversation.AssignedAt = nil
+	if assigneeID != nil {
+		conversation.AssignedAt = &assignedAt
+
+		// Manual assignments count towards round-robin fairness too
+		key := [2]string{conversation.PortalID, *assigneeID}
+		if member, ok := s.members[key]; ok {
+			member.LastAssignedAt = &assignedAt
+			s.members[key] = member
+		}
+	}
+	stamp(nil, &conversation.UpdatedAt)
+	s.conversations[conversation.ID] = conversation
+}
+
+// AutoAssign routes an unassigned conversation to the team member mode picks
+func (s *Conversations) AutoAssign(id, portalID, mode string, assignedAt time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[id]
+	if !ok || conversation.AssigneeID != nil {
+		return "", nil
+	}
+	if mode != models.RoutingRoundRobin && mode != models.RoutingLeastBusy {
+		return "", nil
+	}
+
+	// Assigned conversations still being worked on, per user
+	busy := map[string]int{}
+	for _, other := range s.conversations {
+		if other.PortalID == portalID && other.AssigneeID != nil &&
+			(other.CurrentStatus() == models.ConversationOpen || other.CurrentStatus() == models.ConversationPending) {
+			busy[*other.AssigneeID]++
+		}
+	}
+
+	var candidates []models.PortalMember
+	for _, member := range s.members {
+		if member.PortalID != portalID {
+			continue
+		}
+		for _, role := range models.AssignableRoles {
+			if member.Role == role {
+				candidates = append(candidates, member)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if mode == models.RoutingLeastBusy && busy[a.UserID] != busy[b.UserID] {
+			return busy[a.UserID] < busy[b.UserID]
+		}
+		switch {
+		case a.LastAssignedAt == nil && b.LastAssignedAt != nil:
+			return true
+		case a.LastAssignedAt != nil && b.LastAssignedAt == nil:
+			return false
+		case a.LastAssignedAt != nil && !a.LastAssignedAt.Equal(*b.LastAssignedAt):
+			return a.LastAssignedAt.Before(*b.LastAssignedAt)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+
+	assigneeID := candidates[0].UserID
+	s.assign(conversation, &assigneeID, assignedAt)
+	return assigneeID, nil
+}
+
+// ListByPortal returns a portal's conversations, most recently updated first
+func (s *Conversations) ListByPortal(portalID string, filter store.ConversationFilter) ([]models.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conversations := []models.Conversation{}
+	for _, conversation := range s.conversations {
+		if conversation.PortalID != portalID || !matches(conversation, filter) {
+			continue
+		}
+		conversations = append(conversations, conversation)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].UpdatedAt.After(conversations[j].UpdatedAt)
+	})
+	return conversations, nil
+}
+
+// matches reports whether a conversation passes a filter
+func matches(conversation models.Conversation, filter store.ConversationFilter) bool {
+	if filter.Statuses != nil {
+		found := false
+		for _, status := range filter.Statuses {
+			found = found || conversation.CurrentStatus() == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.AssigneeID != "" && (conversation.AssigneeID == nil || *conversation.AssigneeID != filter.AssigneeID) {
+		return false
+	}
+	if filter.Unassigned && conversation.AssigneeID != nil {
+		return false
+	}
+	return true
+}
+
+// Delete removes a conversation along with its messages and read receipts
+func (s *Conversations) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteConversation(id)
+	return nil
+}
+
+// deleteConversation removes a conversation along with its messages and read
+// receipts; callers hold the lock
+func (s *state) deleteConversation(id string) {
+	delete(s.conversations, id)
+	delete(s.messages, id)
+	for key := range s.receipts {
+		if key[0] == id {
+			delete(s.receipts, key)
+		}
+	}
+}
+
+// Messages is a store.MessageStore
+type Messages struct {
+	*state
+}
+
+// Create stores a message and bumps its conversation's updated_at
+func (s *Messages) Create(message *models.Message) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message.ClientMessageID != nil {
+		for _, existing := range s.messages[message.ConversationID] {
+			if existing.ClientMessageID != nil && *existing.ClientMessageID == *message.ClientMessageID {
+				*message = existing
+				return false, nil
+			}
+		}
+	}
+
+	conversation, ok := s.conversations[message.ConversationID]
+	if !ok {
+		return false, store.ErrNotFound
+	}
+	if err := message.BeforeCreate(nil); err != nil {
+		return false, err
+	}
+	stamp(&message.CreatedAt, nil)
+
+	// Messages are numbered per conversation
+	messages := s.messages[message.ConversationID]
+	message.Seq = 1
+	if len(messages) > 0 {
+		message.Seq = messages[len(messages)-1].Seq + 1
+	}
+	s.messages[message.ConversationID] = append(messages, *message)
+
+	conversation.UpdatedAt = message.CreatedAt
+	s.conversations[conversation.ID] = conversation
+	return true, nil
+}
+
+// ListByConversation returns a conversation's messages, oldest first
+func (s *Messages) ListByConversation(conversationID string) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := append([]models.Message{}, s.messages[conversationID]...)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+// Get returns the conversation's message with the given ID
+func (s *Messages) Get(conversationID, id string) (models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, message := range s.messages[conversationID] {
+		if message.ID == id {
+			return message, nil
+		}
+	}
+	return models.Message{}, store.ErrNotFound
+}
+
+// Latest returns the conversation's most recent message
+func (s *Messages) Latest(conversationID string) (models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := s.messages[conversationID]
+	if len(messages) == 0 {
+		return models.Message{}, store.ErrNotFound
+	}
+	return messages[len(messages)-1], nil
+}
+
+// ListAfterSeq returns up to limit of the conversation's messages after seq
+func (s *Messages) ListAfterSeq(conversationID string, seq int64, limit int) ([]models.Message, error) {
+	return s.listWhere(conversationID, limit, func(message models.Message) bool {
+		return message.Seq > seq
+	})
+}
+
+// ListAfterTime returns up to limit of the conversation's messages sent after t
+func (s *Messages) ListAfterTime(conversationID string, t time.Time, limit int) ([]models.Message, error) {
+	return s.listWhere(conversationID, limit, func(message models.Message) bool {
+		return message.CreatedAt.After(t)
+	})
+}
+
+// listWhere returns up to limit of the conversation's messages that match, in
+// seq order, which is the order they are stored in
+func (s *Messages) listWhere(conversationID string, limit int, match func(models.Message) bool) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []models.Message{}
+	for _, message := range s.messages[conversationID] {
+		if len(messages) == limit {
+			break
+		}
+		if match(message) {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Count returns how many messages a conversation has
+func (s *Messages) Count(conversationID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.messages[conversationID])), nil
+}
+
+// CountUnread returns how many messages from the other side the participant
+// has not read yet, going by their read receipt
+func (s *Messages) CountUnread(conversationID, participantID string, isOwner bool) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	receipt := s.receipts[[2]string{conversationID, participantID}]
+
+	var count int64
+	for _, message := range s.messages[conversationID] {
+		if message.IsOwner != isOwner && message.Seq > receipt.LastReadSeq {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ReadReceipts is a store.ReadReceiptStore
+type ReadReceipts struct {
+	*state
+}
+
+// Advance inserts or moves forward the participant's read receipt
+func (s *ReadReceipts) Advance(receipt *models.ReadReceipt) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{receipt.ConversationID, receipt.ParticipantID}
+	stored, ok := s.receipts[key]
+	if ok && stored.LastReadSeq >= receipt.LastReadSeq {
+		*receipt = stored
+		return false, nil
+	}
+
+	if ok {
+		receipt.ID = stored.ID
+	} else if err := receipt.BeforeCreate(nil); err != nil {
+		return false, err
+	}
+	s.receipts[key] = *receipt
+	return true, nil
+}
+
+// RefreshTokens is a store.RefreshTokenStore
+type RefreshTokens struct {
+	*state
+}
+
+// Create stores a new refresh token
+func (s *RefreshTokens) Create(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.refreshTokens {
+		if existing.TokenHash == token.TokenHash {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := token.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&token.CreatedAt, nil)
+	s.refreshTokens[token.ID] = *token
+	return nil
+}
+
+// GetByHash finds a refresh token by the hash of its value
+func (s *RefreshTokens) GetByHash(tokenHash string) (models.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return models.RefreshToken{}, store.ErrNotFound
+}
+
+// Revoke revokes a refresh token that is not revoked yet
+func (s *RefreshTokens) Revoke(id string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[id]
+	if !ok || token.RevokedAt != nil {
+		return store.ErrNotFound
+	}
+	token.RevokedAt = &revokedAt
+	s.refreshTokens[id] = token
+	return nil
+}
+
+// RevokeFamily revokes every token of a session
+func (s *RefreshTokens) RevokeFamily(familyID string, revokedAt time.Time) error {
+	return s.revokeWhere(revokedAt, func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+}
+
+// RevokeForUser revokes every token of every session of the user
+func (s *RefreshTokens) RevokeForUser(userID string, revokedAt time.Time) error {
+	return s.revokeWhere(revokedAt, func(token models.RefreshToken) bool {
+		return token.UserID == userID
+	})
+}
+
+// revokeWhere revokes the tokens that are not revoked yet and match
+func (s *RefreshTokens) revokeWhere(revokedAt time.Time, match func(models.RefreshToken) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &revokedAt
+			s.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+// UserTokens is a store.UserTokenStore
+type UserTokens struct {
+	*state
+}
+
+// Create stores a new user token
+func (s *UserTokens) Create(token *models.UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.userTokens {
+		if existing.TokenHash == token.TokenHash {
+			return store.ErrDuplicate
+		}
+	}
+
+	if err := token.BeforeCreate(nil); err != nil {
+		return err
+	}
+	stamp(&token.CreatedAt, nil)
+	s.userTokens[token.ID] = *token
+	return nil
+}
+
+// Use marks an unused, unexpired token as used and returns it
+func (s *UserTokens) Use(purpose, tokenHash string, now time.Time) (models.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.Purpose == purpose && token.TokenHash == tokenHash && token.UsedAt == nil && now.Before(token.ExpiresAt) {
+			token.UsedAt = &now
+			s.userTokens[id] = token
+			return token, nil
+		}
+	}
+	return models.UserToken{}, store.ErrNotFound
+}
+
+// ResetPassword uses a password reset token and applies the reset. The
+// lookups come first, so that nothing changes if one of them fails.
+func (s *UserTokens) ResetPassword(tokenHash, passwordHash string, now time.Time) (models.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.Purpose != models.TokenPasswordReset || token.TokenHash != tokenHash || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+			continue
+		}
+		user, ok := s.users[token.UserID]
+		if !ok {
+			return models.UserToken{}, store.ErrNotFound
+		}
+
+		token.UsedAt = &now
+		s.userTokens[id] = token
+
+		user.Password = passwordHash
+		user.TokenVersion++
+		if user.EmailVerifiedAt == nil {
+			user.EmailVerifiedAt = &now
+		}
+		stamp(nil, &user.UpdatedAt)
+		s.users[user.ID] = user
+
+		for refreshID, refresh := range s.refreshTokens {
+			if refresh.UserID == user.ID && refresh.RevokedAt == nil {
+				refresh.RevokedAt = &now
+				s.refreshTokens[refreshID] = refresh
+			}
+		}
+		return token, nil
+	}
+	return models.UserToken{}, store.ErrNotFound
+}
+
+// InvalidateForUser marks the user's unused tokens for purpose as used
+func (s *UserTokens) InvalidateForUser(userID, purpose string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+			s.userTokens[id] = token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"server/database/models"
+	"server/store"
+	"server/store/memory"
+)
+
+func TestUsers(t *testing.T) {
+	stores := memory.New()
+
+	user := models.User{Name: "Ada", Email: "ada@example.com"}
+	if err := stores.Users.Create(&user); err != nil || user.ID == "" {
+		t.Fatalf("create user: %+v, %v", user, err)
+	}
+	if err := stores.Users.Create(&models.User{Name: "Other Ada", Email: "ada@example.com"}); !errors.Is(err, store.ErrDuplicate) {
+		t.Fatalf("creating a second user with the same email: %v, want ErrDuplicate", err)
+	}
+	if _, err := stores.Users.GetByID("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("get a missing user: %v, want ErrNotFound", err)
+	}
+
+	version, err := stores.Users.RevokeTokens(user.ID)
+	if err != nil || version != user.TokenVersion+1 {
+		t.Fatalf("revoke tokens = %d, %v; want version %d", version, err, user.TokenVersion+1)
+	}
+	if got, _ := stores.Users.GetByEmail("ada@example.com"); got.TokenVersion != version {
+		t.Fatalf("stored token version %d, want %d", got.TokenVersion, version)
+	}
+}
+
+func TestConversationClaim(t *testing.T) {
+	stores := memory.New()
+	now := time.Now()
+
+	conversation := models.Conversation{PortalID: "portal", UniqueCode: "CODE", CustomerID: models.UnclaimedCustomerID, LinkMaxUses: 1}
+	if err := stores.Conversations.Create(&conversation); err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	if err := stores.Conversations.Create(&models.Conversation{PortalID: "portal", UniqueCode: "CODE"}); !errors.Is(err, store.ErrDuplicate) {
+		t.Fatalf("reusing a code: %v, want ErrDuplicate", err)
+	}
+
+	version, err := stores.Conversations.Claim(conversation.ID, "customer", "Grace", now)
+	if err != nil || version != conversation.CustomerTokenVersion+1 {
+		t.Fatalf("claim = %d, %v; want version %d", version, err, conversation.CustomerTokenVersion+1)
+	}
+	if _, err := stores.Conversations.Claim(conversation.ID, "other", "Linus", now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("claiming twice: %v, want ErrNotFound", err)
+	}
+	claimed, _ := stores.Conversations.GetByID(conversation.ID)
+	if claimed.CustomerID != "customer" || claimed.LinkUses != 1 {
+		t.Fatalf("claimed conversation = %+v", claimed)
+	}
+}
+
+func TestMessagesAreNumberedAndDeduplicated(t *testing.T) {
+	stores := memory.New()
+
+	first := models.Conversation{PortalID: "portal", UniqueCode: "FIRST"}
+	second := models.Conversation{PortalID: "portal", UniqueCode: "SECOND"}
+	for _, conversation := range []*models.Conversation{&first, &second} {
+		if err := stores.Conversations.Create(conversation); err != nil {
+			t.Fatalf("create conversation: %v", err)
+		}
+	}
+
+	send := func(conversationID, clientID string) (models.Message, bool) {
+		t.Helper()
+
+		message := models.Message{ConversationID: conversationID, Content: "Hello", SenderID: "customer"}
+		if clientID != "" {
+			message.ClientMessageID = &clientID
+		}
+		created, err := stores.Messages.Create(&message)
+		if err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		return message, created
+	}
+
+	a, _ := send(first.ID, "client-1")
+	b, _ := send(second.ID, "client-1")
+	c, _ := send(first.ID, "")
+	if a.Seq != 1 || b.Seq != 1 || c.Seq != 2 {
+		t.Fatalf("seqs = %d, %d, %d; want 1, 1, 2", a.Seq, b.Seq, c.Seq)
+	}
+
+	// A client message ID is only a duplicate within its conversation
+	again, created := send(first.ID, "client-1")
+	if created || again.ID != a.ID || again.Seq != a.Seq {
+		t.Fatalf("resent message = %+v (created %v), want the original", again, created)
+	}
+	if count, _ := stores.Messages.Count(first.ID); count != 2 {
+		t.Fatalf("%d messages stored, want 2", count)
+	}
+
+	after, err := stores.Messages.ListAfterSeq(first.ID, 1, 10)
+	if err != nil || len(after) != 1 || after[0].ID != c.ID {
+		t.Fatalf("messages after seq 1 = %+v, %v; want only the second", after, err)
+	}
+
+	if _, err := stores.Messages.Create(&models.Message{ConversationID: "missing", Content: "Hello"}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("message to a missing conversation: %v, want ErrNotFound", err)
+	}
+}
+
+func TestListByPortalFilters(t *testing.T) {
+	stores := memory.New()
+	assignee := "agent"
+
+	open := models.Conversation{PortalID: "portal", UniqueCode: "OPEN", Status: models.ConversationOpen, AssigneeID: &assignee}
+	legacy := models.Conversation{PortalID: "portal", UniqueCode: "LEGACY"}
+	closed := models.Conversation{PortalID: "portal", UniqueCode: "CLOSED", Status: models.ConversationClosed}
+	elsewhere := models.Conversation{PortalID: "other", UniqueCode: "ELSEWHERE"}
+	for _, conversation := range []*models.Conversation{&open, &legacy, &closed, &elsewhere} {
+		if err := stores.Conversations.Create(conversation); err != nil {
+			t.Fatalf("create conversation: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter store.ConversationFilter
+		want   int
+	}{
+		{"everything", store.ConversationFilter{}, 3},
+		// Conversations from before statuses existed count as open
+		{"open", store.ConversationFilter{Statuses: []string{models.ConversationOpen}}, 2},
+		{"closed", store.ConversationFilter{Statuses: []string{models.ConversationClosed}}, 1},
+		{"assigned", store.ConversationFilter{AssigneeID: assignee}, 1},
+		{"unassigned", store.ConversationFilter{Unassigned: true}, 2},
+	} {
+		conversations, err := stores.Conversations.ListByPortal("portal", tc.filter)
+		if err != nil || len(conversations) != tc.want {
+			t.Errorf("%s: %d conversations, %v; want %d", tc.name, len(conversations), err, tc.want)
+		}
+	}
+}
+
+func TestTokensWorkOnce(t *testing.T) {
+	stores := memory.New()
+	now := time.Now()
+
+	user := models.User{Name: "Ada", Email: "ada@example.com"}
+	if err := stores.Users.Create(&user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	refresh := models.RefreshToken{UserID: user.ID, FamilyID: "family", TokenHash: "refresh", ExpiresAt: now.Add(time.Hour)}
+	if err := stores.RefreshTokens.Create(&refresh); err != nil {
+		t.Fatalf("create refresh token: %v", err)
+	}
+	if err := stores.RefreshTokens.Revoke(refresh.ID, now); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if err := stores.RefreshTokens.Revoke(refresh.ID, now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("revoking twice: %v, want ErrNotFound", err)
+	}
+
+	for _, token := range []models.UserToken{
+		{UserID: user.ID, Purpose: models.TokenPasswordReset, TokenHash: "reset", ExpiresAt: now.Add(time.Hour)},
+		{UserID: user.ID, Purpose: models.TokenPasswordReset, TokenHash: "expired", ExpiresAt: now.Add(-time.Minute)},
+	} {
+		if err := stores.UserTokens.Create(&token); err != nil {
+			t.Fatalf("create user token: %v", err)
+		}
+	}
+	if _, err := stores.UserTokens.Use(models.TokenEmailVerification, "reset", now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("using a token for another purpose: %v, want ErrNotFound", err)
+	}
+	if _, err := stores.UserTokens.Use(models.TokenPasswordReset, "expired", now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("using an expired token: %v, want ErrNotFound", err)
+	}
+	if _, err := stores.UserTokens.ResetPassword("reset", "new-hash", now); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if _, err := stores.UserTokens.ResetPassword("reset", "other-hash", now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("resetting twice: %v, want ErrNotFound", err)
+	}
+
+	reset, _ := stores.Users.GetByID(user.ID)
+	if reset.Password != "new-hash" || reset.TokenVersion != user.TokenVersion+1 || reset.EmailVerifiedAt == nil {
+		t.Fatalf("user after reset = %+v", reset)
+	}
+}
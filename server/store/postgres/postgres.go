@@ -0,0 +1,964 @@
+// Package postgres implements the stores on top of GORM and PostgreSQL.
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"server/database/models"
+	"server/store"
+)
+
+// uniqueViolation is the PostgreSQL error code for a broken unique constraint
+const uniqueViolation = "23505"
+
+// New returns stores backed by db
+func New(db *gorm.DB) store.Stores {
+	return store.Stores{
+		Users:         &Users{db: db},
+		Portals:       &Portals{db: db},
+		Members:       &Members{db: db},
+		Invitations:   &Invitations{db: db},
+		Categories:    &Categories{db: db},
+		Conversations: &Conversations{db: db},
+		Messages:      &Messages{db: db},
+		ReadReceipts:  &ReadReceipts{db: db},
+		RefreshTokens: &RefreshTokens{db: db},
+		UserTokens:    &UserTokens{db: db},
+	}
+}
+
+// translate maps GORM and driver errors to the store errors
+func translate(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return store.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return store.ErrDuplicate
+	}
+	return err
+}
+
+// publicPortal loads only the portal fields customers may see
+func publicPortal(db *gorm.DB) *gorm.DB {
+	return db.Select("id, name, custom_name")
+}
+
+// Users is a store.UserStore
+type Users struct {
+	db *gorm.DB
+}
+
+// GetByID returns the user with the given ID
+func (s *Users) GetByID(id string) (models.User, error) {
+	var user models.User
+	err := s.db.Where("id = ?", id).First(&user).Error
+	return user, translate(err)
+}
+
+// GetByEmail returns the user with the given email
+func (s *Users) GetByEmail(email string) (models.User, error) {
+	var user models.User
+	err := s.db.Where("email = ?", email).First(&user).Error
+	return user, translate(err)
+}
+
+// Create stores a new user
+func (s *Users) Create(user *models.User) error {
+	return translate(s.db.Create(user).Error)
+}
+
+// SelectPortal stores the portal the user's dashboard works on
+func (s *Users) SelectPortal(userID, portalID string) error {
+	return s.db.Model(&models.User{}).Where("id = ?", userID).Update("selected_portal_id", portalID).Error
+}
+
+// UpdatePassword stores a new password hash for the user
+func (s *Users) UpdatePassword(userID, passwordHash string) error {
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// MarkEmailVerified records when the user verified their email
+func (s *Users) MarkEmailVerified(userID string, verifiedAt time.Time) error {
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).
+		Update("email_verified_at", gorm.Expr("COALESCE(email_verified_at, ?)", verifiedAt))
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// RevokeTokens bumps the user's token version
+func (s *Users) RevokeTokens(userID string) (int, error) {
+	var version int
+	err := s.db.Raw(`UPDATE users
+		SET token_version = token_version + 1
+		WHERE id = ?
+		RETURNING token_version`, userID,
+	).Scan(&version).Error
+	if err == nil && version == 0 {
+		return 0, store.ErrNotFound
+	}
+	return version, err
+}
+
+// Portals is a store.PortalStore
+type Portals struct {
+	db *gorm.DB
+}
+
+// GetByID returns the portal with the given ID
+func (s *Portals) GetByID(id string) (models.Portal, error) {
+	var portal models.Portal
+	err := s.db.Where("id = ?", id).First(&portal).Error
+	return portal, translate(err)
+}
+
+// GetByCustomName returns the portal with the given custom name
+func (s *Portals) GetByCustomName(customName string) (models.Portal, error) {
+	var portal models.Portal
+	err := s.db.Where("custom_name = ?", customName).First(&portal).Error
+	return portal, translate(err)
+}
+
+// GetByOldCustomName finds a portal by a custom name it used before a rename
+func (s *Portals) GetByOldCustomName(customName string) (models.Portal, error) {
+	var oldSlug models.PortalSlug
+	if err := s.db.Where("slug = ?", customName).First(&oldSlug).Error; err != nil {
+		return models.Portal{}, translate(err)
+	}
+	return s.GetByID(oldSlug.PortalID)
+}
+
+// ListForUser returns the portals the user owns or is a member of
+func (s *Portals) ListForUser(userID string) ([]models.Portal, error) {
+	var portals []models.Portal
+	err := s.db.Where("owner_id = ? OR id IN (?)", userID,
+		s.db.Model(&models.PortalMember{}).Select("portal_id").Where("user_id = ?", userID),
+	).Find(&portals).Error
+	return portals, err
+}
+
+// CountOwnedBy returns how many portals the user owns
+func (s *Portals) CountOwnedBy(userID string) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Portal{}).Where("owner_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// NameTaken reports whether a portal already has the given name
+func (s *Portals) NameTaken(name string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Portal{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+// CustomNameTaken reports whether a portal other than exceptPortalID uses
+// customName, either currently or as a name kept for redirects
+func (s *Portals) CustomNameTaken(customName, exceptPortalID string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Portal{}).Where("custom_name = ? AND id != ?", customName, exceptPortalID).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err = s.db.Model(&models.PortalSlug{}).Where("slug = ? AND portal_id != ?", customName, exceptPortalID).Count(&count).Error
+	return count > 0, err
+}
+
+// Create stores a portal along with its owner's membership. A user's first
+// portal becomes their selected portal.
+func (s *Portals) Create(portal *models.Portal) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(portal).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PortalMember{
+			PortalID: portal.ID,
+			UserID:   portal.OwnerID,
+			Role:     models.RoleOwner,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).
+			Where("id = ? AND selected_portal_id IS NULL", portal.OwnerID).
+			Update("selected_portal_id", portal.ID).Error
+	})
+	return translate(err)
+}
+
+// Update stores a portal's settings
+func (s *Portals) Update(portal *models.Portal) error {
+	return translate(s.db.Save(portal).Error)
+}
+
+// Rename stores a portal under its new name and custom name, keeping the old
+// custom name so that links using it redirect
+func (s *Portals) Rename(portal *models.Portal, oldCustomName string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if portal.CustomName != oldCustomName {
+			// Taking back one of the portal's own old names ends its redirect
+			if err := tx.Where("slug = ? AND portal_id = ?", portal.CustomName, portal.ID).Delete(&models.PortalSlug{}).Error; err != nil {
+				return err
+			}
+			if oldCustomName != "" {
+				if err := tx.Create(&models.PortalSlug{PortalID: portal.ID, Slug: oldCustomName}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Save(portal).Error
+	})
+	return translate(err)
+}
+
+// MemberRole returns the user's role on the portal's team
+func (s *Portals) MemberRole(portalID, userID string) (string, error) {
+	var member models.PortalMember
+	err := s.db.Select("role").Where("portal_id = ? AND user_id = ?", portalID, userID).First(&member).Error
+	return member.Role, translate(err)
+}
+
+// Members is a store.MemberStore
+type Members struct {
+	db *gorm.DB
+}
+
+// ListByPortal returns a portal's members with their users loaded
+func (s *Members) ListByPortal(portalID string) ([]models.PortalMember, error) {
+	var members []models.PortalMember
+	err := s.db.Preload("User").Where("portal_id = ?", portalID).Order("created_at ASC").Find(&members).Error
+	return members, err
+}
+
+// GetByID returns the portal's member with the given ID
+func (s *Members) GetByID(portalID, id string) (models.PortalMember, error) {
+	var member models.PortalMember
+	err := s.db.Where("id = ? AND portal_id = ?", id, portalID).First(&member).Error
+	return member, translate(err)
+}
+
+// UpdateRole changes a member's role
+func (s *Members) UpdateRole(id, role string) error {
+	result := s.db.Model(&models.PortalMember{}).Where("id = ?", id).Update("role", role)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// Delete removes a member from their portal's team
+func (s *Members) Delete(id string) error {
+	return s.db.Where("id = ?", id).Delete(&models.PortalMember{}).Error
+}
+
+// HasEmail reports whether the user with the given email is on the portal's team
+func (s *Members) HasEmail(portalID, email string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.PortalMember{}).
+		Joins("JOIN users ON users.id = portal_members.user_id").
+		Where("portal_members.portal_id = ? AND LOWER(users.email) = ?", portalID, email).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Invitations is a store.InvitationStore
+type Invitations struct {
+	db *gorm.DB
+}
+
+// ListPending returns a portal's pending invitations, newest first
+func (s *Invitations) ListPending(portalID string, now time.Time) ([]models.PortalInvitation, error) {
+	var invitations []models.PortalInvitation
+	err := s.db.Where("portal_id = ? AND accepted_at IS NULL AND expires_at > ?", portalID, now).Order("created_at DESC").Find(&invitations).Error
+	return invitations, err
+}
+
+// Create stores a new invitation
+func (s *Invitations) Create(invitation *models.PortalInvitation) error {
+	return translate(s.db.Create(invitation).Error)
+}
+
+// Delete removes a pending invitation of the portal
+func (s *Invitations) Delete(portalID, id string) error {
+	result := s.db.Where("id = ? AND portal_id = ? AND accepted_at IS NULL", id, portalID).Delete(&models.PortalInvitation{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// GetPending finds a pending invitation by the hash of its token
+func (s *Invitations) GetPending(tokenHash string, now time.Time) (models.PortalInvitation, error) {
+	var invitation models.PortalInvitation
+	err := s.db.Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, now).First(&invitation).Error
+	return invitation, translate(err)
+}
+
+// Accept marks an invitation as accepted and gives the user its role
+func (s *Invitations) Accept(invitation models.PortalInvitation, userID string, now time.Time) (models.PortalMember, error) {
+	var member models.PortalMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one request gets to accept the invitation
+		result := tx.Model(&models.PortalInvitation{}).
+			Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return store.ErrNotFound
+		}
+
+		// Accepting never lowers an existing role
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("portal_id = ? AND user_id = ?", invitation.PortalID, userID).
+			First(&member).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			member = models.PortalMember{
+				PortalID: invitation.PortalID,
+				UserID:   userID,
+				Role:     invitation.Role,
+			}
+			return tx.Create(&member).Error
+		}
+		if err != nil || models.RoleAtLeast(member.Role, invitation.Role) {
+			return err
+		}
+
+		member.Role = invitation.Role
+		return tx.Model(&member).Update("role", member.Role).Error
+	})
+	return member, translate(err)
+}
+
+// Categories is a store.CategoryStore
+type Categories struct {
+	db *gorm.DB
+}
+
+// ListByPortal returns a portal's categories in display order
+func (s *Categories) ListByPortal(portalID string) ([]models.Category, error) {
+	var categories []models.Category
+	err := s.db.Where("portal_id = ?", portalID).Order("sort_order ASC, name ASC").Find(&categories).Error
+	return categories, err
+}
+
+// CountActive returns how many active (open or pending) conversations the
+// portal has per category ID
+func (s *Categories) CountActive(portalID string) (map[string]int64, error) {
+	var counts []struct {
+		CategoryID string
+		Count      int64
+	}
+	err := s.db.Model(&models.Conversation{}).
+		Select("category_id, COUNT(*) AS count").
+		Where("portal_id = ? AND category_id IS NOT NULL AND status IN ?", portalID, models.ActiveStatuses).
+		Group("category_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[string]int64, len(counts))
+	for _, count := range counts {
+		byCategory[count.CategoryID] = count.Count
+	}
+	return byCategory, nil
+}
+
+// Find returns the portal's category with the given ID or slug
+func (s *Categories) Find(portalID, ref string) (models.Category, error) {
+	var category models.Category
+	err := s.db.Where("portal_id = ? AND (id = ? OR slug = ?)", portalID, ref, ref).First(&category).Error
+	return category, translate(err)
+}
+
+// GetBySlug returns the portal's category with the given slug
+func (s *Categories) GetBySlug(portalID, slug string) (models.Category, error) {
+	var category models.Category
+	err := s.db.Where("portal_id = ? AND slug = ?", portalID, slug).First(&category).Error
+	return category, translate(err)
+}
+
+// GetByOldSlug finds a portal's category by a slug it used before a rename
+func (s *Categories) GetByOldSlug(portalID, slug string) (models.Category, error) {
+	var oldSlug models.CategorySlug
+	if err := s.db.Where("portal_id = ? AND slug = ?", portalID, slug).First(&oldSlug).Error; err != nil {
+		return models.Category{}, translate(err)
+	}
+	return s.Find(portalID, oldSlug.CategoryID)
+}
+
+// SlugTaken reports whether another category of the portal uses slug, either
+// currently or as a slug kept for old links
+func (s *Categories) SlugTaken(portalID, slug, exceptID string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Category{}).Where("portal_id = ? AND slug = ? AND id != ?", portalID, slug, exceptID).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err = s.db.Model(&models.CategorySlug{}).Where("portal_id = ? AND slug = ? AND category_id != ?", portalID, slug, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+// Create stores a new category
+func (s *Categories) Create(category *models.Category) error {
+	return translate(s.db.Create(category).Error)
+}
+
+// Ensure stores a category unless the portal has one with its slug already
+func (s *Categories) Ensure(category *models.Category) error {
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "portal_id"}, {Name: "slug"}},
+		DoNothing: true,
+	}).Create(category).Error
+	if err != nil {
+		return err
+	}
+
+	stored, err := s.GetBySlug(category.PortalID, category.Slug)
+	if err != nil {
+		return err
+	}
+	*category = stored
+	return nil
+}
+
+// Update stores a category along with the name and slug on its conversations,
+// keeping the old slug so that links using it keep working
+func (s *Categories) Update(category *models.Category, oldSlug string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if category.Slug != oldSlug {
+			// Taking back one of the category's own old slugs ends its redirect
+			if err := tx.Where("portal_id = ? AND slug = ? AND category_id = ?", category.PortalID, category.Slug, category.ID).Delete(&models.CategorySlug{}).Error; err != nil {
+				return err
+			}
+			if oldSlug != "" {
+				if err := tx.Create(&models.CategorySlug{PortalID: category.PortalID, CategoryID: category.ID, Slug: oldSlug}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Save(category).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Conversation{}).Where("category_id = ?", category.ID).Updates(map[string]interface{}{
+			"category":      category.Name,
+			"category_slug": category.Slug,
+		}).Error
+	})
+	return translate(err)
+}
+
+// Delete removes a category along with its conversations, their messages,
+// read receipts and old slugs
+func (s *Categories) Delete(id string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", id).Delete(&models.CategorySlug{}).Error; err != nil {
+			return err
+		}
+		conversationIDs := tx.Model(&models.Conversation{}).Select("id").Where("category_id = ?", id)
+		if err := tx.Where("conversation_id IN (?)", conversationIDs).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", conversationIDs).Delete(&models.ReadReceipt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("category_id = ?", id).Delete(&models.Conversation{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.Category{}).Error
+	})
+}
+
+// SlugSource returns the name a category slug was first made from
+func (s *Categories) SlugSource(slug string) (string, error) {
+	var lookup models.SlugLookup
+	err := s.db.Where("scope = ? AND slug = ?", models.SlugScopeCategory, slug).First(&lookup).Error
+	if err == nil && lookup.Source == "" {
+		return "", store.ErrNotFound
+	}
+	return lookup.Source, translate(err)
+}
+
+// Conversations is a store.ConversationStore
+type Conversations struct {
+	db *gorm.DB
+}
+
+// GetByID returns the conversation with the given ID
+func (s *Conversations) GetByID(id string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.Where("id = ?", id).First(&conversation).Error
+	return conversation, translate(err)
+}
+
+// GetByCode finds a conversation by its unique code
+func (s *Conversations) GetByCode(code string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.Preload("Portal", publicPortal).Where("unique_code = ?", code).First(&conversation).Error
+	return conversation, translate(err)
+}
+
+// GetByURL finds a conversation by the parts of its link
+func (s *Conversations) GetByURL(portalID, categorySlug, code string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.Preload("Portal", publicPortal).Where(
+		"portal_id = ? AND category_slug = ? AND unique_code = ?",
+		portalID, categorySlug, code,
+	).First(&conversation).Error
+	return conversation, translate(err)
+}
+
+// Create stores a new conversation
+func (s *Conversations) Create(conversation *models.Conversation) error {
+	return translate(s.db.Create(conversation).Error)
+}
+
+// UpdateCustomer stores who the customer of a conversation is
+func (s *Conversations) UpdateCustomer(id, customerID, customerName string) error {
+	result := s.db.Model(&models.Conversation{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"customer_id":   customerID,
+		"customer_name": customerName,
+	})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// Claim hands an unclaimed conversation to a customer through its link. The
+// link checks run in the UPDATE so concurrent claims cannot go over the use
+// limit.
+func (s *Conversations) Claim(id, customerID, customerName string, now time.Time) (int, error) {
+	var version int
+	err := s.db.Raw(`UPDATE conversations
+		SET customer_id = ?, customer_name = ?, customer_token_version = customer_token_version + 1,
+			link_uses = link_uses + 1, updated_at = ?
+		WHERE id = ? AND customer_id = ? AND link_revoked_at IS NULL
+			AND (link_expires_at IS NULL OR link_expires_at > ?)
+			AND (link_max_uses = 0 OR link_uses < link_max_uses)
+		RETURNING customer_token_version`,
+		customerID, customerName, now, id, models.UnclaimedCustomerID, now,
+	).Scan(&version).Error
+	if err == nil && version == 0 {
+		return 0, store.ErrNotFound
+	}
+	return version, err
+}
+
+// RevokeCustomerTokens bumps the customer token version of a conversation
+func (s *Conversations) RevokeCustomerTokens(id string) (int, error) {
+	var version int
+	err := s.db.Raw(`UPDATE conversations
+		SET customer_token_version = customer_token_version + 1
+		WHERE id = ?
+		RETURNING customer_token_version`, id,
+	).Scan(&version).Error
+	if err == nil && version == 0 {
+		return 0, store.ErrNotFound
+	}
+	return version, err
+}
+
+// RevokeLink turns a conversation link off, keeping the time it was first revoked
+func (s *Conversations) RevokeLink(id string, revokedAt time.Time) error {
+	result := s.db.Model(&models.Conversation{}).Where("id = ?", id).
+		Update("link_revoked_at", gorm.Expr("COALESCE(link_revoked_at, ?)", revokedAt))
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// UpdateStatus stores a conversation's status along with its snooze and resolution times
+func (s *Conversations) UpdateStatus(id, status string, snoozedUntil, resolvedAt *time.Time) error {
+	return s.db.Model(&models.Conversation{ID: id}).Updates(map[string]interface{}{
+		"status":        status,
+		"snoozed_until": snoozedUntil,
+		"resolved_at":   resolvedAt,
+	}).Error
+}
+
+// WakeSnoozed reopens the conversations whose snooze ran out. The check runs
+// in the UPDATE so that a conversation snoozed again meanwhile stays asleep.
+func (s *Conversations) WakeSnoozed(now time.Time) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	err := s.db.Model(&conversations).
+		Clauses(clause.Returning{}).
+		Where("status = ? AND snoozed_until <= ?", models.ConversationSnoozed, now).
+		Updates(map[string]interface{}{
+			"status":        models.ConversationOpen,
+			"snoozed_until": nil,
+		}).Error
+	return conversations, err
+}
+
+// Assign stores a conversation's assignee and when they were assigned
+func (s *Conversations) Assign(id, portalID string, assigneeID *string, assignedAt time.Time) error {
+	var at *time.Time
+	if assigneeID != nil {
+		at = &assignedAt
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Conversation{ID: id}).Updates(map[string]interface{}{
+			"assignee_id": assigneeID,
+			"assigned_at": at,
+		})
+		if result.Error != nil || assigneeID == nil {
+			return result.Error
+		}
+
+		// Manual assignments count towards round-robin fairness too
+		return tx.Model(&models.PortalMember{}).
+			Where("portal_id = ? AND user_id = ?", portalID, *assigneeID).
+			Update("last_assigned_at", assignedAt).Error
+	})
+}
+
+// AutoAssign routes an unassigned conversation to the team member mode picks
+func (s *Conversations) AutoAssign(id, portalID, mode string, assignedAt time.Time) (string, error) {
+	var assigneeID string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		picked, err := pickAssignee(tx, portalID, mode)
+		if err != nil || picked == "" {
+			return err
+		}
+
+		// Another message may have routed the conversation in the meantime
+		result := tx.Model(&models.Conversation{}).
+			Where("id = ? AND assignee_id IS NULL", id).
+			Updates(map[string]interface{}{
+				"assignee_id": picked,
+				"assigned_at": assignedAt,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		assigneeID = picked
+		return tx.Model(&models.PortalMember{}).
+			Where("portal_id = ? AND user_id = ?", portalID, picked).
+			Update("last_assigned_at", assignedAt).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return assigneeID, nil
+}
+
+// pickAssignee chooses the team member to route a conversation to, or "" if
+// nobody can take it. The chosen member's row stays locked until tx ends, so
+// concurrent routing moves on to the next member.
+func pickAssignee(tx *gorm.DB, portalID, mode string) (string, error) {
+	var member models.PortalMember
+
+	var query string
+	switch mode {
+	case models.RoutingRoundRobin:
+		query = `SELECT portal_members.* FROM portal_members
+			WHERE portal_id = ? AND role IN ?
+			ORDER BY last_assigned_at ASC NULLS FIRST, created_at ASC
+			LIMIT 1 FOR UPDATE SKIP LOCKED`
+	case models.RoutingLeastBusy:
+		query = `SELECT portal_members.* FROM portal_members
+			WHERE portal_id = ? AND role IN ?
+			ORDER BY (
+				SELECT COUNT(*) FROM conversations
+				WHERE conversations.portal_id = portal_members.portal_id
+				AND conversations.assignee_id = portal_members.user_id
+				AND conversations.status IN ('open', 'pending')
+			) ASC, last_assigned_at ASC NULLS FIRST, created_at ASC
+			LIMIT 1 FOR UPDATE SKIP LOCKED`
+	default:
+		return "", nil
+	}
+
+	result := tx.Raw(query, portalID, models.AssignableRoles).Scan(&member)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	return member.UserID, nil
+}
+
+// ListByPortal returns a portal's conversations, most recently updated first
+func (s *Conversations) ListByPortal(portalID string, filter store.ConversationFilter) ([]models.Conversation, error) {
+	query := s.db.Where("portal_id = ?", portalID)
+	if filter.Statuses != nil {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.AssigneeID != "" {
+		query = query.Where("assignee_id = ?", filter.AssigneeID)
+	}
+	if filter.Unassigned {
+		query = query.Where("assignee_id IS NULL")
+	}
+
+	var conversations []models.Conversation
+	err := query.Order("updated_at DESC").Find(&conversations).Error
+	return conversations, err
+}
+
+// Delete removes a conversation along with its messages and read receipts
+func (s *Conversations) Delete(id string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", id).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", id).Delete(&models.ReadReceipt{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.Conversation{}).Error
+	})
+}
+
+// Messages is a store.MessageStore
+type Messages struct {
+	db *gorm.DB
+}
+
+// Create stores a message and bumps its conversation's updated_at
+func (s *Messages) Create(message *models.Message) (bool, error) {
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Numbering takes the conversation row's lock until the message is
+		// stored, so its messages commit in seq order and a client syncing
+		// after a seq never misses one
+		result := tx.Raw("UPDATE conversations SET last_seq = last_seq + 1, updated_at = ? WHERE id = ? RETURNING last_seq",
+			message.CreatedAt, message.ConversationID).Scan(&message.Seq)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return store.ErrNotFound
+		}
+
+		// Concurrent retries race on the unique index, so let the database decide
+		result = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "conversation_id"}, {Name: "client_message_id"}},
+			DoNothing: true,
+		}).Create(message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Give the number back
+			return errRetried
+		}
+		return nil
+	})
+	if !errors.Is(err, errRetried) {
+		return err == nil, err
+	}
+
+	var existing models.Message
+	err = s.db.Where("conversation_id = ? AND client_message_id = ?", message.ConversationID, message.ClientMessageID).First(&existing).Error
+	if err != nil {
+		return false, translate(err)
+	}
+	*message = existing
+	return false, nil
+}
+
+// errRetried rolls back numbering a message that was already stored
+var errRetried = errors.New("message already stored")
+
+// ListByConversation returns a conversation's messages, oldest first
+func (s *Messages) ListByConversation(conversationID string) ([]models.Message, error) {
+	var messages []models.Message
+	err := s.db.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages).Error
+	return messages, err
+}
+
+// Get returns the conversation's message with the given ID
+func (s *Messages) Get(conversationID, id string) (models.Message, error) {
+	var message models.Message
+	err := s.db.Where("id = ? AND conversation_id = ?", id, conversationID).First(&message).Error
+	return message, translate(err)
+}
+
+// Latest returns the conversation's most recent message
+func (s *Messages) Latest(conversationID string) (models.Message, error) {
+	var message models.Message
+	err := s.db.Where("conversation_id = ?", conversationID).Order("seq DESC").First(&message).Error
+	return message, translate(err)
+}
+
+// ListAfterSeq returns up to limit of the conversation's messages after seq
+func (s *Messages) ListAfterSeq(conversationID string, seq int64, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := s.db.Where("conversation_id = ? AND seq > ?", conversationID, seq).Order("seq ASC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// ListAfterTime returns up to limit of the conversation's messages sent after t
+func (s *Messages) ListAfterTime(conversationID string, t time.Time, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := s.db.Where("conversation_id = ? AND created_at > ?", conversationID, t).Order("seq ASC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// Count returns how many messages a conversation has
+func (s *Messages) Count(conversationID string) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&count).Error
+	return count, err
+}
+
+// CountUnread returns how many messages from the other side the participant
+// has not read yet, going by their read receipt
+func (s *Messages) CountUnread(conversationID, participantID string, isOwner bool) (int64, error) {
+	var receipt models.ReadReceipt
+	err := s.db.Select("last_read_seq").Where("conversation_id = ? AND participant_id = ?", conversationID, participantID).First(&receipt).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var count int64
+	err = s.db.Model(&models.Message{}).
+		Where("conversation_id = ? AND is_owner = ? AND seq > ?", conversationID, !isOwner, receipt.LastReadSeq).
+		Count(&count).Error
+	return count, err
+}
+
+// ReadReceipts is a store.ReadReceiptStore
+type ReadReceipts struct {
+	db *gorm.DB
+}
+
+// Advance inserts or moves forward the participant's read receipt
+func (s *ReadReceipts) Advance(receipt *models.ReadReceipt) (bool, error) {
+	result := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}, {Name: "participant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "last_read_seq", "read_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "read_receipts.last_read_seq < excluded.last_read_seq"},
+		}},
+	}).Create(receipt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	// Return what is stored, which may be further along than receipt
+	err := s.db.Where("conversation_id = ? AND participant_id = ?", receipt.ConversationID, receipt.ParticipantID).First(receipt).Error
+	return result.RowsAffected > 0, translate(err)
+}
+
+// RefreshTokens is a store.RefreshTokenStore
+type RefreshTokens struct {
+	db *gorm.DB
+}
+
+// Create stores a new refresh token
+func (s *RefreshTokens) Create(token *models.RefreshToken) error {
+	return translate(s.db.Create(token).Error)
+}
+
+// GetByHash finds a refresh token by the hash of its value
+func (s *RefreshTokens) GetByHash(tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := s.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	return token, translate(err)
+}
+
+// Revoke revokes a refresh token that is not revoked yet
+func (s *RefreshTokens) Revoke(id string, revokedAt time.Time) error {
+	result := s.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", revokedAt)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return result.Error
+}
+
+// RevokeFamily revokes every token of a session
+func (s *RefreshTokens) RevokeFamily(familyID string, revokedAt time.Time) error {
+	return s.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// RevokeForUser revokes every token of every session of the user
+func (s *RefreshTokens) RevokeForUser(userID string, revokedAt time.Time) error {
+	return s.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// UserTokens is a store.UserTokenStore
+type UserTokens struct {
+	db *gorm.DB
+}
+
+// Create stores a new user token
+func (s *UserTokens) Create(token *models.UserToken) error {
+	return translate(s.db.Create(token).Error)
+}
+
+// Use marks an unused, unexpired token as used and returns it. The checks run
+// in the UPDATE so that concurrent requests cannot both use the token.
+func (s *UserTokens) Use(purpose, tokenHash string, now time.Time) (models.UserToken, error) {
+	var tokens []models.UserToken
+	err := s.db.Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, now).
+		Update("used_at", now).Error
+	if err != nil {
+		return models.UserToken{}, err
+	}
+	if len(tokens) == 0 {
+		return models.UserToken{}, store.ErrNotFound
+	}
+	return tokens[0], nil
+}
+
+// ResetPassword uses a password reset token and applies the reset in one transaction
+func (s *UserTokens) ResetPassword(tokenHash, passwordHash string, now time.Time) (models.UserToken, error) {
+	var token models.UserToken
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if token, err = (&UserTokens{tx}).Use(models.TokenPasswordReset, tokenHash, now); err != nil {
+			return err
+		}
+
+		users := &Users{tx}
+		if err := users.UpdatePassword(token.UserID, passwordHash); err != nil {
+			return err
+		}
+		// Whoever knew the old password is logged out
+		if _, err := users.RevokeTokens(token.UserID); err != nil {
+			return err
+		}
+		if err := (&RefreshTokens{tx}).RevokeForUser(token.UserID, now); err != nil {
+			return err
+		}
+		// The reset link arrived, so the user owns the address
+		return users.MarkEmailVerified(token.UserID, now)
+	})
+	return token, err
+}
+
+// InvalidateForUser marks the user's unused tokens for purpose as used
+func (s *UserTokens) InvalidateForUser(userID, purpose string, now time.Time) error {
+	return s.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error
+}
@@ -0,0 +1,254 @@
+// Package store defines how handlers and middleware read and write users,
+// portals, teams, categories, conversations, messages and account tokens, so
+// that they do not depend on a particular database. store/postgres implements the stores on top of GORM
+// and PostgreSQL; store/memory keeps everything in maps, which lets handler
+// behavior be exercised with httptest and no database.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"server/database/models"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a record would break a uniqueness rule, such
+// as a second user with the same email
+var ErrDuplicate = errors.New("record already exists")
+
+// Stores groups the stores handlers use
+type Stores struct {
+	Users         UserStore
+	Portals       PortalStore
+	Members       MemberStore
+	Invitations   InvitationStore
+	Categories    CategoryStore
+	Conversations ConversationStore
+	Messages      MessageStore
+	ReadReceipts  ReadReceiptStore
+	RefreshTokens RefreshTokenStore
+	UserTokens    UserTokenStore
+}
+
+// UserStore persists support team accounts
+type UserStore interface {
+	GetByID(id string) (models.User, error)
+	GetByEmail(email string) (models.User, error)
+	// Create stores a new user, returning ErrDuplicate if the email is taken
+	Create(user *models.User) error
+	// SelectPortal stores the portal the user's dashboard works on
+	SelectPortal(userID, portalID string) error
+	// RevokeTokens bumps the user's token version, so that access tokens
+	// issued before stop working
+	RevokeTokens(userID string) (tokenVersion int, err error)
+	UpdatePassword(userID, passwordHash string) error
+	// MarkEmailVerified records when the user verified their email, keeping
+	// the first time if they did so before
+	MarkEmailVerified(userID string, verifiedAt time.Time) error
+}
+
+// PortalStore persists portals and their team memberships
+type PortalStore interface {
+	GetByID(id string) (models.Portal, error)
+	GetByCustomName(customName string) (models.Portal, error)
+	// GetByOldCustomName finds a portal by a custom name it used before a rename
+	GetByOldCustomName(customName string) (models.Portal, error)
+	// ListForUser returns the portals the user owns or is a member of
+	ListForUser(userID string) ([]models.Portal, error)
+	CountOwnedBy(userID string) (int64, error)
+	NameTaken(name string) (bool, error)
+	// CustomNameTaken reports whether a portal other than exceptPortalID uses
+	// customName, either currently or as a name kept for redirects
+	CustomNameTaken(customName, exceptPortalID string) (bool, error)
+	// Create stores a portal along with its owner's membership
+	Create(portal *models.Portal) error
+	// Update stores a portal's settings
+	Update(portal *models.Portal) error
+	// Rename stores a portal under its new name and custom name, keeping
+	// oldCustomName so that links using it redirect
+	Rename(portal *models.Portal, oldCustomName string) error
+	// MemberRole returns the user's role on the portal's team, or ErrNotFound.
+	// A portal's owner is not necessarily a member; callers check OwnerID first.
+	MemberRole(portalID, userID string) (string, error)
+}
+
+// MemberStore persists the teams of portals
+type MemberStore interface {
+	// ListByPortal returns a portal's members with their users loaded,
+	// longest-standing first
+	ListByPortal(portalID string) ([]models.PortalMember, error)
+	// GetByID returns the portal's member with the given ID
+	GetByID(portalID, id string) (models.PortalMember, error)
+	UpdateRole(id, role string) error
+	Delete(id string) error
+	// HasEmail reports whether the user with the given lower-cased email is
+	// on the portal's team
+	HasEmail(portalID, email string) (bool, error)
+}
+
+// InvitationStore persists invitations to join a portal's team
+type InvitationStore interface {
+	// ListPending returns a portal's invitations that are neither accepted nor
+	// expired at now, newest first
+	ListPending(portalID string, now time.Time) ([]models.PortalInvitation, error)
+	Create(invitation *models.PortalInvitation) error
+	// Delete removes a pending invitation of the portal, returning ErrNotFound
+	// if there is none
+	Delete(portalID, id string) error
+	// GetPending finds the invitation with the given token hash, as long as it
+	// is neither accepted nor expired at now
+	GetPending(tokenHash string, now time.Time) (models.PortalInvitation, error)
+	// Accept marks an invitation as accepted and gives the user its role on
+	// the portal, never lowering a role they already have. It returns
+	// ErrNotFound if the invitation was accepted in the meantime.
+	Accept(invitation models.PortalInvitation, userID string, now time.Time) (models.PortalMember, error)
+}
+
+// CategoryStore persists the categories of portals
+type CategoryStore interface {
+	// ListByPortal returns a portal's categories in display order
+	ListByPortal(portalID string) ([]models.Category, error)
+	// CountActive returns how many active (open or pending) conversations the
+	// portal has per category ID
+	CountActive(portalID string) (map[string]int64, error)
+	// Find returns the portal's category with the given ID or slug
+	Find(portalID, ref string) (models.Category, error)
+	GetBySlug(portalID, slug string) (models.Category, error)
+	// GetByOldSlug finds the portal's category by a slug it used before a rename
+	GetByOldSlug(portalID, slug string) (models.Category, error)
+	// SlugTaken reports whether a category of the portal other than exceptID
+	// uses slug, either currently or as a slug kept for old links
+	SlugTaken(portalID, slug, exceptID string) (bool, error)
+	Create(category *models.Category) error
+	// Ensure stores category unless the portal has one with its slug already,
+	// and loads the stored one into category. Concurrent callers end up with
+	// the same category.
+	Ensure(category *models.Category) error
+	// Update stores a category, keeping the category name and slug stored on
+	// its conversations in step. When the slug changed, oldSlug is kept so
+	// that links using it keep working.
+	Update(category *models.Category, oldSlug string) error
+	// Delete removes a category along with its conversations, their messages,
+	// read receipts and old slugs
+	Delete(id string) error
+	// SlugSource returns the name a category slug was first made from, or
+	// ErrNotFound if it was never made here
+	SlugSource(slug string) (string, error)
+}
+
+// ConversationFilter narrows the conversations listed for a portal
+type ConversationFilter struct {
+	// Statuses keeps conversations in one of these statuses; nil keeps all
+	Statuses []string
+	// AssigneeID keeps conversations assigned to this user
+	AssigneeID string
+	// Unassigned keeps conversations nobody is assigned to
+	Unassigned bool
+}
+
+// ConversationStore persists conversations
+type ConversationStore interface {
+	GetByID(id string) (models.Conversation, error)
+	// GetByCode finds a conversation by its unique code, with the public
+	// fields of its portal loaded
+	GetByCode(code string) (models.Conversation, error)
+	// GetByURL finds a conversation by the parts of its link, with the public
+	// fields of its portal loaded
+	GetByURL(portalID, categorySlug, code string) (models.Conversation, error)
+	// Create stores a new conversation, returning ErrDuplicate if its
+	// UniqueCode is taken
+	Create(conversation *models.Conversation) error
+	UpdateCustomer(id, customerID, customerName string) error
+	// Claim hands an unclaimed conversation to a customer, counts a use of
+	// its link and bumps its customer token version. It returns ErrNotFound,
+	// changing nothing, if the conversation does not exist, was already
+	// claimed or its link is not active at now.
+	Claim(id, customerID, customerName string, now time.Time) (tokenVersion int, err error)
+	// RevokeCustomerTokens bumps the customer token version, so that tokens
+	// issued before stop working
+	RevokeCustomerTokens(id string) (tokenVersion int, err error)
+	// RevokeLink turns the conversation's link off
+	RevokeLink(id string, revokedAt time.Time) error
+	UpdateStatus(id, status string, snoozedUntil, resolvedAt *time.Time) error
+	// WakeSnoozed reopens every snoozed conversation whose snooze ran out by
+	// now and returns them
+	WakeSnoozed(now time.Time) ([]models.Conversation, error)
+	// Assign stores a conversation's assignee; nil unassigns. Assigning also
+	// records the time on the assignee's membership, for round-robin routing.
+	Assign(id, portalID string, assigneeID *string, assignedAt time.Time) error
+	// AutoAssign routes an unassigned conversation to the team member that
+	// mode (round robin or least busy) picks, and returns their user ID. It
+	// returns "" if the conversation is assigned already or nobody can take it.
+	AutoAssign(id, portalID, mode string, assignedAt time.Time) (string, error)
+	// ListByPortal returns a portal's conversations, most recently updated first
+	ListByPortal(portalID string, filter ConversationFilter) ([]models.Conversation, error)
+	// Delete removes a conversation along with its messages and read receipts
+	Delete(id string) error
+}
+
+// MessageStore persists the messages of conversations
+type MessageStore interface {
+	// Create stores a message and bumps its conversation's updated_at. Messages
+	// get the next seq of their conversation, and are stored in seq order.
+	// When the message carries a ClientMessageID already used in the
+	// conversation, nothing is stored: message is replaced by the original and
+	// created is false.
+	Create(message *models.Message) (created bool, err error)
+	// ListByConversation returns a conversation's messages, oldest first
+	ListByConversation(conversationID string) ([]models.Message, error)
+	// Get returns the conversation's message with the given ID
+	Get(conversationID, id string) (models.Message, error)
+	// Latest returns the conversation's most recent message
+	Latest(conversationID string) (models.Message, error)
+	// ListAfterSeq returns up to limit of the conversation's messages that
+	// come after seq, in order
+	ListAfterSeq(conversationID string, seq int64, limit int) ([]models.Message, error)
+	// ListAfterTime returns up to limit of the conversation's messages sent
+	// after t, in order
+	ListAfterTime(conversationID string, t time.Time, limit int) ([]models.Message, error)
+	Count(conversationID string) (int64, error)
+	// CountUnread returns how many messages from the other side the participant
+	// has not read yet
+	CountUnread(conversationID, participantID string, isOwner bool) (int64, error)
+}
+
+// ReadReceiptStore persists how far participants have read conversations
+type ReadReceiptStore interface {
+	// Advance stores receipt unless the participant's stored receipt is as far
+	// along already, and loads the stored one into receipt. It reports whether
+	// the stored receipt moved.
+	Advance(receipt *models.ReadReceipt) (bool, error)
+}
+
+// RefreshTokenStore persists the refresh tokens of support users' sessions
+type RefreshTokenStore interface {
+	Create(token *models.RefreshToken) error
+	// GetByHash finds a refresh token by the hash of its value
+	GetByHash(tokenHash string) (models.RefreshToken, error)
+	// Revoke revokes a single token. It returns ErrNotFound if the token does
+	// not exist or was revoked already, so that only one caller can rotate it.
+	Revoke(id string, revokedAt time.Time) error
+	// RevokeFamily revokes every token of a session
+	RevokeFamily(familyID string, revokedAt time.Time) error
+	// RevokeForUser revokes every token of every session of the user
+	RevokeForUser(userID string, revokedAt time.Time) error
+}
+
+// UserTokenStore persists the one-off tokens mailed to users
+type UserTokenStore interface {
+	Create(token *models.UserToken) error
+	// Use marks the token with the given purpose and hash as used and returns
+	// it. It returns ErrNotFound if there is no such token, or it was used
+	// already or has expired, so that each token works once.
+	Use(purpose, tokenHash string, now time.Time) (models.UserToken, error)
+	// ResetPassword uses the password reset token with the given hash and, in
+	// the same transaction, sets the user's new password, logs out every
+	// session and marks the email as verified. Nothing changes if any step
+	// fails. It returns ErrNotFound like Use.
+	ResetPassword(tokenHash, passwordHash string, now time.Time) (models.UserToken, error)
+	// InvalidateForUser marks the user's unused tokens for purpose as used
+	InvalidateForUser(userID, purpose string, now time.Time) error
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"server/config"
+)
+
+// Limits on the conversation code settings. Codes are stored in a VARCHAR(32)
+// column and must stay hard to guess.
+const (
+	minCodeLength       = 6
+	maxCodeLength       = 32
+	minCodeAlphabetSize = 16
+)
+
+// GenerateCode returns a random code of length characters drawn from alphabet
+// using crypto/rand. Every character is equally likely.
+func GenerateCode(alphabet string, length int) (string, error) {
+	symbols := []rune(alphabet)
+	if len(symbols) == 0 || length <= 0 {
+		return "", errors.New("code alphabet and length must not be empty")
+	}
+
+	max := big.NewInt(int64(len(symbols)))
+	code := make([]rune, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = symbols[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NewConversationCode returns a code for a conversation link, using the
+// configured alphabet and length. Uniqueness is left to the database.
+func NewConversationCode() (string, error) {
+	cfg := config.LoadConfig()
+	if err := ValidateCodeSettings(cfg.ConversationCodeAlphabet, cfg.ConversationCodeLength); err != nil {
+		return "", err
+	}
+	return GenerateCode(cfg.ConversationCodeAlphabet, cfg.ConversationCodeLength)
+}
+
+// ValidateCodeSettings checks that an alphabet and length give codes that fit
+// the database column and cannot practically be guessed
+func ValidateCodeSettings(alphabet string, length int) error {
+	if length < minCodeLength || length > maxCodeLength {
+		return fmt.Errorf("code length must be between %d and %d, got %d", minCodeLength, maxCodeLength, length)
+	}
+
+	seen := map[rune]bool{}
+	for _, r := range alphabet {
+		if seen[r] {
+			return fmt.Errorf("code alphabet repeats %q", r)
+		}
+		if !urlSafe(r) {
+			return fmt.Errorf("code alphabet contains %q, which does not belong in a URL", r)
+		}
+		seen[r] = true
+	}
+	if len(seen) < minCodeAlphabetSize {
+		return fmt.Errorf("code alphabet needs at least %d characters, got %d", minCodeAlphabetSize, len(seen))
+	}
+	return nil
+}
+
+// urlSafe reports whether r is an unreserved URL character (RFC 3986), which
+// appears in a link path as it is
+func urlSafe(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return r == '-' || r == '.' || r == '_' || r == '~'
+}
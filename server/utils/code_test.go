@@ -0,0 +1,82 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"server/config"
+	"server/utils"
+)
+
+func TestValidateCodeSettings(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		alphabet string
+		length   int
+		ok       bool
+	}{
+		{"default settings", config.DefaultConversationCodeAlphabet, 10, true},
+		{"shortest length", config.DefaultConversationCodeAlphabet, 6, true},
+		{"longest length", config.DefaultConversationCodeAlphabet, 32, true},
+		{"too short", config.DefaultConversationCodeAlphabet, 5, false},
+		{"too long", config.DefaultConversationCodeAlphabet, 33, false},
+		{"sixteen characters", "0123456789abcdef", 10, true},
+		{"fifteen characters", "0123456789abcde", 10, false},
+		{"duplicate character", "0123456789abcdefa", 10, false},
+		{"unreserved punctuation", "0123456789abcdef-._~", 10, true},
+		{"slash", "0123456789abcdef/", 10, false},
+		{"question mark", "0123456789abcdef?", 10, false},
+		{"hash", "0123456789abcdef#", 10, false},
+		{"percent", "0123456789abcdef%", 10, false},
+		{"plus", "0123456789abcdef+", 10, false},
+		{"space", "0123456789abcdef ", 10, false},
+		{"non-ASCII letter", "0123456789abcdefé", 10, false},
+		{"non-Latin script", "0123456789abcdefगघ", 10, false},
+	} {
+		err := utils.ValidateCodeSettings(tc.alphabet, tc.length)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: ValidateCodeSettings(%q, %d) = %v, want ok %v", tc.name, tc.alphabet, tc.length, err, tc.ok)
+		}
+	}
+}
+
+func TestGenerateCodeUsesOnlyTheAlphabet(t *testing.T) {
+	for _, tc := range []struct {
+		alphabet string
+		length   int
+	}{
+		{config.DefaultConversationCodeAlphabet, 6},
+		{config.DefaultConversationCodeAlphabet, 32},
+		{"0123456789abcdef", 10},
+		{"ab", 64},
+	} {
+		seen := map[rune]bool{}
+		for i := 0; i < 50; i++ {
+			code, err := utils.GenerateCode(tc.alphabet, tc.length)
+			if err != nil {
+				t.Fatalf("GenerateCode(%q, %d): %v", tc.alphabet, tc.length, err)
+			}
+			if len([]rune(code)) != tc.length {
+				t.Fatalf("code %q has %d characters, want %d", code, len([]rune(code)), tc.length)
+			}
+			for _, r := range code {
+				if !strings.ContainsRune(tc.alphabet, r) {
+					t.Fatalf("code %q uses %q, which is not in %q", code, r, tc.alphabet)
+				}
+				seen[r] = true
+			}
+		}
+		if len(seen) < 2 {
+			t.Errorf("codes from %q only ever used %v", tc.alphabet, seen)
+		}
+	}
+
+	for _, length := range []int{0, -1} {
+		if _, err := utils.GenerateCode(config.DefaultConversationCodeAlphabet, length); err == nil {
+			t.Errorf("GenerateCode accepted length %d", length)
+		}
+	}
+	if _, err := utils.GenerateCode("", 10); err == nil {
+		t.Error("GenerateCode accepted an empty alphabet")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -8,26 +9,41 @@ import (
 	"server/config"
 )
 
+// customerTokenType marks tokens issued to customers rather than support users
+const customerTokenType = "customer"
+
 // UserClaims represents the claims in a JWT token
 type UserClaims struct {
 	ID    string `json:"id"`
 	Email string `json:"email"`
 	Name  string `json:"name"`
+	// Version must match the user's TokenVersion for the token to be accepted
+	Version int `json:"ver"`
+}
+
+// CustomerClaims represents the claims in a customer conversation token
+type CustomerClaims struct {
+	ConversationID string `json:"conversationId"`
+	CustomerID     string `json:"customerId"`
+	// Version must match the conversation's CustomerTokenVersion for the token to be accepted
+	Version int `json:"ver"`
 }
 
-// GenerateToken creates a new JWT token for the given user
-func GenerateToken(userID, userEmail, userName string) (string, error) {
+// GenerateToken creates a short-lived access token for the given user, valid
+// for as long as the user's token version stays at version
+func GenerateToken(userID, userEmail, userName string, version int) (string, error) {
 	// Load configuration
 	cfg := config.LoadConfig()
 
 	// Set token expiration time
-	expirationTime := time.Now().Add(cfg.JWTExpiration)
+	expirationTime := time.Now().Add(cfg.AccessTokenExpiration)
 
 	// Create the claims
 	claims := jwt.MapClaims{
 		"id":    userID,
 		"email": userEmail,
 		"name":  userName,
+		"ver":   version,
 		"exp":   expirationTime.Unix(),
 	}
 
@@ -41,4 +57,79 @@ func GenerateToken(userID, userEmail, userName string) (string, error) {
 	}
 
 	return tokenString, nil
-}
\ No newline at end of file
+}
+
+// ParseToken validates a signed token and returns its claims
+func ParseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the algorithm
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("Invalid token signing method")
+		}
+
+		// Return the secret key
+		cfg := config.LoadConfig()
+		return []byte(cfg.JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+
+	return claims, nil
+}
+
+// GenerateCustomerToken creates a token that lets a customer access a single
+// conversation, for as long as the conversation's token version stays at version
+func GenerateCustomerToken(conversationID, customerID string, version int) (string, error) {
+	// Load configuration
+	cfg := config.LoadConfig()
+
+	// Create the claims
+	claims := jwt.MapClaims{
+		"typ":            customerTokenType,
+		"conversationId": conversationID,
+		"customerId":     customerID,
+		"ver":            version,
+		"exp":            time.Now().Add(cfg.CustomerTokenExpiration).Unix(),
+	}
+
+	// Create and sign the token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.JWTSecret))
+}
+
+// ParseCustomerToken validates a customer token and returns its claims
+func ParseCustomerToken(tokenString string) (*CustomerClaims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ, _ := claims["typ"].(string); typ != customerTokenType {
+		return nil, errors.New("Not a customer token")
+	}
+
+	conversationID, _ := claims["conversationId"].(string)
+	if conversationID == "" {
+		return nil, errors.New("Invalid conversation ID in token")
+	}
+
+	customerID, _ := claims["customerId"].(string)
+
+	// Numbers in JWT claims are decoded as float64
+	version, _ := claims["ver"].(float64)
+	if version < 1 {
+		return nil, errors.New("Invalid token version")
+	}
+
+	return &CustomerClaims{
+		ConversationID: conversationID,
+		CustomerID:     customerID,
+		Version:        int(version),
+	}, nil
+}
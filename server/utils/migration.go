@@ -1,30 +1,47 @@
 package utils
 
 import (
-	"fmt"
 	"log"
-	//"strings"
 	"time"
 
-	"server/database"
+	"gorm.io/gorm"
+
+	"server/database/models"
+	"server/slug"
 )
 
-// MigrateData updates existing data to support the new URL structure
-func MigrateData() {
+// MigrateData backfills data written before the URL structure, categories,
+// portal members and slug lookups existed. It runs once, as a migration.
+func MigrateData(tx *gorm.DB) error {
 	log.Println("Starting data migration for new URL structure...")
 	startTime := time.Now()
 	
-	// Migrate portals - add custom_name to portals without it
-	migratePortals()
-	
-	// Migrate conversations - add category_slug to conversations without it
-	migrateConversations()
+	steps := []func(tx *gorm.DB) error{
+		// Migrate portals - add custom_name to portals without it
+		migratePortals,
+		// Migrate conversations - add category_slug to conversations without it
+		migrateConversations,
+		// Migrate categories - create category rows for categories only known from conversations
+		migrateCategories,
+		// Migrate placeholders - resolve the conversations that only stood in for a category
+		migratePlaceholders,
+		// Migrate portal members - give every portal owner an owner membership
+		migratePortalMembers,
+		// Migrate slug lookups - remember the names existing slugs were made from
+		migrateSlugLookups,
+	}
+	for _, step := range steps {
+		if err := step(tx); err != nil {
+			return err
+		}
+	}
 	
 	log.Printf("Migration completed in %v\n", time.Since(startTime))
+	return nil
 }
 
 // migratePortals adds custom_name to portals that don't have one
-func migratePortals() {
+func migratePortals(tx *gorm.DB) error {
 	type Portal struct {
 		ID         string
 		Name       string
@@ -32,43 +49,36 @@ func migratePortals() {
 	}
 	
 	var portals []Portal
-	result := database.DB.Table("portals").Where("custom_name = '' OR custom_name IS NULL").Find(&portals)
+	result := tx.Table("portals").Where("custom_name = '' OR custom_name IS NULL").Find(&portals)
 	
 	if result.Error != nil {
 		log.Printf("Error fetching portals for migration: %v\n", result.Error)
-		return
+		return result.Error
 	}
 	
 	log.Printf("Found %d portals that need custom name migration\n", len(portals))
 	
 	for i, portal := range portals {
-		customName := Slugify(portal.Name)
-		
-		// Check if this custom name already exists
-		var count int64
-		checkResult := database.DB.Table("portals").Where("custom_name = ?", customName).Count(&count)
-		if checkResult.Error != nil {
-			log.Printf("Error checking existing custom name: %v\n", checkResult.Error)
-			continue
-		}
-		
-		// If the custom name already exists, append a random code to make it unique
-		if count > 0 {
-			customName = fmt.Sprintf("%s-%s", customName, GenerateRandomCode())
-		}
+		// If the custom name already exists, append a numbered suffix to make it unique
+		customName := slug.Unique(slug.Make(portal.Name), func(candidate string) bool {
+			var count int64
+			tx.Table("portals").Where("custom_name = ?", candidate).Count(&count)
+			return count > 0
+		})
 		
 		// Update the portal with the new custom name
-		updateResult := database.DB.Table("portals").Where("id = ?", portal.ID).Update("custom_name", customName)
+		updateResult := tx.Table("portals").Where("id = ?", portal.ID).Update("custom_name", customName)
 		if updateResult.Error != nil {
 			log.Printf("Error updating portal %s: %v\n", portal.ID, updateResult.Error)
-		} else {
-			log.Printf("Updated portal %d/%d: %s -> %s\n", i+1, len(portals), portal.Name, customName)
+			return updateResult.Error
 		}
+		log.Printf("Updated portal %d/%d: %s -> %s\n", i+1, len(portals), portal.Name, customName)
 	}
+	return nil
 }
 
 // migrateConversations adds category_slug to conversations that don't have one
-func migrateConversations() {
+func migrateConversations(tx *gorm.DB) error {
 	type Conversation struct {
 		ID           string
 		Category     string
@@ -76,24 +86,155 @@ func migrateConversations() {
 	}
 	
 	var conversations []Conversation
-	result := database.DB.Table("conversations").Where("category_slug = '' OR category_slug IS NULL").Find(&conversations)
+	result := tx.Table("conversations").Where("category_slug = '' OR category_slug IS NULL").Find(&conversations)
 	
 	if result.Error != nil {
 		log.Printf("Error fetching conversations for migration: %v\n", result.Error)
-		return
+		return result.Error
 	}
 	
 	log.Printf("Found %d conversations that need category slug migration\n", len(conversations))
 	
 	for i, conversation := range conversations {
-		categorySlug := Slugify(conversation.Category)
+		categorySlug := slug.Make(conversation.Category)
 		
 		// Update the conversation with the new category slug
-		updateResult := database.DB.Table("conversations").Where("id = ?", conversation.ID).Update("category_slug", categorySlug)
+		updateResult := tx.Table("conversations").Where("id = ?", conversation.ID).Update("category_slug", categorySlug)
 		if updateResult.Error != nil {
 			log.Printf("Error updating conversation %s: %v\n", conversation.ID, updateResult.Error)
-		} else {
-			log.Printf("Updated conversation %d/%d: %s -> %s\n", i+1, len(conversations), conversation.Category, categorySlug)
+			return updateResult.Error
+		}
+		log.Printf("Updated conversation %d/%d: %s -> %s\n", i+1, len(conversations), conversation.Category, categorySlug)
+	}
+	return nil
+}
+
+// migrateCategories turns the categories that only existed as placeholder
+// conversations into category rows and links every conversation to its row.
+// The placeholders themselves are dealt with by migratePlaceholders.
+func migrateCategories(tx *gorm.DB) error {
+	type categoryInfo struct {
+		PortalID     string
+		CategorySlug string
+		Category     string
+		CreatedAt    time.Time
+	}
+	
+	var found []categoryInfo
+	result := tx.Table("conversations").
+		Select("portal_id, category_slug, MIN(category) AS category, MIN(created_at) AS created_at").
+		Where("category_id IS NULL AND category_slug != ''").
+		Group("portal_id, category_slug").
+		Scan(&found)
+	
+	if result.Error != nil {
+		log.Printf("Error fetching categories for migration: %v\n", result.Error)
+		return result.Error
+	}
+	
+	log.Printf("Found %d categories that need category row migration\n", len(found))
+	
+	for i, info := range found {
+		var count int64
+		tx.Model(&models.Category{}).Where("portal_id = ? AND slug = ?", info.PortalID, info.CategorySlug).Count(&count)
+		if count > 0 {
+			continue
 		}
+		
+		category := models.Category{
+			PortalID:  info.PortalID,
+			Name:      info.Category,
+			Slug:      info.CategorySlug,
+			CreatedAt: info.CreatedAt,
+		}
+		if err := tx.Create(&category).Error; err != nil {
+			log.Printf("Error creating category %s for portal %s: %v\n", info.CategorySlug, info.PortalID, err)
+			return err
+		}
+		log.Printf("Created category %d/%d: %s (portal %s)\n", i+1, len(found), category.Name, info.PortalID)
+	}
+	
+	// Point each conversation at the category row with its slug
+	updateResult := tx.Exec(`UPDATE conversations SET category_id = categories.id
+		FROM categories
+		WHERE conversations.category_id IS NULL
+		AND categories.portal_id = conversations.portal_id
+		AND categories.slug = conversations.category_slug`)
+	if updateResult.Error != nil {
+		log.Printf("Error linking conversations to categories: %v\n", updateResult.Error)
+		return updateResult.Error
+	}
+	log.Printf("Linked %d conversations to their categories\n", updateResult.RowsAffected)
+	return nil
+}
+
+// migratePlaceholders resolves the conversations nobody has claimed or written
+// in. Categories used to exist only as such placeholders, and generated links
+// look the same, so they are not deleted, as their links may have been shared.
+// Resolved, they stop counting as active work; a customer who claims one and
+// writes reopens it.
+func migratePlaceholders(tx *gorm.DB) error {
+	result := tx.Table("conversations").
+		Where("customer_id = ? AND status IN ?", models.UnclaimedCustomerID, models.ActiveStatuses).
+		Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.conversation_id = conversations.id)").
+		Updates(map[string]interface{}{
+			"status":      models.ConversationResolved,
+			"resolved_at": time.Now(),
+		})
+	if result.Error != nil {
+		log.Printf("Error resolving placeholder conversations: %v\n", result.Error)
+		return result.Error
+	}
+	log.Printf("Resolved %d placeholder conversations\n", result.RowsAffected)
+	return nil
+}
+
+// migratePortalMembers adds an owner membership for portal owners that do not have one
+func migratePortalMembers(tx *gorm.DB) error {
+	var portals []models.Portal
+	result := tx.Where("NOT EXISTS (SELECT 1 FROM portal_members WHERE portal_members.portal_id = portals.id AND portal_members.user_id = portals.owner_id)").Find(&portals)
+	
+	if result.Error != nil {
+		log.Printf("Error fetching portals for member migration: %v\n", result.Error)
+		return result.Error
 	}
-}
\ No newline at end of file
+	
+	log.Printf("Found %d portals that need an owner membership\n", len(portals))
+	
+	for i, portal := range portals {
+		member := models.PortalMember{
+			PortalID: portal.ID,
+			UserID:   portal.OwnerID,
+			Role:     models.RoleOwner,
+		}
+		if err := tx.Create(&member).Error; err != nil {
+			log.Printf("Error adding owner to portal %s: %v\n", portal.ID, err)
+			return err
+		}
+		log.Printf("Added owner membership %d/%d: portal %s\n", i+1, len(portals), portal.Name)
+	}
+	return nil
+}
+
+// migrateSlugLookups records the names behind the slugs of portals and
+// categories created before slug lookups existed
+func migrateSlugLookups(tx *gorm.DB) error {
+	result := tx.Exec(`INSERT INTO slug_lookups (scope, slug, source, created_at)
+		SELECT ?, custom_name, name, created_at FROM portals
+		WHERE custom_name != '' ON CONFLICT DO NOTHING`, models.SlugScopePortal)
+	if result.Error != nil {
+		log.Printf("Error migrating portal slug lookups: %v\n", result.Error)
+		return result.Error
+	}
+	log.Printf("Recorded %d portal slug lookups\n", result.RowsAffected)
+	
+	result = tx.Exec(`INSERT INTO slug_lookups (scope, slug, source, created_at)
+		SELECT ?, slug, MIN(name), MIN(created_at) FROM categories
+		WHERE slug != '' GROUP BY slug ON CONFLICT DO NOTHING`, models.SlugScopeCategory)
+	if result.Error != nil {
+		log.Printf("Error migrating category slug lookups: %v\n", result.Error)
+		return result.Error
+	}
+	log.Printf("Recorded %d category slug lookups\n", result.RowsAffected)
+	return nil
+}
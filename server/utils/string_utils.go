@@ -2,65 +2,26 @@ package utils
 
 import (
 	"strings"
+	"unicode"
 )
 
-// Slugify converts a string to a URL-friendly slug
-func Slugify(input string) string {
-	// Convert to lowercase
-	result := strings.ToLower(input)
-	
-	// Replace spaces with hyphens
-	result = strings.ReplaceAll(result, " ", "-")
-	
-	// Remove special characters
+// NameFromSlug turns a slug that was never generated here, such as one typed
+// into a URL, into a name by replacing hyphens with spaces and capitalizing
+// each word
+func NameFromSlug(slug string) string {
 	var sb strings.Builder
-	for _, r := range result {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
-			sb.WriteRune(r)
-		}
-	}
-	result = sb.String()
-	
-	// Remove consecutive hyphens
-	for strings.Contains(result, "--") {
-		result = strings.ReplaceAll(result, "--", "-")
-	}
-	
-	// Trim hyphens from start and end
-	result = strings.Trim(result, "-")
-	
-	// If the result is empty, use a fallback
-	if result == "" {
-		result = "untitled"
-	}
-	
-	return result
-}
-
-// Unslugify attempts to convert a slug back to a human-readable format
-// This is just a basic implementation and won't perfectly restore the original string
-func Unslugify(slug string) string {
-	// Replace hyphens with spaces and capitalize each word
-	result := ""
 	capitalize := true
-	
-	for _, char := range slug {
-		if char == '-' {
-			result += " "
+	for _, r := range slug {
+		if r == '-' {
+			sb.WriteByte(' ')
 			capitalize = true
-		} else {
-			if capitalize {
-				if char >= 'a' && char <= 'z' {
-					result += string(char - 32) // Convert to uppercase
-				} else {
-					result += string(char)
-				}
-				capitalize = false
-			} else {
-				result += string(char)
-			}
+			continue
 		}
+		if capitalize {
+			r = unicode.ToUpper(r)
+			capitalize = false
+		}
+		sb.WriteRune(r)
 	}
-	
-	return result
-}
\ No newline at end of file
+	return sb.String()
+}
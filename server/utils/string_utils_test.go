@@ -0,0 +1,22 @@
+package utils_test
+
+import (
+	"testing"
+
+	"server/utils"
+)
+
+func TestNameFromSlug(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"", ""},
+		{"billing", "Billing"},
+		{"billing-questions", "Billing Questions"},
+		{"tech-support-2", "Tech Support 2"},
+		{"über-uns", "Über Uns"},
+		{"नमस्ते-दुनिया", "नमस्ते दुनिया"},
+	} {
+		if got := utils.NameFromSlug(tc.in); got != tc.want {
+			t.Errorf("NameFromSlug(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}